@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"math/big"
 	"sync"
 
 	peer "github.com/libp2p/go-libp2p-core/peer"
@@ -13,8 +12,7 @@ import (
 type clientConfig struct {
 	lk       sync.Mutex
 	ctx      context.Context
-	seqId    int64 // Keeps track of my sequence ID
-	syncId   int64 // Determines seqID from last sync
+	syncId   int64 // Sequence number of the last message synced
 	client   protocol.SmartRecordClient
 	room     string
 	serverID peer.ID
@@ -29,14 +27,11 @@ type syncUpdate struct {
 
 // Genereates the data model for messages for the chat application
 func (e *clientConfig) generateChatMessage(msg string) xr.Dict {
-	e.lk.Lock()
-	// Increase seqID
-	e.seqId++
-	defer e.lk.Unlock()
-	// Message data
+	// Message data, keyed by a sequence number assigned by the server,
+	// so messages are ordered the same way for every participant.
 	d := xr.Dict{
 		Pairs: xr.Pairs{
-			xr.Pair{Key: xr.Int{Int: big.NewInt(e.seqId)}, Value: xr.String{Value: msg}},
+			xr.Pair{Key: xr.Predicate{Tag: "seq"}, Value: xr.String{Value: msg}},
 		},
 	}
 
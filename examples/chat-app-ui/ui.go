@@ -192,7 +192,7 @@ func (ui *chatUI) processSyncMessages(out *vm.RecordValue) {
 
 			// If message has a seqID below the one I keep, it means I haven't seen it
 			if i > ui.env.syncId {
-				// Add id as seqId to track and sync at the end
+				// Add id to track and sync at the end
 				ids = append(ids, int(i))
 				// Append the message for update
 				syncMsgs[i] = append(syncMsgs[i], &syncUpdate{nick, pv.Value.(xr.String).Value})
@@ -209,7 +209,6 @@ func (ui *chatUI) processSyncMessages(out *vm.RecordValue) {
 	// Once all the messages have been processed update sequenceIds.
 	if update {
 		ui.env.lk.Lock()
-		ui.env.seqId = tmpMax
 		ui.env.syncId = tmpMax
 		ui.env.lk.Unlock()
 	}
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"math/big"
 	"sort"
 	"sync"
 
@@ -16,8 +15,7 @@ import (
 type clientConfig struct {
 	lk       sync.Mutex
 	ctx      context.Context
-	seqId    int64 // Keeps track of my sequence ID
-	syncId   int64 // Determines seqID from last sync
+	syncId   int64 // Sequence number of the last message synced
 	client   protocol.SmartRecordClient
 	room     string
 	serverID peer.ID
@@ -32,14 +30,11 @@ type syncUpdate struct {
 
 // Genereates the data model of messages for the chat application
 func (c *clientConfig) generateChatMessage(msg string) xr.Dict {
-	c.lk.Lock()
-	// Increase seqID
-	c.seqId++
-	defer c.lk.Unlock()
-	// Message data
+	// Message data, keyed by a sequence number assigned by the server,
+	// so messages are ordered the same way for every participant.
 	d := xr.Dict{
 		Pairs: xr.Pairs{
-			xr.Pair{Key: xr.Int{Int: big.NewInt(c.seqId)}, Value: xr.String{Value: msg}},
+			xr.Pair{Key: xr.Predicate{Tag: "seq"}, Value: xr.String{Value: msg}},
 		},
 	}
 
@@ -93,7 +88,7 @@ func (c *clientConfig) processSyncMessages(out *vm.RecordValue, outCh chan strin
 
 			// If message has a seqID below the one I keep, it means I haven't seen it
 			if i > c.syncId {
-				// Add id as seqId to track and sync at the end
+				// Add id to track and sync at the end
 				ids = append(ids, int(i))
 				// Append the message for update
 				syncMsgs[i] = append(syncMsgs[i], &syncUpdate{nick, pv.Value.(xr.String).Value})
@@ -110,7 +105,6 @@ func (c *clientConfig) processSyncMessages(out *vm.RecordValue, outCh chan strin
 	// Once all the messages have been processed update sequenceIds.
 	if update {
 		c.lk.Lock()
-		c.seqId = tmpMax
 		c.syncId = tmpMax
 		c.lk.Unlock()
 	}
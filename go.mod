@@ -3,7 +3,9 @@ module github.com/libp2p/go-smart-record
 go 1.16
 
 require (
+	github.com/fxamacker/cbor/v2 v2.3.0
 	github.com/gogo/protobuf v1.3.2
+	github.com/ipfs/go-cid v0.0.7
 	github.com/ipfs/go-log v1.0.5
 	github.com/jbenet/goprocess v0.1.4
 	github.com/libp2p/go-libp2p v0.15.1
@@ -13,4 +15,6 @@ require (
 	github.com/libp2p/go-msgio v0.0.6
 	github.com/libp2p/go-routing-language v0.0.0-20210531170722-12dc033e88ac
 	github.com/multiformats/go-multiaddr v0.4.0
+	github.com/multiformats/go-multistream v0.2.2
+	github.com/prometheus/client_golang v1.11.0
 )
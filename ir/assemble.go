@@ -4,9 +4,11 @@ import (
 	"fmt"
 
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
 	xr "github.com/libp2p/go-routing-language/syntax"
 
 	meta "github.com/libp2p/go-smart-record/ir/metadata"
+	"github.com/libp2p/go-smart-record/trace"
 )
 
 // AssemblerContext holds general contextual data for the stage of the assembly process.
@@ -18,6 +20,27 @@ type AssemblerContext struct {
 	Grammar Assembler
 	Keys    map[string]interface{}
 	Host    host.Host
+	// Span traces the assembly. If set, the assembler that matched each node is
+	// added to it as an event.
+	Span trace.Span
+	// Strict fails the assembly if a predicate with the tag of a registered
+	// smart tag is not valid, instead of assembling it as a plain predicate.
+	// See TagRegistry.
+	Strict bool
+	// Tags is the registry of the smart tags whose lifecycle hooks are called
+	// by the VM. See TagRegistry.
+	Tags *TagRegistry
+	// Writer is the peer whose dict is being assembled, set by the VM so smart
+	// tags can check the data they hold belongs to it.
+	Writer peer.ID
+	// Exists reports whether a writer stores a dict in a key. It is set by the
+	// VM for the updates of writers, so smart tags can check the records they
+	// reference, but not when merging the state of replicas.
+	Exists func(k string, writer peer.ID) bool
+	// Merging is set by the VM when merging the state of replicas (see
+	// vm.Merge), so smart tags accept the forms resolved by the server that
+	// accepted the update (e.g. numbered logs), which writers cannot send.
+	Merging bool
 }
 
 func (ctx AssemblerContext) Assemble(src xr.Node, metadata ...meta.Metadata) (Node, error) {
@@ -43,13 +66,37 @@ type Assembler interface {
 type SequenceAssembler []Assembler
 
 func (asm SequenceAssembler) Assemble(ctx AssemblerContext, src xr.Node, metadata ...meta.Metadata) (Node, error) {
-	for _, a := range asm {
+	errs := make([]error, len(asm))
+	for i, a := range asm {
 		out, err := a.Assemble(ctx, src, metadata...)
+		errs[i] = err
+		// Fatal errors are not recovered by the rest of assemblers.
+		if isFatal(err) {
+			return nil, err
+		}
 		if err == nil {
+			// Nested sequences report the assembler that matched in them.
+			if _, nested := a.(SequenceAssembler); !nested && ctx.Span != nil {
+				ctx.Span.AddEvent("assembled", "assembler", fmt.Sprintf("%T", a), "node", describeNode(src), "attempts", i+1)
+			}
 			return out, nil
 		}
 	}
-	return nil, fmt.Errorf("no assembler in the sequence recognized the input")
+	// The reason of every assembler is reported in the error.
+	return nil, sequenceError(asm, errs)
+}
+
+// describeNode returns a short description of a syntactic node for traces.
+func describeNode(n xr.Node) string {
+	switch n1 := n.(type) {
+	case xr.Dict:
+		return fmt.Sprintf("dict(%d pairs)", len(n1.Pairs))
+	case xr.List:
+		return fmt.Sprintf("list(%d elements)", len(n1.Elements))
+	case xr.Predicate:
+		return fmt.Sprintf("predicate(%s)", n1.Tag)
+	}
+	return fmt.Sprintf("%T", n)
 }
 
 var SyntacticGrammar = SequenceAssembler{
@@ -160,11 +207,11 @@ func (asm DictAssembler) Assemble(ctx AssemblerContext, src xr.Node, metadata ..
 	for i, p := range s.Pairs {
 		k, err := ctx.Assemble(p.Key, metadata...)
 		if err != nil {
-			return nil, fmt.Errorf("key assembly (%v)", err)
+			return nil, atKey(err, keySegment(p.Key))
 		}
 		v, err := ctx.Assemble(p.Value, metadata...)
 		if err != nil {
-			return nil, fmt.Errorf("value assembly (%v)", err)
+			return nil, atPath(err, keySegment(p.Key))
 		}
 		d.Pairs[i] = Pair{Key: k, Value: v}
 	}
@@ -192,7 +239,7 @@ func (asm ListAssembler) Assemble(ctx AssemblerContext, src xr.Node, metadata ..
 	for i, e := range s.Elements {
 		ae, err := ctx.Assemble(e, metadata...)
 		if err != nil {
-			return nil, fmt.Errorf("element assembly (%v)", err)
+			return nil, atPath(err, indexSegment(i))
 		}
 		d.Elements[i] = ae
 	}
@@ -222,7 +269,7 @@ func (asm PredicateAssembler) Assemble(ctx AssemblerContext, src xr.Node, metada
 	for i, e := range s.Positional {
 		ae, err := ctx.Assemble(e, metadata...)
 		if err != nil {
-			return nil, fmt.Errorf("positional assembly (%v)", err)
+			return nil, atPath(err, indexSegment(i))
 		}
 		d.Positional[i] = ae
 	}
@@ -231,11 +278,11 @@ func (asm PredicateAssembler) Assemble(ctx AssemblerContext, src xr.Node, metada
 	for i, p := range s.Named {
 		k, err := ctx.Assemble(p.Key, metadata...)
 		if err != nil {
-			return nil, fmt.Errorf("key assembly (%v)", err)
+			return nil, atKey(err, keySegment(p.Key))
 		}
 		v, err := ctx.Assemble(p.Value, metadata...)
 		if err != nil {
-			return nil, fmt.Errorf("value assembly (%v)", err)
+			return nil, atPath(err, keySegment(p.Key))
 		}
 		d.Named[i] = Pair{Key: k, Value: v}
 	}
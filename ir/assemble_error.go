@@ -0,0 +1,115 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+)
+
+// AssemblyError is an error assembling a node of a document. It reports the
+// path to the node, and the reason each candidate assembler rejected it.
+type AssemblyError struct {
+	// Path to the node that failed from the root of the document, e.g.
+	// .profile.addrs[2]. Keys of dicts and named arguments of predicates
+	// are given as .key, and elements of lists and positional arguments
+	// of predicates as [i]. It is empty for the root of the document.
+	Path string
+	// Reason the node couldn't be assembled.
+	Reason string
+	// Rejections of each candidate assembler, if the node was assembled
+	// by a SequenceAssembler.
+	Rejections []Rejection
+	// Fatal errors fail the assembly of the document, without trying the
+	// rest of assemblers in a sequence (e.g. invalid smart tags in strict mode).
+	Fatal bool
+}
+
+// Rejection is the reason an assembler rejected a node.
+type Rejection struct {
+	Assembler string
+	Reason    string
+}
+
+func (e *AssemblyError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "."
+	}
+	if len(e.Rejections) == 0 {
+		return fmt.Sprintf("assembling %s: %s", path, e.Reason)
+	}
+	rs := make([]string, len(e.Rejections))
+	for i, r := range e.Rejections {
+		rs[i] = fmt.Sprintf("%s: %s", r.Assembler, r.Reason)
+	}
+	return fmt.Sprintf("assembling %s: %s (%s)", path, e.Reason, strings.Join(rs, "; "))
+}
+
+// atPath returns err as an AssemblyError of the node in the path segment
+// given, relative to the node being assembled.
+func atPath(err error, segment string) error {
+	var ae *AssemblyError
+	if errors.As(err, &ae) {
+		out := *ae
+		out.Path = segment + ae.Path
+		return &out
+	}
+	return &AssemblyError{Path: segment, Reason: err.Error()}
+}
+
+// isFatal returns true if err is a fatal AssemblyError.
+func isFatal(err error) bool {
+	var ae *AssemblyError
+	return errors.As(err, &ae) && ae.Fatal
+}
+
+// atKey returns the error assembling the key in the path segment given.
+func atKey(err error, segment string) error {
+	ae := atPath(err, segment).(*AssemblyError)
+	ae.Reason = "key: " + ae.Reason
+	return ae
+}
+
+// keySegment returns the path segment of the value of a key.
+func keySegment(k xr.Node) string {
+	if s, ok := k.(xr.String); ok {
+		return "." + s.Value
+	}
+	var w strings.Builder
+	if err := k.WritePretty(&w); err != nil {
+		return ".?"
+	}
+	return "." + w.String()
+}
+
+// indexSegment returns the path segment of the element in position i.
+func indexSegment(i int) string {
+	return fmt.Sprintf("[%d]", i)
+}
+
+// sequenceError returns the error of a SequenceAssembler given the errors of
+// each of its assemblers. If an assembler failed deeper in the document, it
+// recognized the input, so its error is returned. Otherwise, the rejection of
+// every assembler is reported, flattening nested sequences.
+func sequenceError(asm SequenceAssembler, errs []error) error {
+	out := &AssemblyError{Reason: "no assembler in the sequence recognized the input"}
+	for i, err := range errs {
+		var ae *AssemblyError
+		if errors.As(err, &ae) {
+			if ae.Path != "" {
+				return ae
+			}
+			if _, nested := asm[i].(SequenceAssembler); nested {
+				out.Rejections = append(out.Rejections, ae.Rejections...)
+				continue
+			}
+		}
+		out.Rejections = append(out.Rejections, Rejection{
+			Assembler: fmt.Sprintf("%T", asm[i]),
+			Reason:    err.Error(),
+		})
+	}
+	return out
+}
@@ -0,0 +1,136 @@
+package ir
+
+import (
+	"errors"
+	"testing"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// noIntsGrammar is a grammar that doesn't accept ints.
+var noIntsGrammar = SequenceAssembler{
+	DictAssembler{},
+	ListAssembler{},
+	SequenceAssembler{StringAssembler{}, PredicateAssembler{}},
+}
+
+func TestAssemblyErrorPath(t *testing.T) {
+	in := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "name"}, Value: xr.String{Value: "x"}},
+			xr.Pair{Key: xr.String{Value: "profile"}, Value: xr.Dict{
+				Pairs: xr.Pairs{
+					xr.Pair{Key: xr.String{Value: "addrs"}, Value: xr.List{
+						Elements: xr.Nodes{xr.String{Value: "a"}, xr.String{Value: "b"}, xr.NewInt64(3)},
+					}},
+				},
+			}},
+		},
+	}
+	_, err := AssemblerContext{Grammar: noIntsGrammar}.Assemble(in)
+	var ae *AssemblyError
+	if !errors.As(err, &ae) {
+		t.Fatalf("expecting an assembly error, got %v", err)
+	}
+	if ae.Path != ".profile.addrs[2]" {
+		t.Errorf("expecting path .profile.addrs[2], got %s", ae.Path)
+	}
+	// Nested sequences are flattened.
+	exp := []Rejection{
+		{"ir.DictAssembler", "not a dict"},
+		{"ir.ListAssembler", "not a List"},
+		{"ir.StringAssembler", "not a string"},
+		{"ir.PredicateAssembler", "not a predicate"},
+	}
+	if len(ae.Rejections) != len(exp) {
+		t.Fatalf("expecting rejections %v, got %v", exp, ae.Rejections)
+	}
+	for i := range exp {
+		if ae.Rejections[i] != exp[i] {
+			t.Errorf("expecting rejection %v, got %v", exp[i], ae.Rejections[i])
+		}
+	}
+
+	// Keys and arguments of predicates are reported.
+	in = xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.NewInt64(1), Value: xr.String{Value: "x"}},
+		},
+	}
+	_, err = AssemblerContext{Grammar: noIntsGrammar}.Assemble(in)
+	if !errors.As(err, &ae) || ae.Path != ".1" || ae.Reason != "key: no assembler in the sequence recognized the input" {
+		t.Errorf("wrong error assembling a key: %v", err)
+	}
+	in = xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "p"}, Value: xr.Predicate{
+				Tag:        "f",
+				Positional: xr.Nodes{xr.String{Value: "a"}},
+				Named:      xr.Pairs{xr.Pair{Key: xr.String{Value: "n"}, Value: xr.NewInt64(1)}},
+			}},
+		},
+	}
+	_, err = AssemblerContext{Grammar: noIntsGrammar}.Assemble(in)
+	if !errors.As(err, &ae) || ae.Path != ".p.n" {
+		t.Errorf("wrong error assembling a predicate: %v", err)
+	}
+}
+
+// namedAssembler assembles predicates with a named argument x as plain predicates.
+type namedAssembler struct{}
+
+func (namedAssembler) Assemble(ctx AssemblerContext, src xr.Node, metadata ...meta.Metadata) (Node, error) {
+	p, ok := src.(xr.Predicate)
+	if !ok || len(p.Named) != 1 || !xr.IsEqual(p.Named[0].Key, xr.String{Value: "x"}) {
+		return nil, errors.New("expecting a named argument x")
+	}
+	return PredicateAssembler{}.Assemble(ctx, src, metadata...)
+}
+
+func TestTagRegistry(t *testing.T) {
+	r := NewTagRegistry()
+	if err := r.Register(namedAssembler{}, "f", "g"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(namedAssembler{}, "h", "g"); err == nil {
+		t.Fatal("registering a tag twice should fail")
+	}
+	if _, ok := r.Lookup("h"); ok {
+		t.Fatal("tags of a failed registration should not be registered")
+	}
+	hook := func(HookContext, Node) (func(), func()) { return nil, nil }
+	if err := r.RegisterSmartTag(SmartTag{Tags: []string{"h"}, Assembler: namedAssembler{}, Periodic: hook}); err == nil {
+		t.Fatal("periodic hooks without a period should fail")
+	}
+	if len(r.SmartTags()) != 1 {
+		t.Fatal("wrong number of smart tags", r.SmartTags())
+	}
+	grammar := SequenceAssembler{r, SyntacticGrammar}
+
+	// A typo in the argument of a registered tag.
+	in := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "p"}, Value: xr.Predicate{
+				Tag:   "f",
+				Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "y"}, Value: xr.String{Value: "a"}}},
+			}},
+		},
+	}
+	// Lenient assembly keeps it as a plain predicate.
+	if _, err := (AssemblerContext{Grammar: grammar}).Assemble(in); err != nil {
+		t.Fatal(err)
+	}
+	// Strict assembly rejects it.
+	_, err := AssemblerContext{Grammar: grammar, Strict: true}.Assemble(in)
+	var ae *AssemblyError
+	if !errors.As(err, &ae) || ae.Path != ".p" || !ae.Fatal {
+		t.Fatal("expecting an invalid smart tag error", err)
+	}
+	// Predicates with tags not registered are not smart tags.
+	in.Pairs[0].Value = xr.Predicate{Tag: "unknown"}
+	if _, err := (AssemblerContext{Grammar: grammar, Strict: true}).Assemble(in); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,193 @@
+package base
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// AggregateTags are the tags assembled as Aggregate smart tags. They are
+// namespaced, so they don't clash with the predicates of applications.
+var AggregateTags = []string{"agg.count", "agg.min", "agg.max"}
+
+// aggregatePrefix is the namespace of the aggregate smart tags.
+const aggregatePrefix = "agg."
+
+// AggregateTag is the Aggregate smart tag.
+var AggregateTag = ir.SmartTag{
+	Tags:      AggregateTags,
+	Assembler: AggregateAssembler{},
+}
+
+// Aggregate is a smart node computed when a record is read. It aggregates
+// the nodes found at a path in the dicts of all the writers of a key:
+// agg.count(path=PATH) counts the writers with a node at the path, e.g. how
+// many writers announced something, and agg.min(path=PATH) and
+// agg.max(path=PATH) return the minimum and maximum of the numbers at the
+// path, e.g. the latest value across writers. Paths are given as .key1.key2
+// and only follow the string keys of dicts.
+type Aggregate struct {
+	op   string
+	path []string
+
+	metadataCtx *meta.Meta
+}
+
+// Op returns the aggregation, i.e. count, min or max.
+func (a *Aggregate) Op() string {
+	return a.op
+}
+
+// Path returns the keys of the path aggregated.
+func (a *Aggregate) Path() []string {
+	return a.path
+}
+
+// Evaluate aggregates the nodes at the path in the dicts of the writers of a key.
+// It returns false if there is nothing to aggregate, i.e. for min and max if
+// there are no numbers at the path. Count always returns a result.
+func (a *Aggregate) Evaluate(dicts []xr.Dict) (xr.Node, bool) {
+	count := 0
+	var best xr.Node
+	var bestValue *big.Float
+	for _, d := range dicts {
+		n := lookupPath(d, a.path)
+		if n == nil {
+			continue
+		}
+		count++
+		v, ok := numberValue(n)
+		if !ok {
+			continue
+		}
+		if bestValue == nil ||
+			(a.op == "min" && v.Cmp(bestValue) < 0) ||
+			(a.op == "max" && v.Cmp(bestValue) > 0) {
+			best, bestValue = n, v
+		}
+	}
+	if a.op == "count" {
+		return xr.NewInt64(int64(count)), true
+	}
+	return best, best != nil
+}
+
+// lookupPath returns the node at a path in a dict, or nil if not found.
+func lookupPath(d xr.Dict, path []string) xr.Node {
+	var n xr.Node = d
+	for _, k := range path {
+		cur, ok := n.(xr.Dict)
+		if !ok {
+			return nil
+		}
+		if n = cur.Get(xr.String{Value: k}); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// numberValue returns the value of a number node to compare it.
+func numberValue(n xr.Node) (*big.Float, bool) {
+	switch n1 := n.(type) {
+	case xr.Int:
+		if n1.Int != nil {
+			return new(big.Float).SetInt(n1.Int), true
+		}
+	case xr.Float:
+		if n1.Float != nil {
+			return n1.Float, true
+		}
+	}
+	return nil, false
+}
+
+// Aggregate disassembles to a xr.Predicate of the form
+// agg.count(path=STRING), agg.min(path=STRING) or agg.max(path=STRING).
+func (a Aggregate) Disassemble() xr.Node {
+	return xr.Predicate{
+		Tag:   aggregatePrefix + a.op,
+		Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "path"}, Value: xr.String{Value: "." + strings.Join(a.path, ".")}}},
+	}
+}
+
+func (a *Aggregate) Metadata() meta.MetadataInfo {
+	return a.metadataCtx.Get()
+}
+
+func (a *Aggregate) WritePretty(w io.Writer) error {
+	return a.Disassemble().WritePretty(w)
+}
+
+func (a *Aggregate) UpdateWith(ctx ir.UpdateContext, with ir.Node) error {
+	w, ok := with.(*Aggregate)
+	if !ok {
+		return fmt.Errorf("cannot update with a non-aggregate node")
+	}
+
+	// Update value
+	*a = *w
+	// Update metadata
+	a.metadataCtx.Update(w.metadataCtx)
+
+	return nil
+}
+
+type AggregateAssembler struct{}
+
+// Aggregate assemble expects a predicate of the form:
+// agg.count(path=STRING), agg.min(path=STRING) or agg.max(path=STRING)
+// where the path is of the form .key1.key2
+func (AggregateAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
+	p, ok := srcNode.(xr.Predicate)
+	if !ok {
+		return nil, fmt.Errorf("smart-tags must be predicates")
+	}
+	op := strings.TrimPrefix(p.Tag, aggregatePrefix)
+	switch op {
+	case "count", "min", "max":
+	default:
+		return nil, fmt.Errorf("not an aggregate smart tag")
+	}
+	if op == p.Tag {
+		return nil, fmt.Errorf("not an aggregate smart tag")
+	}
+
+	// Check path
+	s, ok := getNamed(p, xr.String{Value: "path"}).(xr.String)
+	if !ok {
+		return nil, fmt.Errorf("no path provided")
+	}
+	path, err := parsePath(s.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	// Assemble metadata
+	m := meta.New()
+	if err := m.Apply(metadata...); err != nil {
+		return nil, err
+	}
+
+	return &Aggregate{op: op, path: path, metadataCtx: m}, nil
+}
+
+// parsePath parses a path of the form .key1.key2
+func parsePath(s string) ([]string, error) {
+	if !strings.HasPrefix(s, ".") {
+		return nil, fmt.Errorf("path must start with a dot")
+	}
+	path := strings.Split(s[1:], ".")
+	for _, k := range path {
+		if k == "" {
+			return nil, fmt.Errorf("path has an empty key")
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,63 @@
+package base
+
+import (
+	"math/big"
+	"testing"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+)
+
+func aggregate(op, path string) xr.Predicate {
+	return xr.Predicate{Tag: "agg." + op, Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "path"}, Value: xr.String{Value: path}}}}
+}
+
+func TestAggregate(t *testing.T) {
+	asm := ir.AssemblerContext{Grammar: BaseGrammar, Strict: true}
+	// Tags are namespaced, so other predicates are left alone.
+	if n, err := asm.Assemble(xr.Predicate{Tag: "count", Named: aggregate("count", ".a").Named}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := n.(*Aggregate); ok {
+		t.Fatal("predicate without namespace assembled as an aggregate")
+	}
+	for _, path := range []string{"a", ".a..b", "."} {
+		if _, err := asm.Assemble(aggregate("count", path)); err == nil {
+			t.Fatal("invalid path should fail", path)
+		}
+	}
+
+	version := func(v xr.Node) xr.Dict {
+		return xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "app"}, Value: xr.Dict{Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "version"}, Value: v},
+		}}}}}
+	}
+	dicts := []xr.Dict{
+		version(xr.NewInt64(3)),
+		version(xr.Float{Float: big.NewFloat(1.5)}),
+		version(xr.String{Value: "latest"}),
+		{Pairs: xr.Pairs{}},
+	}
+	for op, expected := range map[string]xr.Node{
+		"count": xr.NewInt64(3),
+		"min":   xr.Float{Float: big.NewFloat(1.5)},
+		"max":   xr.NewInt64(3),
+	} {
+		n, err := asm.Assemble(aggregate(op, ".app.version"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !xr.IsEqual(n.Disassemble(), aggregate(op, ".app.version")) {
+			t.Fatal("wrong disassembled aggregate", n.Disassemble())
+		}
+		if v, ok := n.(*Aggregate).Evaluate(dicts); !ok || !xr.IsEqual(v, expected) {
+			t.Fatal("wrong result of", op, v)
+		}
+	}
+
+	// There is nothing to aggregate without numbers.
+	n, _ := asm.Assemble(aggregate("max", ".app.name"))
+	if _, ok := n.(*Aggregate).Evaluate(dicts); ok {
+		t.Fatal("max without numbers should have no result")
+	}
+}
@@ -3,10 +3,27 @@ package base
 
 import "github.com/libp2p/go-smart-record/ir"
 
+// BaseTags is the registry of the smart tags in the base vocabulary.
+var BaseTags = ir.NewTagRegistry()
+
+// ReachableTags are the tags assembled as Reachable smart tags, including
+// the disassembled forms of a verified node.
+var ReachableTags = []string{"connectivity", "connected", "notConnected", "dialable", "dialed", "notDialable", "pending"}
+
+func init() {
+	// register the assemblers of smart tags here
+	for _, st := range []ir.SmartTag{ReachableTag, ProbeTag, SupportsTag, ProvidesTag, PeerRecordTag, NowTag, SeqTag, LogTag, RefTag, AggregateTag} {
+		if err := BaseTags.RegisterSmartTag(st); err != nil {
+			panic(err)
+		}
+	}
+}
+
 // BaseGrammar is an assembler for the base vocabulary of smart tags supported by a record.
+// To extend the vocabulary with other smart tags, register them in a copy of BaseTags and
+// configure the VM with its AssemblerContext (see ir.TagRegistry).
 var BaseGrammar = ir.SequenceAssembler{
-	// insert the assemblers of smart tags here
-	ReachableAssembler{},
+	BaseTags,
 	// if no smart tag parses the input, keep it as is (in the form of syntactic nodes)
 	ir.SyntacticGrammar,
 }
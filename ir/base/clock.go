@@ -0,0 +1,186 @@
+package base
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// NowTag is the Now smart tag. The VM assigns the time to Now nodes once assembled.
+var NowTag = ir.SmartTag{
+	Tags:       []string{"now"},
+	Assembler:  NowAssembler{},
+	OnAssemble: assignNow,
+}
+
+// SeqTag is the Seq smart tag. The VM assigns the next number of the key
+// to Seq nodes once assembled.
+var SeqTag = ir.SmartTag{
+	Tags:       []string{"seq"},
+	Assembler:  SeqAssembler{},
+	OnAssemble: assignSeq,
+}
+
+func assignNow(ctx ir.HookContext, n ir.Node) {
+	if t, ok := n.(*Now); ok && !t.assigned {
+		t.t, t.assigned = time.Now(), true
+	}
+}
+
+// assignSeq assigns the next number of the sequence the VM keeps for the key.
+// Only the numbers assigned to Seq nodes advance it, not the ones written by
+// writers, and it is kept after the record is garbage collected, so numbers
+// are never reused. If the sequence is exhausted, the node is left unassigned.
+func assignSeq(ctx ir.HookContext, n ir.Node) {
+	s, ok := n.(*Seq)
+	if !ok || s.assigned || ctx.NextSeq == nil {
+		return
+	}
+	if next, ok := ctx.NextSeq(); ok {
+		s.n, s.assigned = next, true
+	}
+}
+
+// Now is a smart node replaced by the time of the server
+// that assembles it, so writers cannot forge it.
+type Now struct {
+	t        time.Time
+	assigned bool
+
+	metadataCtx *meta.Meta
+}
+
+// Now disassembles to the Unix time in nanoseconds assigned, or to now() if
+// not assigned yet.
+func (n Now) Disassemble() xr.Node {
+	if !n.assigned {
+		return xr.Predicate{Tag: "now"}
+	}
+	return xr.NewInt64(n.t.UnixNano())
+}
+
+// Resolved returns the time assigned as an ir.Int.
+func (n *Now) Resolved() ir.Node {
+	if !n.assigned {
+		return nil
+	}
+	return ir.NewInt64(n.t.UnixNano(), meta.Expiration(n.Metadata().ExpirationTime))
+}
+
+func (n *Now) Metadata() meta.MetadataInfo {
+	return n.metadataCtx.Get()
+}
+
+func (n *Now) WritePretty(w io.Writer) error {
+	return n.Disassemble().WritePretty(w)
+}
+
+func (n *Now) UpdateWith(ctx ir.UpdateContext, with ir.Node) error {
+	w, ok := with.(*Now)
+	if !ok {
+		return fmt.Errorf("cannot update with a non-now node")
+	}
+
+	// Update value
+	*n = *w
+	// Update metadata
+	n.metadataCtx.Update(w.metadataCtx)
+
+	return nil
+}
+
+type NowAssembler struct{}
+
+// Now assemble expects a predicate of the form now().
+func (NowAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
+	if err := checkNoArgs(srcNode, "now"); err != nil {
+		return nil, err
+	}
+	m := meta.New()
+	if err := m.Apply(metadata...); err != nil {
+		return nil, err
+	}
+	return &Now{metadataCtx: m}, nil
+}
+
+// Seq is a smart node replaced by the next sequence number of the key by the
+// server that assembles it. Unlike sequence numbers chosen by writers, they
+// cannot be forged nor collide.
+type Seq struct {
+	n        uint64
+	assigned bool
+
+	metadataCtx *meta.Meta
+}
+
+// Seq disassembles to the number assigned, or to seq() if not assigned yet.
+func (s Seq) Disassemble() xr.Node {
+	if !s.assigned {
+		return xr.Predicate{Tag: "seq"}
+	}
+	return xr.NewInt64(int64(s.n))
+}
+
+// Resolved returns the number assigned as an ir.Int.
+func (s *Seq) Resolved() ir.Node {
+	if !s.assigned {
+		return nil
+	}
+	return ir.NewInt64(int64(s.n), meta.Expiration(s.Metadata().ExpirationTime))
+}
+
+func (s *Seq) Metadata() meta.MetadataInfo {
+	return s.metadataCtx.Get()
+}
+
+func (s *Seq) WritePretty(w io.Writer) error {
+	return s.Disassemble().WritePretty(w)
+}
+
+func (s *Seq) UpdateWith(ctx ir.UpdateContext, with ir.Node) error {
+	w, ok := with.(*Seq)
+	if !ok {
+		return fmt.Errorf("cannot update with a non-seq node")
+	}
+
+	// Update value
+	*s = *w
+	// Update metadata
+	s.metadataCtx.Update(w.metadataCtx)
+
+	return nil
+}
+
+type SeqAssembler struct{}
+
+// Seq assemble expects a predicate of the form seq().
+func (SeqAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
+	if err := checkNoArgs(srcNode, "seq"); err != nil {
+		return nil, err
+	}
+	m := meta.New()
+	if err := m.Apply(metadata...); err != nil {
+		return nil, err
+	}
+	return &Seq{metadataCtx: m}, nil
+}
+
+// checkNoArgs checks that a node is a predicate with a tag and no arguments.
+func checkNoArgs(n xr.Node, tag string) error {
+	p, ok := n.(xr.Predicate)
+	if !ok {
+		return fmt.Errorf("smart-tags must be predicates")
+	}
+	if p.Tag != tag {
+		return fmt.Errorf("not a %s smart tag", tag)
+	}
+	if len(p.Positional) != 0 || len(p.Named) != 0 {
+		return fmt.Errorf("%s takes no arguments", tag)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package base
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+)
+
+func TestNowSeq(t *testing.T) {
+	asm := ir.AssemblerContext{Grammar: BaseGrammar, Strict: true}
+	if _, err := asm.Assemble(xr.Predicate{Tag: "seq", Positional: xr.Nodes{xr.NewInt64(3)}}); err == nil {
+		t.Fatal("seq with arguments should fail")
+	}
+
+	n, err := asm.Assemble(xr.Predicate{Tag: "now"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !xr.IsEqual(n.Disassemble(), xr.Predicate{Tag: "now"}) {
+		t.Fatal("now assigned before the hook", n.Disassemble())
+	}
+	before := time.Now().UnixNano()
+	NowTag.OnAssemble(ir.HookContext{}, n)
+	if ts, ok := n.Disassemble().(xr.Int); !ok || ts.Int64() < before {
+		t.Fatal("now not assigned", n.Disassemble())
+	}
+
+	// Numbers are taken from the sequence of the key.
+	seq := func(hctx ir.HookContext) xr.Node {
+		n, err := asm.Assemble(xr.Predicate{Tag: "seq"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		SeqTag.OnAssemble(hctx, n)
+		return n.Disassemble()
+	}
+	last := uint64(6)
+	hctx := ir.HookContext{NextSeq: func() (uint64, bool) {
+		if last == math.MaxInt64 {
+			return 0, false
+		}
+		last++
+		return last, true
+	}}
+	if !xr.IsEqual(seq(hctx), xr.NewInt64(7)) || !xr.IsEqual(seq(hctx), xr.NewInt64(8)) {
+		t.Fatal("sequence numbers not taken from the key")
+	}
+	// Nodes are left unassigned if the sequence is exhausted or not available.
+	last = math.MaxInt64
+	if !xr.IsEqual(seq(hctx), xr.Predicate{Tag: "seq"}) {
+		t.Fatal("number assigned from an exhausted sequence")
+	}
+	if !xr.IsEqual(seq(ir.HookContext{}), xr.Predicate{Tag: "seq"}) {
+		t.Fatal("number assigned without a sequence")
+	}
+}
+
+func TestNowResolved(t *testing.T) {
+	asm := ir.AssemblerContext{Grammar: BaseGrammar}
+	// Replicas store the time assigned as an integer, which
+	// is updated with the time assigned to later updates.
+	stored, err := asm.Assemble(xr.NewInt64(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := asm.Assemble(xr.Predicate{Tag: "now"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stored.UpdateWith(ir.DefaultUpdateContext{}, n); err == nil {
+		t.Fatal("update with a time not assigned should fail")
+	}
+	NowTag.OnAssemble(ir.HookContext{}, n)
+	if err := stored.UpdateWith(ir.DefaultUpdateContext{}, n); err != nil {
+		t.Fatal(err)
+	}
+	if !xr.IsEqual(stored.Disassemble(), n.Disassemble()) {
+		t.Fatal("time not updated", stored.Disassemble())
+	}
+}
@@ -0,0 +1,198 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+
+	"github.com/libp2p/go-smart-record/ir"
+)
+
+// DialCheckerKey is the key of the AssemblerContext.Keys (and ir.HookContext.Keys)
+// holding the DialChecker used to verify dialable smart tags. Without a
+// DialChecker, dial checks are run synchronously.
+const DialCheckerKey = "base.DialChecker"
+
+// dialTimeout is the timeout of synchronous dial checks.
+const dialTimeout = 5 * time.Second
+
+// DialChecker runs the dial checks of Reachable smart tags (and the probes of
+// Probe smart tags) asynchronously, in a number of workers fed by a bounded
+// queue. Checks to the same peer are deduplicated across tags and keys,
+// and their results are cached for a time window.
+type DialChecker struct {
+	ctx     context.Context
+	h       host.Host
+	timeout time.Duration
+	window  time.Duration
+	queue   chan func()
+
+	lk     sync.Mutex
+	checks map[peer.ID]*dialCheck
+	probes map[peer.ID]*dialCheck
+}
+
+// NewDialChecker creates a DialChecker that dials from a host, running at most
+// workers dials at the same time, each one with a timeout, and queueing at most
+// queue checks. Results are cached for window. The workers stop, and pending
+// dials are cancelled, when the context is done.
+func NewDialChecker(ctx context.Context, h host.Host, workers, queue int, timeout, window time.Duration) (*DialChecker, error) {
+	if workers <= 0 {
+		return nil, fmt.Errorf("dial workers must be positive")
+	}
+	if queue < 0 {
+		return nil, fmt.Errorf("dial queue must not be negative")
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("dial timeout must be positive")
+	}
+	if window < 0 {
+		return nil, fmt.Errorf("dial cache window must not be negative")
+	}
+	d := &DialChecker{
+		ctx:     ctx,
+		h:       h,
+		timeout: timeout,
+		window:  window,
+		queue:   make(chan func(), queue),
+		checks:  make(map[peer.ID]*dialCheck),
+		probes:  make(map[peer.ID]*dialCheck),
+	}
+	for i := 0; i < workers; i++ {
+		go d.worker()
+	}
+	return d, nil
+}
+
+// worker runs the checks queued until the context of the DialChecker is done.
+func (d *DialChecker) worker() {
+	for {
+		select {
+		case run := <-d.queue:
+			run()
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// dialCheck is the result of a dial check to a peer. It is shared by all
+// the nodes checked while it is pending or cached.
+type dialCheck struct {
+	done  chan struct{} // closed when the check ends
+	ok    bool          // set before done is closed
+	probe probeResult   // set before done is closed, for probes
+}
+
+// result returns if the check ended and if the peer was dialable.
+func (c *dialCheck) result() (done, ok bool) {
+	select {
+	case <-c.done:
+		return true, c.ok
+	default:
+		return false, false
+	}
+}
+
+// check returns the dial check of a peer, starting it if there is none
+// pending or cached. It returns nil if the queue is full.
+func (d *DialChecker) check(i peer.AddrInfo) *dialCheck {
+	return d.start(d.checks, i, func(c *dialCheck) {
+		c.ok = checkIfDialable(d.ctx, d.h, i, d.timeout)
+	})
+}
+
+// probe returns the probe of a peer, starting it if there is none
+// pending or cached. It returns nil if the queue is full.
+func (d *DialChecker) probe(i peer.AddrInfo) *dialCheck {
+	return d.start(d.probes, i, func(c *dialCheck) {
+		c.probe = probePeer(d.ctx, d.h, i, d.timeout)
+		c.ok = c.probe.ok
+	})
+}
+
+// start returns the check of a peer in checks, or queues a new one with run.
+// Checks are removed from checks once they are out of the cache window.
+func (d *DialChecker) start(checks map[peer.ID]*dialCheck, i peer.AddrInfo, run func(*dialCheck)) *dialCheck {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	if c, ok := checks[i.ID]; ok {
+		return c
+	}
+	c := &dialCheck{done: make(chan struct{})}
+	select {
+	case d.queue <- func() {
+		run(c)
+		close(c.done)
+		time.AfterFunc(d.window, func() { d.remove(checks, i.ID, c) })
+	}:
+	default:
+		return nil
+	}
+	checks[i.ID] = c
+	return c
+}
+
+// remove removes the check of a peer from checks, unless it was replaced.
+func (d *DialChecker) remove(checks map[peer.ID]*dialCheck, p peer.ID, c *dialCheck) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	if checks[p] == c {
+		delete(checks, p)
+	}
+}
+
+// dialCheckerOf returns the DialChecker of a hook context, if any.
+func dialCheckerOf(ctx ir.HookContext) *DialChecker {
+	d, _ := ctx.Keys[DialCheckerKey].(*DialChecker)
+	return d
+}
+
+// CheckIfdialable Checks if peer reachable with a timeout.
+func checkIfDialable(ctx context.Context, h host.Host, i peer.AddrInfo, timeout time.Duration) bool {
+	// If self, consider as reachable and don't try to connect
+	if h.ID() == i.ID {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return h.Connect(ctx, i) == nil
+}
+
+// identified reports whether identify ended on the connections to a peer,
+// without waiting for it. Hosts without identify are always identified.
+func identified(h host.Host, p peer.ID) bool {
+	ids, ok := h.(interface{ IDService() *identify.IDService })
+	if !ok {
+		return true
+	}
+	for _, c := range h.Network().ConnsToPeer(p) {
+		select {
+		case <-ids.IDService().IdentifyWait(c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// waitIdentify waits for identify to run on the connections to a peer,
+// so its protocols are known in the peerstore, if supported by the host.
+func waitIdentify(ctx context.Context, h host.Host, p peer.ID) {
+	ids, ok := h.(interface{ IDService() *identify.IDService })
+	if !ok {
+		return
+	}
+	for _, c := range h.Network().ConnsToPeer(p) {
+		select {
+		case <-ids.IDService().IdentifyWait(c):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+)
+
+func TestDialChecker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupHost(ctx, t)
+	s := setupHost(ctx, t)
+	addr := fmt.Sprintf("%s/p2p/%s", tcpAddr(s), s.ID().Pretty())
+
+	if _, err := NewDialChecker(ctx, c, 0, 1, time.Second, time.Minute); err == nil {
+		t.Fatal("dial checker without workers should fail")
+	}
+	dc, err := NewDialChecker(ctx, c, 1, 1, 5*time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hctx := ir.HookContext{Host: c, Keys: map[string]interface{}{DialCheckerKey: dc}}
+	assemble := func() *Reachable {
+		n, err := BaseGrammar.Assemble(ir.AssemblerContext{Grammar: BaseGrammar}, reachableNode(addr, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n.(*Reachable)
+	}
+	tag := func(r *Reachable) string {
+		return r.Disassemble().(xr.Predicate).Tag
+	}
+
+	// Keep the only worker busy so checks stay pending.
+	release := busy(dc)
+	r1, r2 := assemble(), assemble()
+	ReachableTag.OnAssemble(hctx, r1)
+	ReachableTag.OnAssemble(hctx, r2)
+	if tag(r1) != "pending" || tag(r2) != "pending" {
+		t.Fatal("dial checks should be pending", tag(r1), tag(r2))
+	}
+	if r1.dial != r2.dial {
+		t.Fatal("checks to the same peer should be deduplicated")
+	}
+	// Pending nodes are assembled again as dialable.
+	if _, err := (ReachableAssembler{}).Assemble(ir.AssemblerContext{}, r1.Disassemble()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Checks are not queued while the queue is full, and
+	// nodes are left unverified to be verified again.
+	r4, err := BaseGrammar.Assemble(ir.AssemblerContext{Grammar: BaseGrammar}, reachableNode(unreachableAddr, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ReachableTag.OnAssemble(hctx, r4)
+	if tag(r4.(*Reachable)) != "dialable" || r4.Metadata().LastChecked != 0 {
+		t.Fatal("check should not be queued", tag(r4.(*Reachable)))
+	}
+
+	release()
+	<-r1.dial.done
+	if tag(r1) != "dialed" || tag(r2) != "dialed" {
+		t.Fatal("dial checks not resolved", tag(r1), tag(r2))
+	}
+	// Results are cached.
+	r3 := assemble()
+	ReachableTag.OnAssemble(hctx, r3)
+	if r3.dial != r1.dial {
+		t.Fatal("dial check not cached")
+	}
+	// And removed once out of the cache window.
+	time.Sleep(1500 * time.Millisecond)
+	dc.lk.Lock()
+	n := len(dc.checks)
+	dc.lk.Unlock()
+	if n != 0 {
+		t.Fatal("dial check not removed from the cache", n)
+	}
+}
+
+// busy keeps the workers of a DialChecker busy until released.
+func busy(dc *DialChecker) (release func()) {
+	started, done := make(chan struct{}), make(chan struct{})
+	dc.queue <- func() {
+		close(started)
+		<-done
+	}
+	<-started
+	return func() { close(done) }
+}
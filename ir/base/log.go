@@ -0,0 +1,406 @@
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// DefaultLogLength is the maximum number of entries kept in a log
+// that sets neither a maximum length nor a maximum size.
+const DefaultLogLength = 1000
+
+// LogTags are the tags assembled as Log smart tags, including
+// the disassembled form of a log.
+var LogTags = []string{"appendLog", "log"}
+
+// LogTag is the Log smart tag.
+var LogTag = ir.SmartTag{
+	Tags:      LogTags,
+	Assembler: LogAssembler{},
+}
+
+// Log is a smart node. It is an append-only log of values numbered by the
+// server in the order they are appended, so clients don't need to sort and
+// de-duplicate them. Each entry expires on its own, with the metadata of the
+// update that appended it, and the oldest entries are dropped once the log
+// exceeds its maximum length or size.
+type Log struct {
+	// Entries sorted by sequence number.
+	entries []logEntry
+	// Last sequence number assigned.
+	last uint64
+	// Entries are new and numbered when appended to another log.
+	appended bool
+	// Maximum number of entries and size of their values in bytes. Zero if unlimited.
+	maxLength int
+	maxBytes  int
+
+	metadataCtx *meta.Meta
+}
+
+type logEntry struct {
+	seq   uint64
+	value ir.Node
+}
+
+// Entries returns the sequence numbers and values of the entries of the log.
+func (l *Log) Entries() ([]uint64, ir.Nodes) {
+	seqs, values := make([]uint64, len(l.entries)), make(ir.Nodes, len(l.entries))
+	for i, e := range l.entries {
+		seqs[i], values[i] = e.seq, e.value
+	}
+	return seqs, values
+}
+
+// Range returns a copy of the log with the entries numbered
+// from the first to the second sequence number, both included.
+func (l *Log) Range(from, to uint64) *Log {
+	r := l.withEntries(l.metadataCtx)
+	for _, e := range l.entries {
+		if e.seq >= from && e.seq <= to {
+			r.entries = append(r.entries, e)
+		}
+	}
+	return r
+}
+
+// withEntries returns an empty log with the same sequence numbers and limits,
+// and the metadata given.
+func (l *Log) withEntries(m *meta.Meta) *Log {
+	return &Log{last: l.last, maxLength: l.maxLength, maxBytes: l.maxBytes, metadataCtx: m}
+}
+
+// Log disassembles to a xr.Predicate of the form
+// log(entries=[{seq: INT, value: NODE}, ...], last=INT, maxLength=INT, maxBytes=INT)
+// where last is the last sequence number assigned, and the limits are omitted if not set.
+func (l Log) Disassemble() xr.Node {
+	entries := xr.List{Elements: make(xr.Nodes, len(l.entries))}
+	for i, e := range l.entries {
+		entries.Elements[i] = xr.Dict{Pairs: xr.Pairs{
+			{Key: xr.String{Value: "seq"}, Value: xr.NewInt64(int64(e.seq))},
+			{Key: xr.String{Value: "value"}, Value: e.value.Disassemble()},
+		}}
+	}
+	named := xr.Pairs{
+		{Key: xr.String{Value: "entries"}, Value: entries},
+		{Key: xr.String{Value: "last"}, Value: xr.NewInt64(int64(l.last))},
+	}
+	if l.maxLength > 0 {
+		named = append(named, xr.Pair{Key: xr.String{Value: "maxLength"}, Value: xr.NewInt64(int64(l.maxLength))})
+	}
+	if l.maxBytes > 0 {
+		named = append(named, xr.Pair{Key: xr.String{Value: "maxBytes"}, Value: xr.NewInt64(int64(l.maxBytes))})
+	}
+	return xr.Predicate{Tag: "log", Named: named}
+}
+
+func (l *Log) Metadata() meta.MetadataInfo {
+	return l.metadataCtx.Get()
+}
+
+func (l *Log) WritePretty(w io.Writer) error {
+	return l.Disassemble().WritePretty(w)
+}
+
+// Children returns the values of the entries of the log.
+func (l *Log) Children() ir.Nodes {
+	_, values := l.Entries()
+	return values
+}
+
+// Remove removes the entries whose value f returns true for.
+func (l *Log) Remove(f func(ir.Node) bool) {
+	kept := l.entries[:0]
+	for _, e := range l.entries {
+		if !f(e.value) {
+			kept = append(kept, e)
+		}
+	}
+	l.entries = kept
+}
+
+// Split splits the log into logs with the entries that expire at the same time.
+func (l *Log) Split() map[uint64]ir.Node {
+	exp := l.Metadata().ExpirationTime
+	out := map[uint64]ir.Node{exp: l.withEntries(expiringAt(exp))}
+	for _, e := range l.entries {
+		exp := e.value.Metadata().ExpirationTime
+		f, ok := out[exp].(*Log)
+		if !ok {
+			f = l.withEntries(expiringAt(exp))
+			out[exp] = f
+		}
+		f.entries = append(f.entries, e)
+	}
+	return out
+}
+
+func expiringAt(exp uint64) *meta.Meta {
+	m := meta.New()
+	_ = m.Apply(meta.Expiration(exp))
+	return m
+}
+
+// UpdateWith appends the entries of an appendLog node after the last entry
+// of the log, and replaces the limits of the log by the ones of the update,
+// if set. The entries of a disassembled log keep their sequence numbers and
+// are merged with the ones of the log instead, and the limits of the log that
+// expires later are kept, so merges give the same result in any order.
+func (l *Log) UpdateWith(ctx ir.UpdateContext, with ir.Node) error {
+	w, ok := with.(*Log)
+	if !ok {
+		return fmt.Errorf("cannot update with a non-log node")
+	}
+
+	// Update value
+	if w.appended {
+		for _, e := range w.entries {
+			l.last++
+			l.entries = append(l.entries, logEntry{seq: l.last, value: e.value})
+		}
+		if w.maxLength > 0 {
+			l.maxLength = w.maxLength
+		}
+		if w.maxBytes > 0 {
+			l.maxBytes = w.maxBytes
+		}
+	} else {
+		for _, e := range w.entries {
+			l.insert(ctx, e)
+		}
+		if w.last > l.last {
+			l.last = w.last
+		}
+		if w.limitsLater(l) {
+			l.maxLength, l.maxBytes = w.maxLength, w.maxBytes
+		}
+	}
+	l.trim()
+	// Update metadata
+	l.metadataCtx.Update(w.metadataCtx)
+
+	return nil
+}
+
+// limitsLater returns true if the limits of the log take precedence over the
+// ones of another log when merging them: if it expires later or, if they
+// expire at the same time, if its limits are larger.
+func (l *Log) limitsLater(than *Log) bool {
+	exp, thanExp := l.Metadata().ExpirationTime, than.Metadata().ExpirationTime
+	if exp != thanExp {
+		return exp > thanExp
+	}
+	if l.maxLength != than.maxLength {
+		return l.maxLength > than.maxLength
+	}
+	return l.maxBytes > than.maxBytes
+}
+
+// insert inserts an entry in order, merging it with the entry
+// with the same sequence number if there is one.
+func (l *Log) insert(ctx ir.UpdateContext, e logEntry) {
+	i := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].seq >= e.seq })
+	if i < len(l.entries) && l.entries[i].seq == e.seq {
+		l.entries[i].value = ir.MergeLatest(ctx, l.entries[i].value, e.value)
+		return
+	}
+	l.entries = append(l.entries, logEntry{})
+	copy(l.entries[i+1:], l.entries[i:])
+	l.entries[i] = e
+}
+
+// trim drops the oldest entries of the log until it doesn't exceed its limits.
+func (l *Log) trim() {
+	keep := len(l.entries)
+	max := l.maxLength
+	if max == 0 && l.maxBytes == 0 {
+		max = DefaultLogLength
+	}
+	if max > 0 && keep > max {
+		keep = max
+	}
+	if l.maxBytes > 0 {
+		size := 0
+		for i := len(l.entries) - 1; i >= len(l.entries)-keep; i-- {
+			n, err := entrySize(l.entries[i])
+			if err != nil || size+n > l.maxBytes {
+				keep = len(l.entries) - 1 - i
+				break
+			}
+			size += n
+		}
+	}
+	if keep < len(l.entries) {
+		l.entries = append([]logEntry(nil), l.entries[len(l.entries)-keep:]...)
+	}
+}
+
+// entrySize returns the size of the compact JSON encoding of the value of an entry.
+func entrySize(e logEntry) (int, error) {
+	b, err := xr.MarshalJSON(e.value.Disassemble())
+	if err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err != nil {
+		return 0, err
+	}
+	return buf.Len(), nil
+}
+
+type LogAssembler struct{}
+
+// Log assemble expects a predicate of the form:
+// appendLog(VALUE, ...; maxLength=INT, maxBytes=INT)
+// with the values to append and optional limits, or the form resulting
+// from disassembling a Log when merging the state of replicas, as writers
+// cannot choose the sequence numbers of their entries.
+func (LogAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
+	p, ok := srcNode.(xr.Predicate)
+	if !ok {
+		return nil, fmt.Errorf("smart-tags must be predicates")
+	}
+	var l *Log
+	var err error
+	switch p.Tag {
+	case "appendLog":
+		l, err = assembleAppendLog(ctx, p, metadata...)
+	case "log":
+		// Writers can't set the entries of logs, even in lenient mode, as
+		// stored logs would look like the ones numbered by the server.
+		if !ctx.Merging {
+			return nil, &ir.AssemblyError{Reason: "logs can only be appended to", Fatal: true}
+		}
+		l, err = assembleLog(ctx, p, metadata...)
+	default:
+		return nil, fmt.Errorf("not a log smart tag")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Check limits
+	maxLength, err := getLimit(p, "maxLength")
+	if err != nil {
+		return nil, err
+	}
+	maxBytes, err := getLimit(p, "maxBytes")
+	if err != nil {
+		return nil, err
+	}
+	l.maxLength, l.maxBytes = maxLength, maxBytes
+	l.trim()
+
+	// Assemble metadata
+	m := meta.New()
+	if err := m.Apply(metadata...); err != nil {
+		return nil, err
+	}
+	l.metadataCtx = m
+
+	return l, nil
+}
+
+// assembleAppendLog numbers the values appended from 1. They are numbered
+// again after the last entry of the log they are appended to.
+func assembleAppendLog(ctx ir.AssemblerContext, p xr.Predicate, metadata ...meta.Metadata) (*Log, error) {
+	l := &Log{appended: true}
+	for _, v := range p.Positional {
+		n, err := ctx.Assemble(v, metadata...)
+		if err != nil {
+			return nil, fmt.Errorf("no valid value appended: %s", err)
+		}
+		l.last++
+		l.entries = append(l.entries, logEntry{seq: l.last, value: n})
+	}
+	return l, nil
+}
+
+func assembleLog(ctx ir.AssemblerContext, p xr.Predicate, metadata ...meta.Metadata) (*Log, error) {
+	l := &Log{}
+	if e := getNamed(p, xr.String{Value: "entries"}); e != nil {
+		entries, ok := e.(xr.List)
+		if !ok {
+			return nil, fmt.Errorf("entries must be a list")
+		}
+		for _, e := range entries.Elements {
+			d, ok := e.(xr.Dict)
+			if !ok {
+				return nil, fmt.Errorf("log entries must be dicts")
+			}
+			seq, err := getSeq(d.Get(xr.String{Value: "seq"}), "seq")
+			if err != nil || seq == 0 {
+				return nil, fmt.Errorf("no valid sequence number in log entry")
+			}
+			v := d.Get(xr.String{Value: "value"})
+			if v == nil {
+				return nil, fmt.Errorf("no value in log entry")
+			}
+			n, err := ctx.Assemble(v, metadata...)
+			if err != nil {
+				return nil, fmt.Errorf("no valid value in log entry: %s", err)
+			}
+			l.insert(ir.DefaultUpdateContext{}, logEntry{seq: seq, value: n})
+			if seq > l.last {
+				l.last = seq
+			}
+		}
+	}
+	if n := getNamed(p, xr.String{Value: "last"}); n != nil {
+		last, err := getSeq(n, "last")
+		if err != nil {
+			return nil, err
+		}
+		if last > l.last {
+			l.last = last
+		}
+	}
+	return l, nil
+}
+
+// getLimit returns the value of an optional limit of a log, or zero if not set.
+func getLimit(p xr.Predicate, name string) (int, error) {
+	n := getNamed(p, xr.String{Value: name})
+	if n == nil {
+		return 0, nil
+	}
+	v, err := getUint(n, name)
+	if err != nil {
+		return 0, err
+	}
+	if v > uint64(^uint(0)>>1) {
+		return 0, fmt.Errorf("%s is too large", name)
+	}
+	return int(v), nil
+}
+
+// getSeq returns the value of a sequence number, which
+// must fit in the int64 it is disassembled to.
+func getSeq(n xr.Node, name string) (uint64, error) {
+	v, err := getUint(n, name)
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxInt64 {
+		return 0, fmt.Errorf("%s is too large", name)
+	}
+	return v, nil
+}
+
+// getUint returns the value of a non-negative integer node.
+func getUint(n xr.Node, name string) (uint64, error) {
+	i, ok := n.(xr.Int)
+	if !ok || i.Int == nil || !i.IsUint64() {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return i.Uint64(), nil
+}
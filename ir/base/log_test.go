@@ -0,0 +1,157 @@
+package base
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+func appendLog(limits xr.Pairs, values ...string) xr.Node {
+	p := xr.Predicate{Tag: "appendLog", Named: limits}
+	for _, v := range values {
+		p.Positional = append(p.Positional, xr.String{Value: v})
+	}
+	return p
+}
+
+func logValues(t *testing.T, n ir.Node) ([]uint64, []string) {
+	seqs, values := n.(*Log).Entries()
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = v.Disassemble().(xr.String).Value
+	}
+	return seqs, out
+}
+
+func TestLog(t *testing.T) {
+	asm := ir.AssemblerContext{Grammar: BaseGrammar, Strict: true}
+	if _, err := asm.Assemble(appendLog(xr.Pairs{{Key: xr.String{Value: "maxLength"}, Value: xr.NewInt64(-1)}}, "a")); err == nil {
+		t.Fatal("negative limits should fail")
+	}
+
+	maxLength := xr.Pairs{{Key: xr.String{Value: "maxLength"}, Value: xr.NewInt64(3)}}
+	l, err := asm.Assemble(appendLog(maxLength, "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries are appended in order and the oldest dropped.
+	for _, vs := range [][]string{{"c"}, {"d", "e"}} {
+		n, err := asm.Assemble(appendLog(nil, vs...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.UpdateWith(ir.DefaultUpdateContext{}, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seqs, values := logValues(t, l)
+	if len(seqs) != 3 || seqs[0] != 3 || seqs[2] != 5 || values[0] != "c" || values[2] != "e" {
+		t.Fatal("wrong entries", seqs, values)
+	}
+
+	// The disassembled log assembles back to the same log,
+	// but only when merging the state of replicas.
+	if _, err := asm.Assemble(l.Disassemble()); err == nil {
+		t.Fatal("disassembled log should only be accepted when merging")
+	}
+	// Not even in lenient mode, where it would be stored as a plain predicate.
+	lenient := ir.AssemblerContext{Grammar: BaseGrammar}
+	_, err = lenient.Assemble(xr.Dict{Pairs: xr.Pairs{{Key: xr.String{Value: "chat"}, Value: l.Disassemble()}}})
+	var aerr *ir.AssemblyError
+	if !errors.As(err, &aerr) || !aerr.Fatal {
+		t.Fatal("disassembled log should fail in lenient mode", err)
+	}
+	masm := asm
+	masm.Merging = true
+	r, err := masm.Assemble(l.Disassemble())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !xr.IsEqual(r.Disassemble(), l.Disassemble()) {
+		t.Fatal("log not reassembled", r.Disassemble(), l.Disassemble())
+	}
+
+	// Disassembled logs are merged by sequence number.
+	old, err := asm.Assemble(appendLog(maxLength, "a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old, err = masm.Assemble(old.Disassemble()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UpdateWith(ir.DefaultUpdateContext{}, old); err != nil {
+		t.Fatal(err)
+	}
+	if !xr.IsEqual(r.Disassemble(), l.Disassemble()) {
+		t.Fatal("old entries merged", r.Disassemble())
+	}
+	if seqs, _ := logValues(t, l.(*Log).Range(4, 10)); len(seqs) != 2 || seqs[0] != 4 {
+		t.Fatal("wrong range", seqs)
+	}
+
+	// Entries are dropped once the log exceeds its size. Each value takes 20 bytes.
+	maxBytes := xr.Pairs{{Key: xr.String{Value: "maxBytes"}, Value: xr.NewInt64(45)}}
+	l, err = asm.Assemble(appendLog(maxBytes, "aaa", "bbb", "ccc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seqs, _ := logValues(t, l); len(seqs) != 2 || seqs[0] != 2 {
+		t.Fatal("log not trimmed to its size", seqs)
+	}
+
+	// Sequence numbers must fit in an int64.
+	tooLarge := xr.Int{Int: new(big.Int).Add(big.NewInt(math.MaxInt64), big.NewInt(1))}
+	if _, err := masm.Assemble(xr.Predicate{Tag: "log", Named: xr.Pairs{{Key: xr.String{Value: "last"}, Value: tooLarge}}}); err == nil {
+		t.Fatal("last larger than an int64 should fail")
+	}
+}
+
+func TestLogMergeLimits(t *testing.T) {
+	asm := ir.AssemblerContext{Grammar: BaseGrammar, Strict: true, Merging: true}
+	logForm := func(maxLength int64, seqs ...int64) xr.Node {
+		entries := xr.List{}
+		for _, s := range seqs {
+			entries.Elements = append(entries.Elements, xr.Dict{Pairs: xr.Pairs{
+				{Key: xr.String{Value: "seq"}, Value: xr.NewInt64(s)},
+				{Key: xr.String{Value: "value"}, Value: xr.String{Value: "v"}},
+			}})
+		}
+		return xr.Predicate{Tag: "log", Named: xr.Pairs{
+			{Key: xr.String{Value: "entries"}, Value: entries},
+			{Key: xr.String{Value: "maxLength"}, Value: xr.NewInt64(maxLength)},
+		}}
+	}
+	// Merges keep the limits of the log that expires later in any order.
+	merge := func(first, second ir.Node) xr.Node {
+		merged, err := asm.Assemble(first.Disassemble(), meta.Expiration(first.Metadata().ExpirationTime))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ir.MergeLatest(ir.DefaultUpdateContext{}, merged, second).Disassemble()
+	}
+	older, err := asm.Assemble(logForm(2, 1, 2), meta.Expiration(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, err := asm.Assemble(logForm(3, 2, 3), meta.Expiration(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := merge(older, later)
+	if !xr.IsEqual(out, merge(later, older)) {
+		t.Fatal("merge depends on the order", out, merge(later, older))
+	}
+	n, err := asm.Assemble(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seqs, _ := logValues(t, n); len(seqs) != 3 || n.(*Log).maxLength != 3 {
+		t.Fatal("wrong limits merged", out)
+	}
+}
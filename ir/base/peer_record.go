@@ -0,0 +1,144 @@
+package base
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// PeerRecordTag is the PeerRecord smart tag.
+var PeerRecordTag = ir.SmartTag{
+	Tags:      []string{"peerRecord"},
+	Assembler: PeerRecordAssembler{},
+}
+
+// PeerRecord is a smart node holding a signed peer record (see peer.PeerRecord)
+// of the writer of the dict it is stored in. As peer records are signed by
+// the peer they describe, other writers cannot spoof its addresses.
+type PeerRecord struct {
+	envelope []byte
+	rec      *peer.PeerRecord
+
+	metadataCtx *meta.Meta
+}
+
+// Record returns the peer record.
+func (p *PeerRecord) Record() *peer.PeerRecord {
+	return p.rec
+}
+
+// PeerRecord disassembles to a xr.Predicate of the form
+// peerRecord(peer=PEERID:STRING, addrs=[MULTIADDRESS:STRING, ...], seq=INT, envelope=BYTES)
+// where envelope is the signed envelope of the record, so it can be assembled
+// (and verified) again.
+func (p PeerRecord) Disassemble() xr.Node {
+	addrs := xr.List{Elements: xr.Nodes{}}
+	for _, a := range p.rec.Addrs {
+		addrs.Elements = append(addrs.Elements, xr.String{Value: a.String()})
+	}
+	return xr.Predicate{
+		Tag: "peerRecord",
+		Named: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "peer"}, Value: xr.String{Value: p.rec.PeerID.String()}},
+			xr.Pair{Key: xr.String{Value: "addrs"}, Value: addrs},
+			xr.Pair{Key: xr.String{Value: "seq"}, Value: xr.NewInt64(int64(p.rec.Seq))},
+			xr.Pair{Key: xr.String{Value: "envelope"}, Value: xr.Bytes{Bytes: p.envelope}},
+		},
+	}
+}
+
+func (p *PeerRecord) Metadata() meta.MetadataInfo {
+	return p.metadataCtx.Get()
+}
+
+func (p *PeerRecord) WritePretty(w io.Writer) error {
+	return p.Disassemble().WritePretty(w)
+}
+
+// UpdateWith updates the record only if the new one has a newer sequence number.
+// Older records are ignored, along with their metadata, so they don't extend
+// the expiration of the record.
+func (p *PeerRecord) UpdateWith(ctx ir.UpdateContext, with ir.Node) error {
+	w, ok := with.(*PeerRecord)
+	if !ok {
+		return fmt.Errorf("cannot update with a non-peer-record node")
+	}
+	if w.rec.Seq < p.rec.Seq {
+		return nil
+	}
+
+	// Update value
+	p.envelope, p.rec = w.envelope, w.rec
+	// Update metadata
+	p.metadataCtx.Update(w.metadataCtx)
+
+	return nil
+}
+
+// Compare orders peer records by their sequence number.
+func (p *PeerRecord) Compare(with ir.Node) int {
+	w, ok := with.(*PeerRecord)
+	switch {
+	case !ok || w.rec.Seq == p.rec.Seq:
+		return 0
+	case p.rec.Seq > w.rec.Seq:
+		return 1
+	default:
+		return -1
+	}
+}
+
+type PeerRecordAssembler struct{}
+
+// PeerRecord assemble expects a predicate of the form:
+// peerRecord(envelope=BYTES)
+// with a signed envelope of a peer record, or the form resulting from disassembling
+// a PeerRecord. The envelope must be signed by the peer of the record, and if the
+// writer is given in the AssemblerContext, the peer must be the writer.
+// Invalid envelopes fail the assembly even if it is not strict, so they are
+// never stored as plain predicates that could be mistaken for a verified record.
+func (PeerRecordAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
+	p, ok := srcNode.(xr.Predicate)
+	if !ok {
+		return nil, fmt.Errorf("smart-tags must be predicates")
+	}
+	if p.Tag != "peerRecord" {
+		return nil, fmt.Errorf("not a peer record smart tag")
+	}
+
+	// Verify envelope
+	b, ok := getNamed(p, xr.String{Value: "envelope"}).(xr.Bytes)
+	if !ok {
+		return nil, invalidPeerRecord("no envelope provided")
+	}
+	rec := &peer.PeerRecord{}
+	env, err := record.ConsumeTypedEnvelope(b.Bytes, rec)
+	if err != nil {
+		return nil, invalidPeerRecord("no valid signed peer record provided: %s", err)
+	}
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil || signer != rec.PeerID {
+		return nil, invalidPeerRecord("peer record not signed by its peer")
+	}
+	if ctx.Writer != "" && ctx.Writer != rec.PeerID {
+		return nil, invalidPeerRecord("peer record of %s written by %s", rec.PeerID, ctx.Writer)
+	}
+
+	// Assemble metadata
+	m := meta.New()
+	if err := m.Apply(metadata...); err != nil {
+		return nil, err
+	}
+
+	return &PeerRecord{envelope: b.Bytes, rec: rec, metadataCtx: m}, nil
+}
+
+func invalidPeerRecord(format string, args ...interface{}) error {
+	return &ir.AssemblyError{Reason: fmt.Sprintf(format, args...), Fatal: true}
+}
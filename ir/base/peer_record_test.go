@@ -0,0 +1,130 @@
+package base
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+	xr "github.com/libp2p/go-routing-language/syntax"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// signedPeerRecord returns the envelope of a peer record signed with priv.
+func signedPeerRecord(t *testing.T, priv crypto.PrivKey, id peer.ID, seq uint64, addrs ...string) []byte {
+	rec := peer.NewPeerRecord()
+	rec.PeerID = id
+	rec.Seq = seq
+	for _, a := range addrs {
+		rec.Addrs = append(rec.Addrs, ma.StringCast(a))
+	}
+	env, err := record.Seal(rec, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := env.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func peerRecordNode(env []byte) xr.Node {
+	return xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "pr"}, Value: xr.Predicate{
+			Tag:   "peerRecord",
+			Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "envelope"}, Value: xr.Bytes{Bytes: env}}},
+		}},
+	}}
+}
+
+func TestPeerRecord(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := peer.IDFromPrivateKey(priv)
+	other, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	otherID, _ := peer.IDFromPrivateKey(other)
+
+	assemble := func(writer peer.ID, env []byte, metadata ...meta.Metadata) (*PeerRecord, error) {
+		n, err := BaseGrammar.Assemble(ir.AssemblerContext{Grammar: BaseGrammar, Writer: writer}, peerRecordNode(env), metadata...)
+		if err != nil {
+			return nil, err
+		}
+		return n.(*ir.Dict).Get(&ir.String{Value: "pr"}).(*PeerRecord), nil
+	}
+
+	r1, err := assemble(id, signedPeerRecord(t, priv, id, 1, "/ip4/1.2.3.4/tcp/4001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := r1.Disassemble().(xr.Predicate)
+	if !xr.IsEqual(getNamed(out, xr.String{Value: "seq"}), xr.NewInt64(1)) ||
+		!xr.IsEqual(getNamed(out, xr.String{Value: "addrs"}), xr.List{Elements: xr.Nodes{xr.String{Value: "/ip4/1.2.3.4/tcp/4001"}}}) ||
+		!xr.IsEqual(getNamed(out, xr.String{Value: "peer"}), xr.String{Value: id.String()}) {
+		t.Fatal("wrong disassembled peer record", out)
+	}
+
+	// Records of other peers, or not signed by their peer, are rejected
+	// even if the assembly is not strict.
+	invalid := map[string][]byte{
+		"other writer": signedPeerRecord(t, other, otherID, 1),
+		"wrong signer": signedPeerRecord(t, other, id, 1),
+		"corrupted":    []byte("not an envelope"),
+	}
+	for name, env := range invalid {
+		_, err := assemble(id, env)
+		var ae *ir.AssemblyError
+		if !errors.As(err, &ae) || ae.Path != ".pr" || !ae.Fatal {
+			t.Fatalf("%s: expected an invalid peer record error: %v", name, err)
+		}
+	}
+
+	// Newer sequence numbers win.
+	r2, err := assemble(id, signedPeerRecord(t, priv, id, 2, "/ip4/5.6.7.8/tcp/4001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r1.UpdateWith(nil, r2); err != nil {
+		t.Fatal(err)
+	}
+	if r1.Record().Seq != 2 {
+		t.Fatal("peer record not updated", r1.Record())
+	}
+	// Older records are ignored, even if they expire later.
+	r0, err := assemble(id, signedPeerRecord(t, priv, id, 0, "/ip4/9.9.9.9/tcp/4001"), meta.Expiration(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r1.UpdateWith(nil, r0); err != nil {
+		t.Fatal(err)
+	}
+	if r1.Record().Seq != 2 || !r1.Record().Addrs[0].Equal(ma.StringCast("/ip4/5.6.7.8/tcp/4001")) {
+		t.Fatal("peer record updated with an older one", r1.Record())
+	}
+	if r1.Metadata().ExpirationTime != 0 {
+		t.Fatal("metadata of an older peer record applied", r1.Metadata())
+	}
+
+	// Replicas merge the newest record regardless of the expiration and the order.
+	for _, order := range [][2]uint64{{1, 2}, {2, 1}} {
+		recs := map[uint64]*PeerRecord{}
+		for seq, exp := range map[uint64]uint64{1: 200, 2: 100} {
+			r, err := assemble(id, signedPeerRecord(t, priv, id, seq), meta.Expiration(exp))
+			if err != nil {
+				t.Fatal(err)
+			}
+			recs[seq] = r
+		}
+		merged := ir.MergeLatest(nil, recs[order[0]], recs[order[1]]).(*PeerRecord)
+		if merged.Record().Seq != 2 || merged.Metadata().ExpirationTime != 100 {
+			t.Fatal("wrong merged peer record", order, merged.Record().Seq, merged.Metadata())
+		}
+	}
+}
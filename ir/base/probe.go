@@ -0,0 +1,263 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	xr "github.com/libp2p/go-routing-language/syntax"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// ProbeTags are the tags assembled as Probe smart tags, including
+// the disassembled forms of a probed node.
+var ProbeTags = []string{"probe", "probing", "probed", "notProbed"}
+
+// ProbeTag is the Probe smart tag. The VM probes the peer once the node is
+// assembled if it has a DialChecker, and otherwise leaves it to the periodic
+// probe, and probes it again once the result is stale, as latencies and
+// protocols change over time. The values measured are not replicated: every
+// replica probes the peer on its own (see ir.Measured).
+var ProbeTag = ir.SmartTag{
+	Tags:       ProbeTags,
+	Assembler:  ProbeAssembler{},
+	OnAssemble: runProbe,
+	Periodic:   reprobe,
+	Period:     reverifyPeriod,
+	Staleness:  reverifyStaleness,
+}
+
+// runProbe runs with the VM locked, so it doesn't dial the peer
+// without a DialChecker (see Probe.probe).
+func runProbe(ctx ir.HookContext, n ir.Node) {
+	if p, ok := n.(*Probe); ok {
+		p.probe(ctx.Host, dialCheckerOf(ctx), false)
+	}
+}
+
+// reprobe probes a copy of the node with its address, so the VM can run
+// the probe without the lock, and then sets the result in the node if it
+// still probes the same address.
+func reprobe(ctx ir.HookContext, n ir.Node) (func(), func()) {
+	p, ok := n.(*Probe)
+	if !ok {
+		return nil, nil
+	}
+	c := &Probe{addr: p.addr}
+	d := dialCheckerOf(ctx)
+	check := func() { c.probe(ctx.Host, d, true) }
+	apply := func() {
+		if p.addr.Equal(c.addr) {
+			p.result, p.check = c.result, c.check
+			if p.metadataCtx != nil && p.probed() {
+				_ = p.metadataCtx.Apply(meta.Checked(time.Now()))
+			}
+		}
+	}
+	return check, apply
+}
+
+// Probe is a smart node. It dials a multiaddr and records the round-trip
+// latency to the peer, the protocols it supports and the address used.
+type Probe struct {
+	// Multiaddr probed
+	addr ma.Multiaddr
+	// Result of the probe, if run synchronously.
+	result *probeResult
+	// Asynchronous probe, if run with a DialChecker.
+	check *dialCheck
+
+	metadataCtx *meta.Meta
+}
+
+// probeResult is the result of probing a peer.
+type probeResult struct {
+	ok        bool
+	latency   time.Duration // Zero if the peer didn't answer pings.
+	protocols []string
+	observed  ma.Multiaddr
+}
+
+// Probe disassembles to a xr.Predicate of the form
+// probe(address=MULTIADDRESS:STRING) if not probed yet.
+// probing(address=MULTIADDRESS:STRING) if an asynchronous probe has not ended.
+// notProbed(address=MULTIADDRESS:STRING) if the peer couldn't be dialed.
+// probed(address=MULTIADDRESS:STRING, latency=INT, protocols=LIST, observed=MULTIADDRESS:STRING)
+// if the peer was dialed, with the latency in microseconds (omitted if the
+// peer doesn't answer pings), the protocols it advertises and the address
+// of the connection (omitted when probing the host itself).
+func (p Probe) Disassemble() xr.Node {
+	address := xr.Pair{Key: xr.String{Value: "address"}, Value: xr.String{Value: p.addr.String()}}
+	res := p.result
+	if p.check != nil {
+		if done, _ := p.check.result(); !done {
+			return xr.Predicate{Tag: "probing", Named: xr.Pairs{address}}
+		}
+		res = &p.check.probe
+	}
+	if res == nil {
+		return xr.Predicate{Tag: "probe", Named: xr.Pairs{address}}
+	}
+	if !res.ok {
+		return xr.Predicate{Tag: "notProbed", Named: xr.Pairs{address}}
+	}
+
+	named := xr.Pairs{address}
+	if res.latency > 0 {
+		named = append(named, xr.Pair{Key: xr.String{Value: "latency"}, Value: xr.NewInt64(res.latency.Microseconds())})
+	}
+	protocols := xr.List{Elements: xr.Nodes{}}
+	for _, proto := range res.protocols {
+		protocols.Elements = append(protocols.Elements, xr.String{Value: proto})
+	}
+	named = append(named, xr.Pair{Key: xr.String{Value: "protocols"}, Value: protocols})
+	if res.observed != nil {
+		named = append(named, xr.Pair{Key: xr.String{Value: "observed"}, Value: xr.String{Value: res.observed.String()}})
+	}
+	return xr.Predicate{Tag: "probed", Named: named}
+}
+
+// Unmeasured returns the form of the node before probing,
+// so replicas probe the peer on their own.
+func (p *Probe) Unmeasured() xr.Node {
+	return xr.Predicate{
+		Tag:   "probe",
+		Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "address"}, Value: xr.String{Value: p.addr.String()}}},
+	}
+}
+
+func (p *Probe) Metadata() meta.MetadataInfo {
+	return p.metadataCtx.Get()
+}
+
+func (p *Probe) WritePretty(w io.Writer) error {
+	return p.Disassemble().WritePretty(w)
+}
+
+func (p *Probe) UpdateWith(ctx ir.UpdateContext, with ir.Node) error {
+	w, ok := with.(*Probe)
+	if !ok {
+		return fmt.Errorf("cannot update with a non-probe node")
+	}
+
+	// Update value
+	*p = *w
+	// Update metadata
+	p.metadataCtx.Update(w.metadataCtx)
+
+	return nil
+}
+
+type ProbeAssembler struct{}
+
+// Probe assemble expects a predicate of the form:
+// probe(address=MULTIADDRESS)
+// or any of the forms resulting from disassembling a Probe, which are probed again.
+func (ProbeAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
+	p, ok := srcNode.(xr.Predicate)
+	if !ok {
+		return nil, fmt.Errorf("smart-tags must be predicates")
+	}
+	switch p.Tag {
+	case "probe", "probing", "probed", "notProbed":
+	default:
+		return nil, fmt.Errorf("not a probe smart tag")
+	}
+
+	// Check multiaddress
+	maddr, err := parseAddress(getNamed(p, xr.String{Value: "address"}))
+	if err != nil {
+		return nil, fmt.Errorf("no valid multiaddr provided")
+	}
+
+	// Assemble metadata
+	m := meta.New()
+	if err := m.Apply(metadata...); err != nil {
+		return nil, err
+	}
+
+	return &Probe{addr: maddr, metadataCtx: m}, nil
+}
+
+// probe runs the probe, discarding the result of a previous one, and records
+// the time of the probe in metadata.
+// If a DialChecker is given, the probe is run asynchronously. If its queue is
+// full, the node is left unprobed, and not recorded as checked so it is probed
+// again by the next periodic probe. Otherwise, the peer is only dialed if wait
+// is set, and the node is left unprobed in the same way if not.
+func (p *Probe) probe(h host.Host, d *DialChecker, wait bool) {
+	defer func() {
+		if p.metadataCtx != nil && p.probed() {
+			_ = p.metadataCtx.Apply(meta.Checked(time.Now()))
+		}
+	}()
+	p.result, p.check = nil, nil
+	info, err := peer.AddrInfoFromP2pAddr(p.addr)
+	// If there is an error, the probe is not successful.
+	if err != nil {
+		p.result = &probeResult{}
+		return
+	}
+	if d != nil {
+		p.check = d.probe(*info)
+		return
+	}
+	if !wait && info.ID != h.ID() {
+		return
+	}
+	res := probePeer(context.Background(), h, *info, dialTimeout)
+	p.result = &res
+}
+
+// probed reports whether the node was probed, or a probe started.
+func (p *Probe) probed() bool {
+	return p.result != nil || p.check != nil
+}
+
+// probePeer dials a peer and, if successful, pings it and waits for identify
+// to learn the protocols it supports.
+func probePeer(ctx context.Context, h host.Host, i peer.AddrInfo, timeout time.Duration) probeResult {
+	// If self, report our own protocols without dialing. There is
+	// no connection, so no address is observed.
+	if h.ID() == i.ID {
+		protocols := h.Mux().Protocols()
+		sort.Strings(protocols)
+		return probeResult{ok: true, protocols: protocols}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := h.Connect(ctx, i); err != nil {
+		return probeResult{}
+	}
+	res := probeResult{ok: true}
+
+	if conns := h.Network().ConnsToPeer(i.ID); len(conns) > 0 {
+		res.observed = conns[0].RemoteMultiaddr()
+	}
+	waitIdentify(ctx, h, i.ID)
+	if protocols, err := h.Peerstore().GetProtocols(i.ID); err == nil {
+		sort.Strings(protocols)
+		res.protocols = protocols
+	}
+
+	// The latency is only reported if the peer answers pings.
+	pctx, pcancel := context.WithCancel(ctx)
+	defer pcancel()
+	select {
+	case r := <-ping.Ping(pctx, h, i.ID):
+		if r.Error == nil {
+			res.latency = r.RTT
+		}
+	case <-ctx.Done():
+	}
+	return res
+}
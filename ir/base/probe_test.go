@@ -0,0 +1,111 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+)
+
+func TestProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupHost(ctx, t)
+	s := setupHost(ctx, t)
+	ping.NewPingService(s)
+	addr := fmt.Sprintf("%s/p2p/%s", tcpAddr(s), s.ID().Pretty())
+
+	cases := []struct {
+		// Tag once assembled, and once probed periodically.
+		addr, assembled, tag string
+		// Protocol supported, address observed, if any, and whether the latency is recorded.
+		protocol string
+		observed xr.Node
+		latency  bool
+	}{
+		// Peers are not dialed with the VM locked, but by the periodic probe.
+		{addr, "probe", "probed", ping.ID, xr.String{Value: tcpAddr(s).String()}, true},
+		// The host itself is probed without dialing, so no address is observed.
+		{fmt.Sprintf("%s/p2p/%s", tcpAddr(c), c.ID().Pretty()), "probed", "probed", identify.ID, nil, false},
+		// Peers not dialable are not probed.
+		{unreachableAddr, "probe", "notProbed", "", nil, false},
+	}
+	for _, tc := range cases {
+		in := xr.Predicate{
+			Tag:   "probe",
+			Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "address"}, Value: xr.String{Value: tc.addr}}},
+		}
+		n, err := BaseGrammar.Assemble(ir.AssemblerContext{Grammar: BaseGrammar}, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := n.(*Probe)
+		if !xr.IsEqual(p.Disassemble(), in) {
+			t.Fatal("wrong node before probing", p.Disassemble())
+		}
+		ProbeTag.OnAssemble(ir.HookContext{Host: c}, p)
+		if tag := p.Disassemble().(xr.Predicate).Tag; tag != tc.assembled {
+			t.Fatalf("wrong node of %s once assembled: %s", tc.addr, tag)
+		}
+		if tc.assembled == "probe" && p.Metadata().LastChecked != 0 {
+			t.Fatal("node not probed recorded as checked")
+		}
+		runPeriodic(ProbeTag, ir.HookContext{Host: c}, p)
+		out := p.Disassemble().(xr.Predicate)
+		if out.Tag != tc.tag {
+			t.Fatalf("wrong probe of %s: %s", tc.addr, out.Tag)
+		}
+		if o := getNamed(out, xr.String{Value: "observed"}); !(o == nil && tc.observed == nil || xr.IsEqual(o, tc.observed)) {
+			t.Fatalf("wrong observed address probing %s: %v", tc.addr, o)
+		}
+		if l, ok := getNamed(out, xr.String{Value: "latency"}).(xr.Int); ok != tc.latency || ok && l.Int64() <= 0 {
+			t.Fatalf("wrong latency probing %s: %v", tc.addr, out)
+		}
+		if tc.protocol != "" {
+			protocols, ok := getNamed(out, xr.String{Value: "protocols"}).(xr.List)
+			if !ok || protocols.Elements.IndexOf(xr.String{Value: tc.protocol}) < 0 {
+				t.Fatal("protocols not recorded", out)
+			}
+		}
+		if p.Metadata().LastChecked == 0 {
+			t.Fatal("probe time not recorded")
+		}
+		// Probed nodes are assembled again, and replicated unmeasured.
+		if _, err := (ProbeAssembler{}).Assemble(ir.AssemblerContext{}, out); err != nil {
+			t.Fatal(err)
+		}
+		if !xr.IsEqual(p.Unmeasured(), in) {
+			t.Fatal("wrong unmeasured node", p.Unmeasured())
+		}
+	}
+
+	// Probes are run asynchronously with a DialChecker.
+	dc, err := NewDialChecker(ctx, c, 1, 1, 5*time.Second, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := busy(dc)
+	n, err := BaseGrammar.Assemble(ir.AssemblerContext{Grammar: BaseGrammar}, xr.Predicate{
+		Tag:   "probe",
+		Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "address"}, Value: xr.String{Value: addr}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := n.(*Probe)
+	ProbeTag.OnAssemble(ir.HookContext{Host: c, Keys: map[string]interface{}{DialCheckerKey: dc}}, p)
+	if tag := p.Disassemble().(xr.Predicate).Tag; tag != "probing" {
+		t.Fatal("probe should be pending", tag)
+	}
+	release()
+	<-p.check.done
+	if tag := p.Disassemble().(xr.Predicate).Tag; tag != "probed" {
+		t.Fatal("probe not resolved", tag)
+	}
+}
@@ -0,0 +1,160 @@
+package base
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// ProvidesTag is the Provides smart tag. The writer of the dict a Provides node
+// is stored in is attached to it as provider by the VM once assembled.
+var ProvidesTag = ir.SmartTag{
+	Tags:       []string{"provides"},
+	Assembler:  ProvidesAssembler{},
+	OnAssemble: attachProvider,
+}
+
+func attachProvider(ctx ir.HookContext, n ir.Node) {
+	if p, ok := n.(*Provides); ok {
+		p.provider = ctx.Writer
+	}
+}
+
+// Provides is a smart node. Like a DHT provider record, it announces
+// that its writer provides the content of a CID at some addresses.
+type Provides struct {
+	cid      cid.Cid
+	addrs    []ma.Multiaddr
+	provider peer.ID
+
+	metadataCtx *meta.Meta
+}
+
+// Cid returns the CID provided.
+func (p *Provides) Cid() cid.Cid {
+	return p.cid
+}
+
+// Addrs returns the public addresses of the provider.
+func (p *Provides) Addrs() []ma.Multiaddr {
+	return p.addrs
+}
+
+// Provider returns the provider, i.e. the writer of the node.
+func (p *Provides) Provider() peer.ID {
+	return p.provider
+}
+
+// Provides disassembles to a xr.Predicate of the form
+// provides(cid=CID:STRING, addrs=[MULTIADDRESS:STRING, ...], provider=PEERID:STRING)
+// where provider is omitted until the provider is attached.
+func (p Provides) Disassemble() xr.Node {
+	addrs := xr.List{Elements: xr.Nodes{}}
+	for _, a := range p.addrs {
+		addrs.Elements = append(addrs.Elements, xr.String{Value: a.String()})
+	}
+	named := xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "cid"}, Value: xr.String{Value: p.cid.String()}},
+		xr.Pair{Key: xr.String{Value: "addrs"}, Value: addrs},
+	}
+	if p.provider != "" {
+		named = append(named, xr.Pair{Key: xr.String{Value: "provider"}, Value: xr.String{Value: p.provider.String()}})
+	}
+	return xr.Predicate{Tag: "provides", Named: named}
+}
+
+func (p *Provides) Metadata() meta.MetadataInfo {
+	return p.metadataCtx.Get()
+}
+
+func (p *Provides) WritePretty(w io.Writer) error {
+	return p.Disassemble().WritePretty(w)
+}
+
+func (p *Provides) UpdateWith(ctx ir.UpdateContext, with ir.Node) error {
+	w, ok := with.(*Provides)
+	if !ok {
+		return fmt.Errorf("cannot update with a non-provides node")
+	}
+
+	// Update value
+	*p = *w
+	// Update metadata
+	p.metadataCtx.Update(w.metadataCtx)
+
+	return nil
+}
+
+type ProvidesAssembler struct{}
+
+// Provides assemble expects a predicate of the form:
+// provides(cid=CID, addrs=[MULTIADDRESS, ...])
+// Private and unroutable addresses are filtered out. The provider given, if any,
+// is ignored: the writer of the node is attached as provider instead.
+func (ProvidesAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
+	p, ok := srcNode.(xr.Predicate)
+	if !ok {
+		return nil, fmt.Errorf("smart-tags must be predicates")
+	}
+	if p.Tag != "provides" {
+		return nil, fmt.Errorf("not a provides smart tag")
+	}
+
+	// Check CID
+	cs, ok := getNamed(p, xr.String{Value: "cid"}).(xr.String)
+	if !ok {
+		return nil, fmt.Errorf("no cid provided")
+	}
+	c, err := cid.Decode(cs.Value)
+	if err != nil {
+		return nil, fmt.Errorf("no valid cid provided: %s", err)
+	}
+
+	// Check addresses
+	var addrs []ma.Multiaddr
+	if a := getNamed(p, xr.String{Value: "addrs"}); a != nil {
+		l, ok := a.(xr.List)
+		if !ok {
+			return nil, fmt.Errorf("addrs must be a list")
+		}
+		for _, e := range l.Elements {
+			maddr, err := parseAddress(e)
+			if err != nil {
+				return nil, fmt.Errorf("no valid multiaddr provided")
+			}
+			if isRoutable(maddr) {
+				addrs = append(addrs, maddr)
+			}
+		}
+	}
+
+	// Assemble metadata
+	m := meta.New()
+	if err := m.Apply(metadata...); err != nil {
+		return nil, err
+	}
+
+	return &Provides{cid: c, addrs: addrs, metadataCtx: m}, nil
+}
+
+// isRoutable returns true for public IP addresses and DNS addresses.
+func isRoutable(a ma.Multiaddr) bool {
+	if manet.IsPublicAddr(a) {
+		return true
+	}
+	for _, p := range a.Protocols() {
+		switch p.Code {
+		case ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR:
+			return true
+		}
+	}
+	return false
+}
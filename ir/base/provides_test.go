@@ -0,0 +1,83 @@
+package base
+
+import (
+	"testing"
+
+	p2ptestutil "github.com/libp2p/go-libp2p-netutil"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+)
+
+const testCid = "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+
+func TestProvides(t *testing.T) {
+	asm := ir.AssemblerContext{Grammar: BaseGrammar, Strict: true}
+	w, _ := p2ptestutil.RandTestBogusIdentity()
+	cases := []struct {
+		cid   string
+		addrs []string
+		// Addresses kept, or nil if the assembly fails.
+		kept []string
+	}{
+		{"notacid", nil, nil},
+		{testCid, []string{"notamultiaddr"}, nil},
+		{testCid, []string{}, []string{}},
+		// Private and unroutable addresses are filtered out.
+		{testCid, []string{
+			"/ip4/1.2.3.4/tcp/4001",
+			"/ip4/127.0.0.1/tcp/4001",
+			"/ip4/192.168.1.2/tcp/4001",
+			"/dns4/example.com/tcp/4001",
+		}, []string{"/ip4/1.2.3.4/tcp/4001", "/dns4/example.com/tcp/4001"}},
+	}
+	for _, tc := range cases {
+		addrs := xr.List{Elements: xr.Nodes{}}
+		for _, a := range tc.addrs {
+			addrs.Elements = append(addrs.Elements, xr.String{Value: a})
+		}
+		in := xr.Predicate{
+			Tag: "provides",
+			Named: xr.Pairs{
+				xr.Pair{Key: xr.String{Value: "cid"}, Value: xr.String{Value: tc.cid}},
+				xr.Pair{Key: xr.String{Value: "addrs"}, Value: addrs},
+			},
+		}
+		n, err := asm.Assemble(in)
+		if tc.kept == nil {
+			if err == nil {
+				t.Fatalf("provides of %s with %v should fail", tc.cid, tc.addrs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, ok := n.(*Provides)
+		if !ok {
+			t.Fatal("not assembled as provides", n)
+		}
+		if len(p.Addrs()) != len(tc.kept) {
+			t.Fatal("addresses not filtered", p.Addrs())
+		}
+		for i, a := range p.Addrs() {
+			if a.String() != tc.kept[i] {
+				t.Fatal("addresses not filtered", p.Addrs())
+			}
+		}
+
+		// The writer is attached as provider.
+		ProvidesTag.OnAssemble(ir.HookContext{Writer: w.ID()}, p)
+		if p.Provider() != w.ID() {
+			t.Fatal("provider not attached", p.Provider())
+		}
+		out := p.Disassemble().(xr.Predicate)
+		if !xr.IsEqual(getNamed(out, xr.String{Value: "provider"}), xr.String{Value: w.ID().String()}) {
+			t.Fatal("provider not disassembled", out)
+		}
+		// Disassembled nodes are assembled again.
+		if n, err := asm.Assemble(out); err != nil || !n.(*Provides).Cid().Equals(p.Cid()) {
+			t.Fatal("provides not assembled again", err)
+		}
+	}
+}
@@ -29,6 +29,8 @@ type Reachable struct {
 	verifiedDial     bool
 	verifiedFail     bool
 	verifiedFailConn bool
+	// Asynchronous dial check, if dialed with a DialChecker.
+	dial *dialCheck
 
 	metadataCtx *meta.Meta
 }
@@ -38,11 +40,21 @@ type Reachable struct {
 // connected(address=MULTIADDRESS:STRING) if connected checks.
 // notConnected(address=MULTIADDRESS:STRING) if connected fails.
 // notDialable(address=MULTIADDRESS:STRING) if dial fails.
+// pending(address=MULTIADDRESS:STRING) if an asynchronous dial check has not ended.
 func (r Reachable) Disassemble() xr.Node {
 	var tag string
 
 	// Set right tag for predicate
-	if r.verifiedConn {
+	if r.dial != nil {
+		switch done, ok := r.dial.result(); {
+		case !done:
+			tag = "pending"
+		case ok:
+			tag = "dialed"
+		default:
+			tag = "notDialable"
+		}
+	} else if r.verifiedConn {
 		tag = "connected"
 	} else if r.verifiedDial {
 		tag = "dialed"
@@ -105,11 +117,21 @@ func getNamed(p xr.Predicate, key xr.Node) xr.Node {
 	return nil
 }
 
+// parseAddress parses the address of a reachable node, given as a
+// multiaddr predicate or, in its disassembled form, as a string.
+func parseAddress(addr xr.Node) (ma.Multiaddr, error) {
+	if s, ok := addr.(xr.String); ok {
+		return ma.NewMultiaddr(s.Value)
+	}
+	return parse.ParseMultiaddr(&parse.ParseCtx{}, addr)
+}
+
 type ReachableAssembler struct{}
 
 // Reachable assemble expects a predicate of the form:
 // connectivity(address=MULTIADDRESS) or
 // dialable(address=MULTIADDRESS)
+// or any of the verified forms resulting from disassembling a Reachable.
 // See Disassemble() for more info on the resulting predicates after check.
 func (ReachableAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
 	// Reachable receives a predicate
@@ -121,13 +143,22 @@ func (ReachableAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, met
 	// Get tag and positional arguments.
 	tag := p.Tag
 	addr := getNamed(p, xr.String{Value: "address"})
-	// Check tag
-	if tag != "connectivity" && tag != "dialable" {
+	// Check tag. The disassembled forms of a verified node are also
+	// accepted so records can be copied between VMs (e.g. replicas).
+	// The result of the verification is not kept, the node is verified
+	// again by the VM that assembles it.
+	var verifyConn, verifyDial bool
+	switch tag {
+	case "connectivity", "connected", "notConnected":
+		verifyConn = true
+	case "dialable", "dialed", "notDialable", "pending":
+		verifyDial = true
+	default:
 		return nil, fmt.Errorf("not a reachable smart tag")
 	}
 
 	// Check multiaddress
-	maddr, err := parse.ParseMultiaddr(&parse.ParseCtx{}, addr)
+	maddr, err := parseAddress(addr)
 	if err != nil {
 		return nil, fmt.Errorf("no valid multiaddr provided")
 	}
@@ -140,14 +171,61 @@ func (ReachableAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, met
 
 	return &Reachable{
 		addr:        maddr,
-		verifyConn:  tag == "connectivity",
-		verifyDial:  tag == "dialable",
+		verifyConn:  verifyConn,
+		verifyDial:  verifyDial,
 		metadataCtx: m,
 	}, nil
 }
 
+const (
+	// reverifyPeriod is the default period of the re-verification of Reachable nodes.
+	reverifyPeriod = 60 * time.Second
+	// reverifyStaleness is the default time after which a Reachable node is re-verified.
+	reverifyStaleness = 5 * time.Minute
+)
+
+// ReachableTag is the Reachable smart tag. Reachable nodes are verified by the VM
+// once assembled, and verified again periodically once their result is stale,
+// so connected nodes become notConnected (and dialed nodes notDialable), and
+// vice versa. The period and staleness can be configured in the VM (see
+// vm.HookPeriod and vm.HookStaleness).
+var ReachableTag = ir.SmartTag{
+	Tags:       ReachableTags,
+	Assembler:  ReachableAssembler{},
+	OnAssemble: verifyReachable,
+	Periodic:   reverifyReachable,
+	Period:     reverifyPeriod,
+	Staleness:  reverifyStaleness,
+}
+
+func verifyReachable(ctx ir.HookContext, n ir.Node) {
+	if r, ok := n.(*Reachable); ok {
+		r.verify(ctx.Host, dialCheckerOf(ctx))
+	}
+}
+
+// reverifyReachable verifies a copy of the node with its address, so the
+// VM can run the verification without the lock, and then sets the result
+// in the node if it still checks the same address.
+func reverifyReachable(ctx ir.HookContext, n ir.Node) (func(), func()) {
+	r, ok := n.(*Reachable)
+	if !ok {
+		return nil, nil
+	}
+	c := &Reachable{addr: r.addr, verifyConn: r.verifyConn, verifyDial: r.verifyDial}
+	d := dialCheckerOf(ctx)
+	check := func() { c.verify(ctx.Host, d) }
+	apply := func() {
+		if r.addr.Equal(c.addr) && r.verifyConn == c.verifyConn && r.verifyDial == c.verifyDial {
+			r.setResult(c)
+		}
+	}
+	return check, apply
+}
+
 // TriggerReachable triggers the execution of Reachable verifications
 // over a dict and adds the appropiate flag to Nodes that don't pass the verification.
+// The VM runs the verifications through the OnAssemble hook of ReachableTag.
 func TriggerReachable(d *ir.Dict, h host.Host) {
 	// For each pair.
 	for _, p := range d.Pairs {
@@ -159,7 +237,7 @@ func TriggerReachable(d *ir.Dict, h host.Host) {
 func triggerReachable(n ir.Node, h host.Host) {
 	switch n1 := n.(type) {
 	case *Reachable:
-		n1.verify(h)
+		n1.verify(h, nil)
 	case *ir.Dict:
 		TriggerReachable(n1, h)
 	case *ir.List:
@@ -174,9 +252,34 @@ func verifyList(s *ir.List, h host.Host) {
 	}
 }
 
+// checked reports whether the node was verified, or a dial check started.
+func (r *Reachable) checked() bool {
+	return r.verifiedConn || r.verifiedDial || r.verifiedFail || r.verifiedFailConn || r.dial != nil
+}
+
+// setResult replaces the result of the previous verification with the one
+// of another node and records the time of the verification in metadata.
+func (r *Reachable) setResult(c *Reachable) {
+	r.verifiedConn, r.verifiedDial = c.verifiedConn, c.verifiedDial
+	r.verifiedFail, r.verifiedFailConn = c.verifiedFail, c.verifiedFailConn
+	r.dial = c.dial
+	if r.metadataCtx != nil && r.checked() {
+		_ = r.metadataCtx.Apply(meta.Checked(time.Now()))
+	}
+}
+
 // trigger the verification of reachable
 // updates flags according to the verification result
-func (r *Reachable) verify(h host.Host) {
+// and records the time of the verification in metadata.
+// If a DialChecker is given, dial checks are run asynchronously. If its queue
+// is full, the node is left unverified, and not recorded as checked so it is
+// verified again by the next periodic verification.
+func (r *Reachable) verify(h host.Host, d *DialChecker) {
+	defer func() {
+		if r.metadataCtx != nil && r.checked() {
+			_ = r.metadataCtx.Apply(meta.Checked(time.Now()))
+		}
+	}()
 	info, err := peer.AddrInfoFromP2pAddr(r.addr)
 	// If there is an error, the verification is not successful.
 	if err != nil {
@@ -186,8 +289,13 @@ func (r *Reachable) verify(h host.Host) {
 
 	// If dialable verification enabled and not checked.
 	if r.verifyDial && !r.verifiedDial {
+		// The node is pending until the asynchronous check ends.
+		if d != nil {
+			r.dial = d.check(*info)
+			return
+		}
 		// Set verifyFail if the verification failed.
-		if c := checkIfDialable(h, *info); !c {
+		if c := checkIfDialable(context.Background(), h, *info, dialTimeout); !c {
 			r.verifiedFail = true
 			return
 		}
@@ -207,21 +315,6 @@ func (r *Reachable) verify(h host.Host) {
 	}
 }
 
-// CheckIfdialable Checks if peer reachable with 5s timeout.
-func checkIfDialable(h host.Host, i peer.AddrInfo) bool {
-	// If self, consider as reachable and don't try to connect
-	if h.ID() == i.ID {
-		return true
-	}
-	reachable := false
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	if err := h.Connect(ctx, i); err == nil {
-		reachable = true
-	}
-	cancel()
-	return reachable
-}
-
 // CheckIfConnected checks if we are currently connected to a peer.
 func checkIfConnected(h host.Host, i peer.AddrInfo) bool {
 	// If self, consider as connected and don't try to connect
@@ -9,6 +9,7 @@ import (
 	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	xr "github.com/libp2p/go-routing-language/syntax"
+	ma "github.com/multiformats/go-multiaddr"
 
 	"github.com/libp2p/go-smart-record/ir"
 )
@@ -23,6 +24,16 @@ func setupHost(ctx context.Context, t *testing.T) host.Host {
 	return h
 }
 
+// tcpAddr returns a TCP address of a host, so dials don't depend on other transports.
+func tcpAddr(h host.Host) ma.Multiaddr {
+	for _, a := range h.Addrs() {
+		if _, err := a.ValueForProtocol(ma.P_TCP); err == nil {
+			return a
+		}
+	}
+	return h.Addrs()[0]
+}
+
 func reachableNode(addr string, conn bool) xr.Node {
 	var tag string
 	maddr1 := xr.Predicate{
@@ -240,3 +251,121 @@ func TestTriggerDisassemble(t *testing.T) {
 		t.Errorf("notConnected predicate didn't disassemble correctly")
 	}
 }
+
+func TestAssembleVerified(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupHost(ctx, t)
+	s := setupHost(ctx, t)
+	addr := s.Addrs()[0]
+	for _, a := range s.Addrs() {
+		if _, err := a.ValueForProtocol(ma.P_TCP); err == nil {
+			addr = a
+			break
+		}
+	}
+	reachable := fmt.Sprintf("%s/p2p/%s", addr.String(), s.ID().Pretty())
+
+	n, err := BaseGrammar.Assemble(ir.AssemblerContext{Grammar: BaseGrammar}, reachableNode(reachable, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.(*Reachable).verify(c, nil)
+	verified := n.Disassemble()
+	if verified.(xr.Predicate).Tag != "dialed" {
+		t.Fatal("dialable node not verified successfully", verified)
+	}
+
+	// The verified node can be assembled again and its verification
+	// is triggered from scratch.
+	n2, err := BaseGrammar.Assemble(ir.AssemblerContext{Grammar: BaseGrammar}, verified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := n2.(*Reachable)
+	if !ok {
+		t.Fatal("verified node not assembled to reachable")
+	}
+	if !r.verifyDial || r.verifiedDial {
+		t.Fatal("verification flags not set correctly", r)
+	}
+	r.verify(c, nil)
+	if !xr.IsEqual(verified, r.Disassemble()) {
+		t.Fatal("reassembled node not verified successfully", r.Disassemble())
+	}
+}
+
+func TestStrictReachable(t *testing.T) {
+	// Typo in the name of the address argument.
+	in := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "addr"}, Value: xr.Predicate{
+				Tag:   "dialable",
+				Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "adress"}, Value: xr.String{Value: unreachableAddr}}},
+			}},
+		},
+	}
+	n, err := ir.AssemblerContext{Grammar: BaseGrammar}.Assemble(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.(*ir.Dict).Pairs[0].Value.(*ir.Predicate); !ok {
+		t.Fatal("invalid smart tag should be assembled as a predicate in lenient mode")
+	}
+	if _, err := (ir.AssemblerContext{Grammar: BaseGrammar, Strict: true}).Assemble(in); err == nil {
+		t.Fatal("invalid smart tag should fail in strict mode")
+	}
+	for _, tag := range ReachableTags {
+		if _, ok := BaseTags.Lookup(tag); !ok {
+			t.Fatalf("tag %s not registered", tag)
+		}
+	}
+}
+
+// runPeriodic runs the check of the periodic hook of a smart tag for a node
+// and applies its result, like the VM.
+func runPeriodic(st ir.SmartTag, ctx ir.HookContext, n ir.Node) {
+	check, apply := st.Periodic(ctx, n)
+	check()
+	apply()
+}
+
+func TestReverifyReachable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupHost(ctx, t)
+	s := setupHost(ctx, t)
+	addr := fmt.Sprintf("%s/p2p/%s", tcpAddr(s), s.ID().Pretty())
+	if err := c.Connect(ctx, *host.InfoFromHost(s)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := BaseGrammar.Assemble(ir.AssemblerContext{Grammar: BaseGrammar}, reachableNode(addr, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := n.(*Reachable)
+	ReachableTag.OnAssemble(ir.HookContext{Host: c}, r)
+	if tag := r.Disassemble().(xr.Predicate).Tag; tag != "connected" {
+		t.Fatal("wrong verification", tag)
+	}
+	if r.Metadata().LastChecked == 0 {
+		t.Fatal("verification time not recorded")
+	}
+
+	// Results flip when the peer disconnects and connects again.
+	if err := c.Network().ClosePeer(s.ID()); err != nil {
+		t.Fatal(err)
+	}
+	runPeriodic(ReachableTag, ir.HookContext{Host: c}, r)
+	if tag := r.Disassemble().(xr.Predicate).Tag; tag != "notConnected" {
+		t.Fatal("wrong re-verification after disconnecting", tag)
+	}
+	if err := c.Connect(ctx, *host.InfoFromHost(s)); err != nil {
+		t.Fatal(err)
+	}
+	runPeriodic(ReachableTag, ir.HookContext{Host: c}, r)
+	if tag := r.Disassemble().(xr.Predicate).Tag; tag != "connected" {
+		t.Fatal("wrong re-verification after connecting", tag)
+	}
+}
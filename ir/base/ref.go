@@ -0,0 +1,128 @@
+package base
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// RefTag is the Ref smart tag.
+var RefTag = ir.SmartTag{
+	Tags:      []string{"ref"},
+	Assembler: RefAssembler{},
+}
+
+// Ref is a smart node. It references the dict stored by a writer in another
+// key, so records can link to each other instead of hard-coding key names.
+// Servers check the dict referenced exists when a writer updates its record,
+// and can resolve references when getting a record, inlining the dict
+// referenced. Like any other node, references expire with their metadata,
+// and the dict referenced may expire before them.
+type Ref struct {
+	key    string
+	writer peer.ID
+
+	metadataCtx *meta.Meta
+}
+
+// Key returns the key referenced.
+func (r *Ref) Key() string {
+	return r.key
+}
+
+// Writer returns the writer of the dict referenced.
+func (r *Ref) Writer() peer.ID {
+	return r.writer
+}
+
+// Ref disassembles to a xr.Predicate of the form
+// ref(key=STRING, writer=PEERID:STRING)
+// Once resolved, the dict referenced is added as value=DICT, or the reason
+// it couldn't be resolved as error=STRING.
+func (r Ref) Disassemble() xr.Node {
+	return xr.Predicate{
+		Tag: "ref",
+		Named: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "key"}, Value: xr.String{Value: r.key}},
+			xr.Pair{Key: xr.String{Value: "writer"}, Value: xr.String{Value: r.writer.String()}},
+		},
+	}
+}
+
+func (r *Ref) Metadata() meta.MetadataInfo {
+	return r.metadataCtx.Get()
+}
+
+func (r *Ref) WritePretty(w io.Writer) error {
+	return r.Disassemble().WritePretty(w)
+}
+
+func (r *Ref) UpdateWith(ctx ir.UpdateContext, with ir.Node) error {
+	w, ok := with.(*Ref)
+	if !ok {
+		return fmt.Errorf("cannot update with a non-ref node")
+	}
+
+	// Update value
+	*r = *w
+	// Update metadata
+	r.metadataCtx.Update(w.metadataCtx)
+
+	return nil
+}
+
+type RefAssembler struct{}
+
+// Ref assemble expects a predicate of the form:
+// ref(key=STRING, writer=PEERID)
+// or any of the forms resulting from resolving a Ref, whose value or error is
+// dropped. If the writer is omitted, the writer of the record is referenced.
+// If the VM can look up records, the dict referenced must exist.
+func (RefAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
+	p, ok := srcNode.(xr.Predicate)
+	if !ok {
+		return nil, fmt.Errorf("smart-tags must be predicates")
+	}
+	if p.Tag != "ref" {
+		return nil, fmt.Errorf("not a ref smart tag")
+	}
+
+	// Check key
+	k, ok := getNamed(p, xr.String{Value: "key"}).(xr.String)
+	if !ok || k.Value == "" {
+		return nil, fmt.Errorf("no key provided")
+	}
+
+	// Check writer
+	writer := ctx.Writer
+	if w := getNamed(p, xr.String{Value: "writer"}); w != nil {
+		ws, ok := w.(xr.String)
+		if !ok {
+			return nil, fmt.Errorf("writer must be a string")
+		}
+		id, err := peer.Decode(ws.Value)
+		if err != nil {
+			return nil, fmt.Errorf("no valid writer provided: %s", err)
+		}
+		writer = id
+	}
+	if writer == "" {
+		return nil, fmt.Errorf("no writer provided")
+	}
+	if ctx.Exists != nil && !ctx.Exists(k.Value, writer) {
+		return nil, &ir.AssemblyError{Reason: fmt.Sprintf("reference to missing record of %s in %s", writer, k.Value), Fatal: true}
+	}
+
+	// Assemble metadata
+	m := meta.New()
+	if err := m.Apply(metadata...); err != nil {
+		return nil, err
+	}
+
+	return &Ref{key: k.Value, writer: writer, metadataCtx: m}, nil
+}
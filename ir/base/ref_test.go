@@ -0,0 +1,54 @@
+package base
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	p2ptestutil "github.com/libp2p/go-libp2p-netutil"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+)
+
+func ref(k string, writer peer.ID) xr.Predicate {
+	p := xr.Predicate{Tag: "ref", Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "key"}, Value: xr.String{Value: k}}}}
+	if writer != "" {
+		p.Named = append(p.Named, xr.Pair{Key: xr.String{Value: "writer"}, Value: xr.String{Value: writer.String()}})
+	}
+	return p
+}
+
+func TestRef(t *testing.T) {
+	w1, _ := p2ptestutil.RandTestBogusIdentity()
+	w2, _ := p2ptestutil.RandTestBogusIdentity()
+	asm := ir.AssemblerContext{Grammar: BaseGrammar, Strict: true}
+	if _, err := asm.Assemble(ref("k", "")); err == nil {
+		t.Fatal("ref without writer should fail")
+	}
+
+	// The writer of the record is referenced by default.
+	asm.Writer = w1.ID()
+	n, err := asm.Assemble(ref("k", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := n.(*Ref); r.Key() != "k" || r.Writer() != w1.ID() {
+		t.Fatal("wrong reference", r.Key(), r.Writer())
+	}
+	// The disassembled ref assembles back to the same ref.
+	if r, err := asm.Assemble(n.Disassemble()); err != nil || !xr.IsEqual(r.Disassemble(), n.Disassemble()) {
+		t.Fatal("ref not reassembled", err)
+	}
+
+	// References to missing records are rejected if the VM can look them up.
+	asm.Exists = func(k string, writer peer.ID) bool { return k == "k" && writer == w2.ID() }
+	if _, err := asm.Assemble(ref("k", w2.ID())); err != nil {
+		t.Fatal(err)
+	}
+	_, err = asm.Assemble(ref("k", ""))
+	var ae *ir.AssemblyError
+	if !errors.As(err, &ae) || !ae.Fatal {
+		t.Fatal("reference to missing record should fail", err)
+	}
+}
@@ -0,0 +1,197 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// SupportsTags are the tags assembled as Supports smart tags, including
+// the disassembled forms of a verified node.
+var SupportsTags = []string{"supports", "supported", "unsupported"}
+
+// SupportsTag is the Supports smart tag. The VM checks the protocols of the
+// peer once the node is assembled, and checks them again once the result is
+// stale, as peers start or stop supporting protocols when they upgrade.
+var SupportsTag = ir.SmartTag{
+	Tags:       SupportsTags,
+	Assembler:  SupportsAssembler{},
+	OnAssemble: verifySupports,
+	Periodic:   reverifySupports,
+	Period:     reverifyPeriod,
+	Staleness:  reverifyStaleness,
+}
+
+// verifySupports runs with the VM locked, so it reads the peerstore
+// without waiting for identify (see Supports.verify).
+func verifySupports(ctx ir.HookContext, n ir.Node) {
+	if s, ok := n.(*Supports); ok {
+		s.verify(ctx.Host, false)
+	}
+}
+
+// reverifySupports verifies a copy of the node, so the VM can run the
+// verification without the lock, and then sets the result in the node
+// if it still checks the same peer and protocol.
+func reverifySupports(ctx ir.HookContext, n ir.Node) (func(), func()) {
+	s, ok := n.(*Supports)
+	if !ok {
+		return nil, nil
+	}
+	c := &Supports{peer: s.peer, protocol: s.protocol}
+	check := func() { c.verify(ctx.Host, true) }
+	apply := func() {
+		if s.peer == c.peer && s.protocol == c.protocol {
+			s.verified, s.supported = c.verified, c.supported
+			if s.metadataCtx != nil && s.verified {
+				_ = s.metadataCtx.Apply(meta.Checked(time.Now()))
+			}
+		}
+	}
+	return check, apply
+}
+
+// Supports is a smart node. It checks if a peer supports a protocol,
+// according to the protocols the host learnt through identify.
+type Supports struct {
+	peer     peer.ID
+	protocol string
+	// Has it been verified?
+	verified  bool
+	supported bool
+
+	metadataCtx *meta.Meta
+}
+
+// Supports disassembles to a xr.Predicate of the form
+// supports(peer=PEERID:STRING, protocol=PROTOCOLID:STRING) if not verified yet.
+// supported(peer=PEERID:STRING, protocol=PROTOCOLID:STRING) if the peer supports the protocol.
+// unsupported(peer=PEERID:STRING, protocol=PROTOCOLID:STRING) if it doesn't, or it is not known.
+func (s Supports) Disassemble() xr.Node {
+	tag := "supports"
+	if s.verified {
+		if s.supported {
+			tag = "supported"
+		} else {
+			tag = "unsupported"
+		}
+	}
+	return xr.Predicate{
+		Tag: tag,
+		Named: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "peer"}, Value: xr.String{Value: s.peer.String()}},
+			xr.Pair{Key: xr.String{Value: "protocol"}, Value: xr.String{Value: s.protocol}},
+		},
+	}
+}
+
+func (s *Supports) Metadata() meta.MetadataInfo {
+	return s.metadataCtx.Get()
+}
+
+func (s *Supports) WritePretty(w io.Writer) error {
+	return s.Disassemble().WritePretty(w)
+}
+
+func (s *Supports) UpdateWith(ctx ir.UpdateContext, with ir.Node) error {
+	w, ok := with.(*Supports)
+	if !ok {
+		return fmt.Errorf("cannot update with a non-supports node")
+	}
+
+	// Update value
+	*s = *w
+	// Update metadata
+	s.metadataCtx.Update(w.metadataCtx)
+
+	return nil
+}
+
+type SupportsAssembler struct{}
+
+// Supports assemble expects a predicate of the form:
+// supports(peer=PEERID, protocol=PROTOCOLID)
+// or any of the verified forms resulting from disassembling a Supports,
+// which are verified again.
+func (SupportsAssembler) Assemble(ctx ir.AssemblerContext, srcNode xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
+	p, ok := srcNode.(xr.Predicate)
+	if !ok {
+		return nil, fmt.Errorf("smart-tags must be predicates")
+	}
+	switch p.Tag {
+	case "supports", "supported", "unsupported":
+	default:
+		return nil, fmt.Errorf("not a supports smart tag")
+	}
+
+	// Check peer and protocol
+	ps, ok := getNamed(p, xr.String{Value: "peer"}).(xr.String)
+	if !ok {
+		return nil, fmt.Errorf("no peer provided")
+	}
+	id, err := peer.Decode(ps.Value)
+	if err != nil {
+		return nil, fmt.Errorf("no valid peer provided")
+	}
+	proto, ok := getNamed(p, xr.String{Value: "protocol"}).(xr.String)
+	if !ok || proto.Value == "" {
+		return nil, fmt.Errorf("no protocol provided")
+	}
+
+	// Assemble metadata
+	m := meta.New()
+	if err := m.Apply(metadata...); err != nil {
+		return nil, err
+	}
+
+	return &Supports{peer: id, protocol: proto.Value, metadataCtx: m}, nil
+}
+
+// verify checks if the peer supports the protocol and records the time
+// of the verification in metadata. If wait is set, it waits for identify
+// to run on the connections to the peer. Otherwise, if identify is still
+// running, the node is left unverified, and not recorded as checked so it
+// is verified again by the next periodic verification.
+func (s *Supports) verify(h host.Host, wait bool) {
+	supported, ok := checkSupports(h, s.peer, s.protocol, wait)
+	if !ok {
+		return
+	}
+	if s.metadataCtx != nil {
+		_ = s.metadataCtx.Apply(meta.Checked(time.Now()))
+	}
+	s.supported, s.verified = supported, true
+}
+
+// checkSupports checks in the peerstore if a peer supports a protocol,
+// waiting for identify to run on the connections to the peer if wait is set.
+// It returns false if identify didn't end.
+func checkSupports(h host.Host, p peer.ID, proto string, wait bool) (supported, ok bool) {
+	// If self, check the protocols we handle.
+	if h.ID() == p {
+		for _, pr := range h.Mux().Protocols() {
+			if pr == proto {
+				return true, true
+			}
+		}
+		return false, true
+	}
+	if wait {
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		defer cancel()
+		waitIdentify(ctx, h, p)
+	}
+	if !identified(h, p) {
+		return false, false
+	}
+	protos, err := h.Peerstore().SupportsProtocols(p, proto)
+	return err == nil && len(protos) > 0, true
+}
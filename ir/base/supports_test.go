@@ -0,0 +1,106 @@
+package base
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+)
+
+func TestSupports(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn := mocknet.New(ctx)
+	c, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetStreamHandler("/test/1.0.0", func(s network.Stream) { s.Close() })
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mn.ConnectPeers(c.ID(), s.ID()); err != nil {
+		t.Fatal(err)
+	}
+	// Nodes are verified once identify ends.
+	waitIdentify(ctx, c, s.ID())
+
+	cases := []struct {
+		peer, proto, tag string
+	}{
+		// Invalid peers fail to assemble.
+		{"notapeer", "/test/1.0.0", ""},
+		{s.ID().String(), "/test/1.0.0", "supported"},
+		{s.ID().String(), "/other/1.0.0", "unsupported"},
+		// Protocols of peers never identified are not known.
+		{w.ID().String(), "/test/1.0.0", "unsupported"},
+		{c.ID().String(), "/ipfs/id/1.0.0", "supported"},
+	}
+	for _, tc := range cases {
+		in := xr.Predicate{
+			Tag: "supports",
+			Named: xr.Pairs{
+				xr.Pair{Key: xr.String{Value: "peer"}, Value: xr.String{Value: tc.peer}},
+				xr.Pair{Key: xr.String{Value: "protocol"}, Value: xr.String{Value: tc.proto}},
+			},
+		}
+		n, err := BaseGrammar.Assemble(ir.AssemblerContext{Grammar: BaseGrammar, Strict: true}, in)
+		if tc.tag == "" {
+			if err == nil {
+				t.Fatalf("supports of %s for %s should fail", tc.proto, tc.peer)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sn, ok := n.(*Supports)
+		if !ok {
+			t.Fatal("not assembled as supports", n)
+		}
+		SupportsTag.OnAssemble(ir.HookContext{Host: c}, sn)
+		out := sn.Disassemble()
+		if tag := out.(xr.Predicate).Tag; tag != tc.tag {
+			t.Fatalf("wrong verification of %s for %s: %s", tc.proto, tc.peer, tag)
+		}
+		if sn.Metadata().LastChecked == 0 {
+			t.Fatal("verification time not recorded")
+		}
+		// Verified nodes are assembled again.
+		if _, err := (SupportsAssembler{}).Assemble(ir.AssemblerContext{}, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Periodic verifications wait for identify.
+	w.SetStreamHandler("/test/1.0.0", func(s network.Stream) { s.Close() })
+	if _, err := mn.ConnectPeers(c.ID(), w.ID()); err != nil {
+		t.Fatal(err)
+	}
+	n, err := BaseGrammar.Assemble(ir.AssemblerContext{Grammar: BaseGrammar}, xr.Predicate{
+		Tag: "supports",
+		Named: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "peer"}, Value: xr.String{Value: w.ID().String()}},
+			xr.Pair{Key: xr.String{Value: "protocol"}, Value: xr.String{Value: "/test/1.0.0"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runPeriodic(SupportsTag, ir.HookContext{Host: c}, n)
+	if tag := n.Disassemble().(xr.Predicate).Tag; tag != "supported" {
+		t.Fatal("wrong periodic verification", tag)
+	}
+}
@@ -2,6 +2,10 @@ package ir
 
 import (
 	"testing"
+	"time"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
 )
 
 func TestUpdateDictDisjointPairs(t *testing.T) {
@@ -82,3 +86,43 @@ func TestUpdate(t *testing.T) {
 		t.Errorf("expecting %v, got %v", exp, d1)
 	}
 }
+
+func TestMergeLatest(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	assemble := func(n xr.Node, exp uint64) Node {
+		out, err := SyntacticGrammar.Assemble(AssemblerContext{Grammar: SyntacticGrammar}, n, meta.Expiration(exp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	older := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "a"}, Value: xr.String{Value: "old"}},
+		xr.Pair{Key: xr.String{Value: "b"}, Value: xr.List{Elements: xr.Nodes{xr.NewInt64(1)}}},
+	}}
+	newer := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "a"}, Value: xr.String{Value: "new"}},
+		xr.Pair{Key: xr.String{Value: "b"}, Value: xr.List{Elements: xr.Nodes{xr.NewInt64(2)}}},
+	}}
+	exp := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "a"}, Value: xr.String{Value: "new"}},
+		xr.Pair{Key: xr.String{Value: "b"}, Value: xr.List{Elements: xr.Nodes{xr.NewInt64(1), xr.NewInt64(2)}}},
+	}}
+
+	// The result is the same regardless of the order of the merge.
+	out1 := MergeLatest(DefaultUpdateContext{}, assemble(older, now+10), assemble(newer, now+20))
+	out2 := MergeLatest(DefaultUpdateContext{}, assemble(newer, now+20), assemble(older, now+10))
+	if !xr.IsEqual(out1.Disassemble(), exp) || !xr.IsEqual(out2.Disassemble(), exp) {
+		t.Fatalf("merge didn't keep the latest values: %v, %v", out1.Disassemble(), out2.Disassemble())
+	}
+	if out1.Metadata().ExpirationTime != now+20 || out2.Metadata().ExpirationTime != now+20 {
+		t.Fatal("merge didn't keep the latest expiration")
+	}
+
+	// Ties are broken consistently.
+	out1 = MergeLatest(DefaultUpdateContext{}, assemble(older, now+10), assemble(newer, now+10))
+	out2 = MergeLatest(DefaultUpdateContext{}, assemble(newer, now+10), assemble(older, now+10))
+	if !xr.IsEqual(out1.Disassemble(), out2.Disassemble()) {
+		t.Fatalf("merge with same expiration is not deterministic: %v, %v", out1.Disassemble(), out2.Disassemble())
+	}
+}
@@ -17,6 +17,7 @@ type Meta struct {
 // nodes. For instance, we can use a map[string]metadataType and add a .RegisterMetadataType.
 type metadataContext struct {
 	expirationTime expirationTime // Timestamp of expiration of the node.
+	lastChecked    lastChecked    // Timestamp of the last verification of the node.
 }
 
 // MetadataInfo is a container for the reporting of the current
@@ -24,6 +25,7 @@ type metadataContext struct {
 // internal value type, not the metadataType
 type MetadataInfo struct {
 	ExpirationTime uint64
+	LastChecked    uint64
 }
 
 // Metadata option applies metaadata to a smart node.
@@ -83,10 +85,12 @@ func (m *metadataContext) getMetadata() MetadataInfo {
 
 	return MetadataInfo{
 		ExpirationTime: m.expirationTime.value,
+		LastChecked:    m.lastChecked.value,
 	}
 }
 
 // update the metadata of a node conveniently when it receives an update.
 func (m *metadataContext) update(with *metadataContext) {
 	m.expirationTime = m.expirationTime.update(with.expirationTime).(expirationTime)
+	m.lastChecked = m.lastChecked.update(with.lastChecked).(lastChecked)
 }
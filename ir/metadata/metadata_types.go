@@ -22,6 +22,16 @@ func TTL(value time.Duration) Metadata {
 	}
 }
 
+// Expiration sets the absolute expiration time of the node in metadata,
+// as a Unix timestamp in seconds. It is used to replicate nodes keeping
+// the expiration time set by the original TTL.
+func Expiration(value uint64) Metadata {
+	return func(m *metadataContext) error {
+		m.expirationTime.value = value
+		return nil
+	}
+}
+
 // update logic for expirationTime metadata type
 func (t expirationTime) update(with metadataType) metadataType {
 	withT, ok := with.(expirationTime)
@@ -36,3 +46,31 @@ func (t expirationTime) update(with metadataType) metadataType {
 	t.value = withT.value
 	return t
 }
+
+// lastChecked determines the last time a smart tag was verified.
+type lastChecked struct {
+	value uint64
+}
+
+// Checked sets the time a smart tag was last verified, as a Unix timestamp in seconds.
+func Checked(t time.Time) Metadata {
+	return func(m *metadataContext) error {
+		m.lastChecked.value = uint64(t.Unix())
+		return nil
+	}
+}
+
+// update logic for lastChecked metadata type
+func (t lastChecked) update(with metadataType) metadataType {
+	withT, ok := with.(lastChecked)
+	// If entered wrong type to update do nothing and return metadata as-is
+	if !ok {
+		return t
+	}
+	// Keep the latest verification.
+	if withT.value < t.value {
+		return t
+	}
+	t.value = withT.value
+	return t
+}
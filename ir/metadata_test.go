@@ -225,3 +225,22 @@ func TestListMetadataUpdate(t *testing.T) {
 		t.Fatal("Update with no ttl not updated successfully in node:", m.ExpirationTime, ds1.Metadata().ExpirationTime, now)
 	}
 }
+
+func TestExpirationMetadata(t *testing.T) {
+	d := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "x"}, Value: xr.NewInt64(1)},
+		},
+	}
+	exp := uint64(time.Now().Unix()) + 1000
+	ds, err := SyntacticGrammar.Assemble(AssemblerContext{Grammar: SyntacticGrammar}, d, meta.Expiration(exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Metadata().ExpirationTime != exp {
+		t.Fatal("Expiration not set successfully in dict:", ds.Metadata().ExpirationTime, exp)
+	}
+	if v := ds.(*Dict).Pairs[0].Value; v.Metadata().ExpirationTime != exp {
+		t.Fatal("Expiration not set successfully in dict element:", v.Metadata().ExpirationTime, exp)
+	}
+}
@@ -14,6 +14,51 @@ type Node interface {
 
 type Nodes []Node
 
+// Collection is implemented by smart nodes holding children that expire on
+// their own, like the entries of a log. Instead of expiring as a whole, they
+// are garbage collected, merged and fragmented child by child, so their
+// UpdateWith must merge the children of both nodes regardless of the order.
+type Collection interface {
+	Node
+	// Children returns the children of the collection.
+	Children() Nodes
+	// Remove removes the children for which f returns true.
+	Remove(f func(Node) bool)
+	// Split splits the collection into collections whose children
+	// expire at the same time, keyed by their expiration time.
+	Split() map[uint64]Node
+}
+
+// Ordered is implemented by smart nodes whose values are ordered, like
+// versioned records. MergeLatest keeps the latest of two ordered nodes
+// instead of the one that expires later.
+type Ordered interface {
+	Node
+	// Compare returns a negative number if the node is older than another
+	// one, a positive number if it is newer, and zero if they are equal or
+	// can't be compared (e.g. if they are of different types).
+	Compare(with Node) int
+}
+
+// Resolved is implemented by smart nodes that resolve to a primitive value
+// once assembled, like the time assigned by the server. Replicas store their
+// disassembled form, so primitives are updated with the value they resolve to.
+type Resolved interface {
+	Node
+	// Resolved returns the primitive the node resolves to, or nil if it is not resolved yet.
+	Resolved() Node
+}
+
+// Measured is implemented by smart nodes whose disassembled form includes
+// values measured by the VM storing them, like latencies, which differ between
+// VMs. Snapshots and digests use their unmeasured form, so replicas converge
+// instead of exchanging values that only hold for the VM that measured them.
+type Measured interface {
+	Node
+	// Unmeasured returns the disassembled form of the node without the measured values.
+	Unmeasured() xr.Node
+}
+
 func (ns Nodes) IndexOf(element Node) int {
 	for i, p := range ns {
 		if IsEqual(p, element) {
@@ -35,3 +80,33 @@ func AreSameNodes(x, y Nodes) bool {
 	}
 	return true
 }
+
+// Walk calls f for n and, recursively, for every node in it, including
+// the keys and values of dicts, the elements of lists, the arguments
+// of predicates and the children of collections.
+func Walk(n Node, f func(Node)) {
+	f(n)
+	switch n1 := n.(type) {
+	case *Dict:
+		for _, p := range n1.Pairs {
+			Walk(p.Key, f)
+			Walk(p.Value, f)
+		}
+	case *List:
+		for _, e := range n1.Elements {
+			Walk(e, f)
+		}
+	case *Predicate:
+		for _, e := range n1.Positional {
+			Walk(e, f)
+		}
+		for _, p := range n1.Named {
+			Walk(p.Key, f)
+			Walk(p.Value, f)
+		}
+	case Collection:
+		for _, c := range n1.Children() {
+			Walk(c, f)
+		}
+	}
+}
@@ -37,6 +37,9 @@ func (n *Int) Metadata() meta.MetadataInfo {
 func (n *Int) TypeIsNumber() {}
 
 func (n *Int) UpdateWith(ctx UpdateContext, with Node) error {
+	if r, ok := with.(Resolved); ok {
+		with = r.Resolved()
+	}
 	wn, ok := with.(*Int)
 	if !ok {
 		return fmt.Errorf("cannot update with different primitive type")
@@ -0,0 +1,216 @@
+package ir
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// HookContext is the context the VM passes to the lifecycle hooks of a smart tag.
+type HookContext struct {
+	Ctx  context.Context
+	Host host.Host
+	// Key and Writer identify the dict of the record the node belongs to.
+	Key    string
+	Writer peer.ID
+	// Keys holds the contextual data of the AssemblerContext of the VM.
+	Keys map[string]interface{}
+	// NextSeq advances the sequence of numbers of the key kept by the VM and
+	// returns the next one, or false if the sequence is exhausted. It is nil
+	// if the node is not stored in a key.
+	NextSeq func() (uint64, bool)
+}
+
+// Hook is a lifecycle hook of a smart tag. It is called with a node assembled by
+// the assembler of the smart tag, and it may update the node in place (e.g. with
+// the result of a verification).
+type Hook func(ctx HookContext, n Node)
+
+// PeriodicHook is the periodic hook of a smart tag. It is called with the VM
+// locked, so it must not block: it reads from the node what it needs and returns
+// the check to run, which the VM runs without the lock, and the function that
+// updates the node with the result of the check, which the VM calls with the
+// lock held again. The node may have been updated or removed in between.
+type PeriodicHook func(ctx HookContext, n Node) (check, apply func())
+
+// SmartTag describes a smart tag: the tags it is assembled from, its assembler
+// and the hooks the VM calls during the lifecycle of its nodes. Hooks are optional.
+type SmartTag struct {
+	Tags      []string
+	Assembler Assembler
+
+	// OnAssemble is called for every node of the smart tag in an update,
+	// once assembled and before it is stored.
+	OnAssemble Hook
+	// OnUpdate is called for every node of the smart tag in the dict of a writer
+	// after an update to the dict is stored.
+	OnUpdate Hook
+	// Periodic is called every Period for every node of the smart tag stored.
+	// If Staleness is set, it is only called for the nodes last checked (see
+	// metadata.Checked) more than Staleness ago.
+	Periodic  PeriodicHook
+	Period    time.Duration
+	Staleness time.Duration
+	// OnExpire is called for every node of the smart tag removed by the garbage collection.
+	OnExpire Hook
+}
+
+// TagRegistry maps the tags of smart tags to the assemblers that parse them
+// and to their lifecycle hooks.
+// It is an Assembler that assembles the predicates whose tag is registered.
+//
+// If the assembler of a registered tag fails and the AssemblerContext is strict,
+// the assembly fails instead of falling back to the next assembler in a sequence
+// (usually SyntacticGrammar), so invalid smart tags are rejected instead of
+// being stored as plain predicates.
+type TagRegistry struct {
+	lk   sync.RWMutex
+	tags map[string]*SmartTag
+}
+
+// NewTagRegistry creates an empty TagRegistry.
+func NewTagRegistry() *TagRegistry {
+	return &TagRegistry{tags: make(map[string]*SmartTag)}
+}
+
+// Register registers the assembler of a smart tag without lifecycle hooks for the tags given.
+// It fails if any of the tags is already registered.
+func (r *TagRegistry) Register(asm Assembler, tags ...string) error {
+	return r.RegisterSmartTag(SmartTag{Tags: tags, Assembler: asm})
+}
+
+// RegisterSmartTag registers a smart tag.
+// It fails if any of its tags is already registered.
+func (r *TagRegistry) RegisterSmartTag(st SmartTag) error {
+	if st.Assembler == nil {
+		return fmt.Errorf("smart tag without assembler")
+	}
+	if st.Periodic != nil && st.Period <= 0 {
+		return fmt.Errorf("periodic hook without a positive period")
+	}
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	for _, t := range st.Tags {
+		if _, ok := r.tags[t]; ok {
+			return fmt.Errorf("smart tag %s already registered", t)
+		}
+	}
+	s := &st
+	for _, t := range st.Tags {
+		r.tags[t] = s
+	}
+	return nil
+}
+
+// Lookup returns the assembler registered for a tag.
+func (r *TagRegistry) Lookup(tag string) (Assembler, bool) {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	st, ok := r.tags[tag]
+	if !ok {
+		return nil, false
+	}
+	return st.Assembler, true
+}
+
+// Tags returns the tags registered, sorted.
+func (r *TagRegistry) Tags() []string {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	return r.sortedTags()
+}
+
+// SmartTags returns the smart tags registered. They must not be modified.
+func (r *TagRegistry) SmartTags() []*SmartTag {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	seen := make(map[*SmartTag]bool)
+	out := []*SmartTag{}
+	// Iterate over the sorted tags so the order is deterministic.
+	for _, t := range r.sortedTags() {
+		st := r.tags[t]
+		if !seen[st] {
+			seen[st] = true
+			out = append(out, st)
+		}
+	}
+	return out
+}
+
+func (r *TagRegistry) sortedTags() []string {
+	out := make([]string, 0, len(r.tags))
+	for t := range r.tags {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// SmartTagOf returns the smart tag a semantic node belongs to, looking up the
+// tag of its disassembled form. Syntactic nodes do not belong to any smart tag.
+// The smart tag returned must not be modified.
+func (r *TagRegistry) SmartTagOf(n Node) (*SmartTag, bool) {
+	switch n.(type) {
+	case *Dict, *List, *Predicate, *String, *Int, *Float, *Bool, *Bytes:
+		return nil, false
+	}
+	p, ok := n.Disassemble().(xr.Predicate)
+	if !ok {
+		return nil, false
+	}
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	st, ok := r.tags[p.Tag]
+	return st, ok
+}
+
+// Copy returns a new registry with the smart tags registered in r, so it can be
+// extended without modifying r.
+func (r *TagRegistry) Copy() *TagRegistry {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	out := NewTagRegistry()
+	for t, st := range r.tags {
+		out.tags[t] = st
+	}
+	return out
+}
+
+// Grammar returns an assembler for the smart tags registered that keeps
+// any other input as syntactic nodes.
+func (r *TagRegistry) Grammar() Assembler {
+	return SequenceAssembler{r, SyntacticGrammar}
+}
+
+// AssemblerContext returns an AssemblerContext that assembles the smart tags
+// registered and whose lifecycle hooks are called by the VM.
+func (r *TagRegistry) AssemblerContext() AssemblerContext {
+	return AssemblerContext{Grammar: r.Grammar(), Tags: r}
+}
+
+func (r *TagRegistry) Assemble(ctx AssemblerContext, src xr.Node, metadata ...meta.Metadata) (Node, error) {
+	p, ok := src.(xr.Predicate)
+	if !ok {
+		return nil, fmt.Errorf("smart-tags must be predicates")
+	}
+	asm, ok := r.Lookup(p.Tag)
+	if !ok {
+		return nil, fmt.Errorf("unknown smart tag %s", p.Tag)
+	}
+	out, err := asm.Assemble(ctx, src, metadata...)
+	if err != nil {
+		if ctx.Strict {
+			return nil, &AssemblyError{Reason: fmt.Sprintf("invalid smart tag %s: %s", p.Tag, err), Fatal: true}
+		}
+		return nil, err
+	}
+	return out, nil
+}
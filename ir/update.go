@@ -1,5 +1,11 @@
 package ir
 
+import (
+	"bytes"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+)
+
 type UpdateContext interface{}
 
 type DefaultUpdateContext struct{}
@@ -11,3 +17,81 @@ type DefaultUpdateContext struct{}
 func Update(ctx UpdateContext, old, update Node) error {
 	return old.UpdateWith(ctx, update)
 }
+
+// MergeLatest merges the node in the second argument into the first one,
+// resolving conflicts in favor of the node that expires later. Dicts are
+// merged pair by pair, lists are merged as sets and collections child by
+// child (see Collection). Ordered nodes (see Ordered) keep the latest one.
+// For any other node, or if ordered nodes are equal, the one with the later
+// expiration time is kept, breaking ties with the ordering of their
+// syntactic representation. Merging the same nodes in
+// any order leads to the same result, so it is used to reconcile replicas.
+// Later nodes are merged using UpdateWith, or replace the old node if they are
+// of a different type. It returns the merged node, which may be the first
+// argument updated in place.
+func MergeLatest(ctx UpdateContext, old, with Node) Node {
+	switch o := old.(type) {
+	case *Dict:
+		w, ok := with.(*Dict)
+		if !ok {
+			break
+		}
+		for _, p := range w.Pairs {
+			if i := o.Pairs.IndexOf(p.Key); i < 0 {
+				o.Pairs = append(o.Pairs, p)
+			} else {
+				o.Pairs[i].Value = MergeLatest(ctx, o.Pairs[i].Value, p.Value)
+			}
+		}
+		o.metadataCtx.Update(w.metadataCtx)
+		return o
+	case *List:
+		w, ok := with.(*List)
+		if !ok {
+			break
+		}
+		o.Elements = MergeElements(o.Elements, w.Elements)
+		o.metadataCtx.Update(w.metadataCtx)
+		return o
+	case Collection:
+		// Collections merge their children one by one.
+		if err := o.UpdateWith(ctx, with); err == nil {
+			return o
+		}
+	}
+	if !isLater(with, old) {
+		return old
+	}
+	// Predicates are updated with the arguments of both nodes, and ordered
+	// nodes with the metadata of both, so they are replaced to keep the
+	// result independent of the order.
+	switch old.(type) {
+	case *Predicate, Ordered:
+		return with
+	}
+	if err := old.UpdateWith(ctx, with); err != nil {
+		return with
+	}
+	return old
+}
+
+// isLater returns true if x is newer than y, if they are ordered, or if x
+// expires after y or, if they expire at the same time, x is greater than y
+// in the ordering of their JSON representation.
+func isLater(x, y Node) bool {
+	if o, ok := x.(Ordered); ok {
+		if c := o.Compare(y); c != 0 {
+			return c > 0
+		}
+	}
+	ex, ey := x.Metadata().ExpirationTime, y.Metadata().ExpirationTime
+	if ex != ey {
+		return ex > ey
+	}
+	bx, errx := xr.MarshalJSON(x.Disassemble())
+	by, erry := xr.MarshalJSON(y.Disassemble())
+	if errx != nil || erry != nil {
+		return false
+	}
+	return bytes.Compare(bx, by) > 0
+}
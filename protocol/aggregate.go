@@ -0,0 +1,37 @@
+package protocol
+
+import (
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	"github.com/libp2p/go-smart-record/ir/base"
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// Aggregates evaluates the aggregate smart tags found in the dicts of the
+// writers of a record across all of them (see base.Aggregate). The result of
+// each tag is keyed by the tag in the dict returned, e.g. agg.count(path=".a") => 3.
+// Tags with nothing to aggregate are omitted.
+func Aggregates(rv vm.RecordValue) xr.Dict {
+	dicts := make([]xr.Dict, 0, len(rv))
+	for _, d := range rv {
+		dicts = append(dicts, *d)
+	}
+	out := xr.Dict{Pairs: xr.Pairs{}}
+	for _, d := range dicts {
+		walkPredicates(d, func(p xr.Predicate) {
+			n, err := base.AggregateAssembler{}.Assemble(ir.AssemblerContext{}, p)
+			if err != nil {
+				return
+			}
+			k := n.Disassemble()
+			if out.Get(k) != nil {
+				return
+			}
+			if v, ok := n.(*base.Aggregate).Evaluate(dicts); ok {
+				out.Pairs = append(out.Pairs, xr.Pair{Key: k, Value: v})
+			}
+		})
+	}
+	return out
+}
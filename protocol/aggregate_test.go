@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	p2ptestutil "github.com/libp2p/go-libp2p-netutil"
+	xr "github.com/libp2p/go-routing-language/syntax"
+)
+
+func TestAggregates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupClient(ctx, t)
+	s := setupServer(ctx, t)
+	connect(ctx, t, c.host, s.host)
+
+	count := xr.Predicate{Tag: "agg.count", Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "path"}, Value: xr.String{Value: ".seen"}}}}
+	max := xr.Predicate{Tag: "agg.max", Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "path"}, Value: xr.String{Value: ".seen"}}}}
+	for i := int64(1); i <= 3; i++ {
+		w, _ := p2ptestutil.RandTestBogusIdentity()
+		rec := xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "seen"}, Value: xr.NewInt64(i)}}}
+		if err := s.UpdateLocal("k", w.ID(), rec, 10*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Aggregates are only returned if some writer asks for them.
+	out, aggs, err := c.GetAggregates(ctx, "k", s.host.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aggs.Pairs) != 0 {
+		t.Fatal("aggregates returned without aggregate smart tags", aggs)
+	}
+
+	rec := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "count"}, Value: count},
+		xr.Pair{Key: xr.String{Value: "max"}, Value: max},
+	}}
+	if err := c.Update(ctx, "k", s.host.ID(), rec, ttl); err != nil {
+		t.Fatal(err)
+	}
+	out, aggs, err = c.GetAggregates(ctx, "k", s.host.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := aggs.Get(count); !xr.IsEqual(v, xr.NewInt64(3)) {
+		t.Fatal("wrong count", v)
+	}
+	if v := aggs.Get(max); !xr.IsEqual(v, xr.NewInt64(3)) {
+		t.Fatal("wrong max", v)
+	}
+	// Aggregates are not added to the record.
+	if len(*out) != 4 || (*out)[s.host.ID()] != nil {
+		t.Fatal("aggregates added to the record", *out)
+	}
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-smart-record/ir"
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+)
+
+// assemblyErrorToPB returns the node that failed to assemble in err,
+// so clients can report it to writers. It returns nil if err was not
+// caused by an assembly error.
+func assemblyErrorToPB(err error) *pb.Message_AssemblyError {
+	var ae *ir.AssemblyError
+	if !errors.As(err, &ae) {
+		return nil
+	}
+	out := &pb.Message_AssemblyError{Path: ae.Path, Reason: ae.Reason}
+	for _, r := range ae.Rejections {
+		out.Rejections = append(out.Rejections, &pb.Message_AssemblyError_Rejection{
+			Assembler: r.Assembler,
+			Reason:    r.Reason,
+		})
+	}
+	return out
+}
+
+func assemblyErrorFromPB(m *pb.Message_AssemblyError) *ir.AssemblyError {
+	out := &ir.AssemblyError{Path: m.GetPath(), Reason: m.GetReason()}
+	for _, r := range m.GetRejections() {
+		out.Rejections = append(out.Rejections, ir.Rejection{Assembler: r.GetAssembler(), Reason: r.GetReason()})
+	}
+	return out
+}
+
+// serverError returns the error reported by a server. Assembly errors are
+// returned as an ir.AssemblyError, which can be retrieved with errors.As.
+func serverError(msg string, ae *pb.Message_AssemblyError) error {
+	if ae != nil {
+		return fmt.Errorf("request failed in server: %w", assemblyErrorFromPB(ae))
+	}
+	return fmt.Errorf("request failed in server: %s", msg)
+}
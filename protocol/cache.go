@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// CacheStats reports the usage of the record cache of a client.
+type CacheStats struct {
+	Hits      uint64 // Gets served from the cache.
+	Misses    uint64 // Gets sent to the server.
+	Evictions uint64 // Entries evicted to make room for new ones.
+	StaleHits uint64 // Expired entries served because the server was unreachable.
+}
+
+// cacheKey identifies a record in the cache.
+type cacheKey struct {
+	p peer.ID
+	k string
+}
+
+type cacheEntry struct {
+	key        cacheKey
+	rv         vm.RecordValue
+	expiration time.Time // Time until which the entry is served.
+}
+
+// recordCache is a LRU cache of the records got from servers. Expired
+// entries are kept until they are evicted so they can be served stale.
+type recordCache struct {
+	lk      sync.Mutex
+	size    int
+	maxAge  time.Duration
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+	stats   CacheStats
+	// gen is incremented on every invalidation, so records got
+	// while a key was updated are not cached.
+	gen uint64
+}
+
+func newRecordCache(size int, maxAge time.Duration) *recordCache {
+	return &recordCache{
+		size:    size,
+		maxAge:  maxAge,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns the record of a key cached for a server if it hasn't expired.
+func (c *recordCache) get(p peer.ID, k string) (vm.RecordValue, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	el, ok := c.entries[cacheKey{p, k}]
+	if !ok || time.Now().After(el.Value.(*cacheEntry).expiration) {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+	return copyRecordValue(el.Value.(*cacheEntry).rv), true
+}
+
+// stale returns the record of a key cached for a server even if it expired.
+func (c *recordCache) stale(p peer.ID, k string) (vm.RecordValue, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	el, ok := c.entries[cacheKey{p, k}]
+	if !ok {
+		return nil, false
+	}
+	c.stats.StaleHits++
+	return copyRecordValue(el.Value.(*cacheEntry).rv), true
+}
+
+// generation returns the number of invalidations so far. It is
+// taken before getting a record to add it to the cache.
+func (c *recordCache) generation() uint64 {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.gen
+}
+
+// add caches the record of a key got from a server. The entry expires when
+// the first node in the record expires, or after the max age of the cache.
+// Records without the expiration of some writer are not cached, nor records
+// got before the generation of the cache changed, as they may predate an update.
+func (c *recordCache) add(p peer.ID, k string, rv vm.RecordValue, exps map[peer.ID]writerExpiration, gen uint64) {
+	expiration := time.Now().Add(c.maxAge)
+	for w := range rv {
+		exp, ok := exps[w]
+		if !ok || exp.earliest == 0 {
+			return
+		}
+		if t := time.Unix(int64(exp.earliest), 0); t.Before(expiration) {
+			expiration = t
+		}
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if gen != c.gen {
+		return
+	}
+	key := cacheKey{p, k}
+	entry := &cacheEntry{key: key, rv: copyRecordValue(rv), expiration: expiration}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// invalidate removes the record of a key cached for a server.
+func (c *recordCache) invalidate(p peer.ID, k string) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.gen++
+	if el, ok := c.entries[cacheKey{p, k}]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, cacheKey{p, k})
+	}
+}
+
+func (c *recordCache) Stats() CacheStats {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.stats
+}
+
+// copyRecordValue copies a RecordValue so the cached one can't be modified by callers.
+func copyRecordValue(rv vm.RecordValue) vm.RecordValue {
+	out := make(vm.RecordValue, len(rv))
+	for w, d := range rv {
+		c := d.Copy()
+		out[w] = &c
+	}
+	return out
+}
@@ -0,0 +1,208 @@
+package protocol
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+func TestCacheHits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t)
+	c := setupClient(ctx, t, ClientCache(10))
+	connect(ctx, t, c.host, s.host)
+
+	k := "234"
+	if err := c.Update(ctx, k, s.host.ID(), in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		out, err := c.Get(ctx, k, s.host.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !xr.IsEqual(in1, *(*out)[c.host.ID()]) {
+			t.Fatal("wrong record", out)
+		}
+	}
+	if st := c.CacheStats(); st.Misses != 1 || st.Hits != 2 {
+		t.Fatal("wrong cache stats", st)
+	}
+
+	// Updates invalidate the cached record.
+	if err := c.Update(ctx, k, s.host.ID(), in2, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	out, err := c.Get(ctx, k, s.host.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !xr.IsEqual(in, *(*out)[c.host.ID()]) {
+		t.Fatal("cached record not invalidated", out)
+	}
+	if st := c.CacheStats(); st.Misses != 2 || st.Hits != 2 {
+		t.Fatal("wrong cache stats", st)
+	}
+}
+
+func TestCacheExpiration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t)
+	c := setupClient(ctx, t, ClientCache(10), ClientCacheMaxAge(200*time.Millisecond))
+	connect(ctx, t, c.host, s.host)
+
+	k := "234"
+	if err := c.Update(ctx, k, s.host.ID(), in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, k, s.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err := c.Get(ctx, k, s.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.CacheStats(); st.Misses != 2 || st.Hits != 0 {
+		t.Fatal("record served after max age", st)
+	}
+
+	// Records are not cached beyond the expiration of their nodes.
+	k = "235"
+	if err := c.Update(ctx, k, s.host.ID(), in1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.cache.maxAge = time.Hour
+	if _, err := c.Get(ctx, k, s.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+	if _, ok := c.cache.get(s.host.ID(), k); ok {
+		t.Fatal("record served after its expiration")
+	}
+}
+
+func TestCacheEvictions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t)
+	c := setupClient(ctx, t, ClientCache(1))
+	connect(ctx, t, c.host, s.host)
+
+	for _, k := range []string{"234", "235", "234"} {
+		if err := s.UpdateLocal(k, c.host.ID(), in1, 10*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Get(ctx, k, s.host.ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := c.CacheStats(); st.Misses != 3 || st.Evictions != 2 {
+		t.Fatal("wrong cache stats", st)
+	}
+}
+
+func TestCacheServeStale(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t)
+	c := setupClient(ctx, t, ClientCache(10), ClientCacheMaxAge(time.Millisecond), ClientServeStale())
+	connect(ctx, t, c.host, s.host)
+
+	k := "234"
+	if err := c.Update(ctx, k, s.host.ID(), in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, k, s.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// The expired record is served when the server is unreachable.
+	if err := s.host.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := c.Get(ctx, k, s.host.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !xr.IsEqual(in1, *(*out)[c.host.ID()]) {
+		t.Fatal("wrong stale record", out)
+	}
+	if st := c.CacheStats(); st.StaleHits != 1 {
+		t.Fatal("wrong cache stats", st)
+	}
+	if _, err := c.Get(ctx, "235", s.host.ID()); err == nil {
+		t.Fatal("get of a key not cached should fail")
+	}
+}
+
+func TestCacheServeStaleRejected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t, ServerMaxMessageSize(500))
+	c := setupClient(ctx, t, ClientCache(10), ClientCacheMaxAge(time.Millisecond), ClientServeStale())
+	connect(ctx, t, c.host, s.host)
+
+	k := "234"
+	if err := c.Update(ctx, k, s.host.ID(), in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, k, s.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// The server rejects the request as the record no longer fits in a message.
+	big := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "big"}, Value: xr.String{Value: strings.Repeat("a", 600)}},
+	}}
+	if err := s.UpdateLocal(k, s.host.ID(), big, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, k, s.host.ID()); err == nil {
+		t.Fatal("stale record served when the server rejected the request")
+	}
+	if st := c.CacheStats(); st.StaleHits != 0 {
+		t.Fatal("wrong cache stats", st)
+	}
+}
+
+func TestCacheOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupClient(ctx, t)
+	if c.cache != nil || c.CacheStats() != (CacheStats{}) {
+		t.Fatal("cache should be disabled by default")
+	}
+	if _, err := newSmartRecordClient(ctx, c.host, ClientCache(0)); err == nil {
+		t.Fatal("cache with size 0 should fail")
+	}
+}
+
+func TestCacheSkipsRecordsBeforeInvalidation(t *testing.T) {
+	p, _ := peer.Decode("QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN")
+	c := newRecordCache(10, time.Minute)
+	rv := vm.RecordValue{p: &in1}
+	exps := map[peer.ID]writerExpiration{p: {latest: uint64(time.Now().Unix()) + 10, earliest: uint64(time.Now().Unix()) + 10}}
+
+	// A get racing with an update of the key is not cached.
+	gen := c.generation()
+	c.invalidate(p, "234")
+	c.add(p, "234", rv, exps, gen)
+	if _, ok := c.get(p, "234"); ok {
+		t.Fatal("record got before the update was cached")
+	}
+
+	c.add(p, "234", rv, exps, c.generation())
+	if _, ok := c.get(p, "234"); !ok {
+		t.Fatal("record not cached")
+	}
+}
@@ -2,12 +2,15 @@ package protocol
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"path"
+	"sort"
+	"strings"
 	"time"
 
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
 	xr "github.com/libp2p/go-routing-language/syntax"
@@ -19,10 +22,28 @@ var log = logging.Logger("smart-records")
 
 // SmartRecordClient sends smart-record requesets to other peers.
 type SmartRecordClient interface {
-	Get(ctx context.Context, k string, p peer.ID) (*vm.RecordValue, error)
+	// Get gets the record of a key. See ResolveRefs to resolve its references.
+	Get(ctx context.Context, k string, p peer.ID, opts ...GetOption) (*vm.RecordValue, error)
+	// GetAggregates gets the record of a key like Get, along with the results of
+	// its aggregate smart tags (see Aggregates). It doesn't use the cache.
+	GetAggregates(ctx context.Context, k string, p peer.ID, opts ...GetOption) (*vm.RecordValue, xr.Dict, error)
 	Update(ctx context.Context, k string, p peer.ID, rec xr.Dict, ttl time.Duration) error
-	// NOTE: we won't support queries until we figure out selectors
-	// Query(ctx context.Context, k string, p peer.ID, selector ir.Dict) (*ir.Dict, error)
+	// GetMany gets the records of several keys in a single request. If some keys
+	// fail, the records of the rest are returned along with a BatchError. Keys
+	// without records return an empty record like Get, and keys the server
+	// doesn't return are reported as failed in the BatchError.
+	GetMany(ctx context.Context, ks []string, p peer.ID) (map[string]*vm.RecordValue, error)
+	// UpdateMany updates the records of several keys in a single request. Keys are
+	// updated independently, and the ones that fail are reported in a BatchError.
+	UpdateMany(ctx context.Context, recs map[string]xr.Dict, p peer.ID, ttl time.Duration) error
+	// CacheStats reports the usage of the record cache. It is empty if the cache is disabled.
+	CacheStats() CacheStats
+	// Query gets the record of a key, selecting the parts of it matched by a selector.
+	// Only ranges of sequence numbers of logs (see base.Log) are understood for now:
+	// with the selector {from: INT, to: INT}, logs only include the entries numbered
+	// from..to, both included and optional. Servers that don't understand selectors
+	// return the whole record. Queries are not cached.
+	Query(ctx context.Context, k string, p peer.ID, selector xr.Dict) (*vm.RecordValue, error)
 }
 
 // smartRecordClient is responsible for sending smart-record
@@ -32,8 +53,14 @@ type smartRecordClient struct {
 	host      host.Host
 	self      peer.ID
 	protocols []protocol.ID
+	wire      map[protocol.ID]wireProtocol // Version and codec negotiated with each protocol.
 
 	senderManager *messageSenderImpl
+
+	cache      *recordCache // Cache of records. Nil if disabled.
+	serveStale bool         // Serve expired records from the cache if a get fails.
+
+	metrics *requestMetrics // Nil if metrics are disabled.
 }
 
 // NewSmartRecordClient starts a smartRecordClient instance
@@ -47,8 +74,7 @@ func newSmartRecordClient(ctx context.Context, h host.Host, options ...ClientOpt
 		return nil, err
 	}
 
-	// protocols := []protocol.ID{cfg.protocolPrefix + srid}
-	protocols := protocol.ConvertFromStrings([]string{path.Join(string(cfg.protocolPrefix) + string(srid))})
+	protocols, wire := wireProtocols(cfg.protocolPrefix, cfg.versions, cfg.codecs)
 
 	// Start a smartRecordClient
 	e := &smartRecordClient{
@@ -56,50 +82,257 @@ func newSmartRecordClient(ctx context.Context, h host.Host, options ...ClientOpt
 		host:      h,
 		self:      h.ID(),
 		protocols: protocols,
+		wire:      wire,
 
 		senderManager: &messageSenderImpl{
 			host:      h,
 			strmap:    make(map[peer.ID]*peerMessageSender),
 			protocols: protocols,
+			retry: retryPolicy{
+				retries:    cfg.retries,
+				minBackoff: cfg.minBackoff,
+				maxBackoff: cfg.maxBackoff,
+				classes:    cfg.retryClasses,
+			},
+			readTimeout:    cfg.readTimeout,
+			maxMessageSize: network.MessageSizeMax,
 		},
+		serveStale: cfg.serveStale,
+	}
+	if cfg.cacheSize > 0 {
+		e.cache = newRecordCache(cfg.cacheSize, cfg.cacheMaxAge)
+	}
+	if cfg.registry != nil {
+		m, err := newRequestMetrics(cfg.registry, "client")
+		if err != nil {
+			return nil, err
+		}
+		e.metrics = m
 	}
 
 	return e, nil
 }
 
-func (e *smartRecordClient) Get(ctx context.Context, k string, p peer.ID) (*vm.RecordValue, error) {
+// wireForPeer returns the version and codec negotiated with a peer.
+func (e *smartRecordClient) wireForPeer(ctx context.Context, p peer.ID) (wireProtocol, error) {
+	proto, err := e.senderManager.ProtocolForPeer(ctx, p)
+	if err != nil {
+		return wireProtocol{}, err
+	}
+	w, ok := e.wire[proto]
+	if !ok {
+		return wireProtocol{}, fmt.Errorf("unknown protocol negotiated %s", proto)
+	}
+	return w, nil
+}
+
+// sendRequest sends a request to a peer and waits for the response,
+// returning the error reported by the server if the request failed.
+func (e *smartRecordClient) sendRequest(ctx context.Context, p peer.ID, req *pb.Message) (resp *pb.Message, err error) {
+	start := time.Now()
+	defer func() { e.metrics.observe(req.GetType(), start, err) }()
+	resp, err = e.senderManager.SendRequest(ctx, p, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetError() != "" {
+		return nil, serverError(resp.GetError(), resp.GetAssemblyError())
+	}
+	return resp, nil
+}
+
+// Get gets the record of a key from a server. If the cache is enabled, records
+// are served from the cache until one of their nodes expires.
+func (e *smartRecordClient) Get(ctx context.Context, k string, p peer.ID, opts ...GetOption) (*vm.RecordValue, error) {
+	var cfg getConfig
+	if err := cfg.apply(opts...); err != nil {
+		return nil, err
+	}
+	// Records with resolved references are not cached.
+	cache := e.cache
+	if cfg.refDepth > 0 {
+		cache = nil
+	}
+	var gen uint64
+	if cache != nil {
+		if rv, ok := cache.get(p, k); ok {
+			return &rv, nil
+		}
+		gen = cache.generation()
+	}
+	rv, exps, err := e.getWithExpirations(ctx, k, p, cfg.refDepth)
+	if err != nil {
+		// Serve expired records if the caller opted in and the server
+		// couldn't be reached, but not if it rejected the request.
+		if cache != nil && e.serveStale && transportError(err) {
+			if rv, ok := cache.stale(p, k); ok {
+				log.Debugw("serving stale record", "error", err, "from", p, "key", k)
+				return &rv, nil
+			}
+		}
+		return nil, err
+	}
+	if cache != nil {
+		cache.add(p, k, rv, exps, gen)
+	}
+	return &rv, nil
+}
+
+// transportError returns true if a request failed without getting
+// a response from the server, rather than being rejected by it.
+func transportError(err error) bool {
+	if _, ok := classifyError(err); ok {
+		return true
+	}
+	var se *streamError
+	return errors.As(err, &se)
+}
+
+// CacheStats reports the usage of the record cache.
+func (e *smartRecordClient) CacheStats() CacheStats {
+	if e.cache == nil {
+		return CacheStats{}
+	}
+	return e.cache.Stats()
+}
+
+// invalidate removes a key from the cache once an update of it completes,
+// whether it succeeded or not, as the server may have applied it anyway.
+func (e *smartRecordClient) invalidate(p peer.ID, k string) {
+	if e.cache != nil {
+		e.cache.invalidate(p, k)
+	}
+}
+
+// writerExpiration is the expiration time of the record of a writer
+// reported by the server in GET responses.
+type writerExpiration struct {
+	latest   uint64 // Expiration of the record as a whole.
+	earliest uint64 // Expiration of the first node of the record that expires.
+}
+
+// GetAggregates gets the record of a key from a server along with the results
+// of its aggregate smart tags.
+func (e *smartRecordClient) GetAggregates(ctx context.Context, k string, p peer.ID, opts ...GetOption) (*vm.RecordValue, xr.Dict, error) {
+	var cfg getConfig
+	if err := cfg.apply(opts...); err != nil {
+		return nil, xr.Dict{}, err
+	}
+	resp, w, err := e.get(ctx, k, p, cfg.refDepth)
+	if err != nil {
+		return nil, xr.Dict{}, err
+	}
+	rv, err := vm.UnmarshalRecordValueWith(w.codec, resp.GetValue())
+	if err != nil {
+		return nil, xr.Dict{}, err
+	}
+	aggs := xr.Dict{Pairs: xr.Pairs{}}
+	if len(resp.GetAggregates()) > 0 {
+		n, err := vm.UnmarshalNode(w.codec, resp.GetAggregates())
+		if err != nil {
+			return nil, xr.Dict{}, err
+		}
+		d, ok := n.(xr.Dict)
+		if !ok {
+			return nil, xr.Dict{}, fmt.Errorf("aggregates are not a dict")
+		}
+		aggs = d
+	}
+	return &rv, aggs, nil
+}
+
+// getWithExpirations gets the record of a key along with the expiration
+// time of the record of each writer reported by the server. Servers that
+// don't report expirations return an empty map. References are resolved
+// up to refDepth.
+func (e *smartRecordClient) getWithExpirations(ctx context.Context, k string, p peer.ID, refDepth int) (vm.RecordValue, map[peer.ID]writerExpiration, error) {
+	resp, w, err := e.get(ctx, k, p, refDepth)
+	if err != nil {
+		return nil, nil, err
+	}
+	rv, err := vm.UnmarshalRecordValueWith(w.codec, resp.GetValue())
+	if err != nil {
+		return nil, nil, err
+	}
+	exps := make(map[peer.ID]writerExpiration)
+	for _, en := range resp.GetEntries() {
+		if writer, err := peer.IDFromBytes(en.GetWriter()); err == nil {
+			exps[writer] = writerExpiration{latest: en.GetExpiration(), earliest: en.GetEarliestExpiration()}
+		}
+	}
+
+	return rv, exps, nil
+}
+
+// get sends a GET request for a key, resolving its references up to refDepth,
+// and returns the response along with the wire protocol used to decode it.
+func (e *smartRecordClient) get(ctx context.Context, k string, p peer.ID, refDepth int) (*pb.Message, wireProtocol, error) {
+	w, err := e.wireForPeer(ctx, p)
+	if err != nil {
+		return nil, wireProtocol{}, err
+	}
 	// Send a new request and wait for response
 	req := &pb.Message{
-		Type: pb.Message_GET,
-		Key:  []byte(k),
+		Type:     pb.Message_GET,
+		Key:      []byte(k),
+		RefDepth: uint32(refDepth),
 	}
-	resp, err := e.senderManager.SendRequest(ctx, p, req)
+	resp, err := e.sendRequest(ctx, p, req)
+	if err != nil {
+		return nil, wireProtocol{}, err
+	}
+	return resp, w, nil
+}
+
+func (e *smartRecordClient) Query(ctx context.Context, k string, p peer.ID, selector xr.Dict) (*vm.RecordValue, error) {
+	w, err := e.wireForPeer(ctx, p)
 	if err != nil {
 		return nil, err
 	}
-	//rec, err := ir.Unmarshal(resp.GetValue())
-	rv, err := vm.UnmarshalRecordValue(resp.GetValue())
+	selB, err := vm.MarshalNode(w.codec, selector)
+	if err != nil {
+		return nil, err
+	}
+	// Send a new request and wait for response
+	req := &pb.Message{
+		Type:  pb.Message_QUERY,
+		Key:   []byte(k),
+		Value: selB,
+	}
+	resp, err := e.sendRequest(ctx, p, req)
+	if err != nil {
+		return nil, err
+	}
+	rv, err := vm.UnmarshalRecordValueWith(w.codec, resp.GetValue())
 	if err != nil {
 		return nil, err
 	}
-
 	return &rv, nil
-
 }
 
 func (e *smartRecordClient) Update(ctx context.Context, k string, p peer.ID, rec xr.Dict, ttl time.Duration) error {
+	defer e.invalidate(p, k)
+	w, err := e.wireForPeer(ctx, p)
+	if err != nil {
+		return err
+	}
 	// Send a new request and wait for response
-	recB, err := xr.MarshalJSON(rec)
+	recB, err := vm.MarshalNode(w.codec, rec)
+	if err != nil {
+		return err
+	}
+	sig, err := e.sign(w, k, rec, uint64(ttl.Seconds()))
 	if err != nil {
 		return err
 	}
 	req := &pb.Message{
-		Type:  pb.Message_UPDATE,
-		Key:   []byte(k),
-		Value: recB,
-		TTL:   uint64(ttl.Seconds()),
+		Type:      pb.Message_UPDATE,
+		Key:       []byte(k),
+		Value:     recB,
+		TTL:       uint64(ttl.Seconds()),
+		Signature: sig,
 	}
-	resp, err := e.senderManager.SendRequest(ctx, p, req)
+	resp, err := e.sendRequest(ctx, p, req)
 	if err != nil {
 		return err
 	}
@@ -113,10 +346,161 @@ func (e *smartRecordClient) Update(ctx context.Context, k string, p peer.ID, rec
 	return nil
 }
 
-/*
-func (e *smartRecordManager) Query(ctx context.Context, k string, p peer.ID, selector ir.Dict) (*ir.Dict, error) {
-	// NOTE: For now Query and Get are the same because we don't
-	// understand selectors (yet)
-	return e.Get(ctx, k, p)
+// BatchError reports the error of each key that failed in a batch request.
+type BatchError map[string]error
+
+func (e BatchError) Error() string {
+	ks := make([]string, 0, len(e))
+	for k := range e {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	errs := make([]string, len(ks))
+	for i, k := range ks {
+		errs[i] = fmt.Sprintf("%s: %s", k, e[k])
+	}
+	return fmt.Sprintf("batch request failed for %d keys: %s", len(e), strings.Join(errs, "; "))
+}
+
+func (e *smartRecordClient) GetMany(ctx context.Context, ks []string, p peer.ID) (map[string]*vm.RecordValue, error) {
+	w, err := e.wireForPeer(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fallback to one request per key if the peer doesn't support batches.
+	if !w.supports(pb.Message_BATCH_GET) {
+		out := make(map[string]*vm.RecordValue, len(ks))
+		batchErr := BatchError{}
+		for _, k := range ks {
+			rv, err := e.Get(ctx, k, p)
+			if err != nil {
+				batchErr[k] = err
+				continue
+			}
+			out[k] = rv
+		}
+		return out, batchErr.orNil()
+	}
+
+	req := &pb.Message{
+		Type:    pb.Message_BATCH_GET,
+		Entries: make([]*pb.Message_Entry, len(ks)),
+	}
+	for i, k := range ks {
+		req.Entries[i] = &pb.Message_Entry{Key: []byte(k)}
+	}
+	resp, err := e.sendRequest(ctx, p, req)
+	if err != nil {
+		return nil, err
+	}
+	return batchGetResults(w.codec, ks, resp.GetEntries())
+}
+
+// batchGetResults returns the records in the entries of a BATCH_GET response.
+// Keys requested without an entry in the response are reported as failed.
+func batchGetResults(c vm.Codec, ks []string, entries []*pb.Message_Entry) (map[string]*vm.RecordValue, error) {
+	out := make(map[string]*vm.RecordValue, len(ks))
+	batchErr := BatchError{}
+	for _, en := range entries {
+		k := string(en.GetKey())
+		if en.GetError() != "" {
+			batchErr[k] = fmt.Errorf("request failed in server: %s", en.GetError())
+			continue
+		}
+		rv, err := vm.UnmarshalRecordValueWith(c, en.GetValue())
+		if err != nil {
+			batchErr[k] = err
+			continue
+		}
+		out[k] = &rv
+	}
+	for _, k := range ks {
+		if _, ok := out[k]; !ok && batchErr[k] == nil {
+			batchErr[k] = errors.New("no record returned by the server")
+		}
+	}
+	return out, batchErr.orNil()
+}
+
+func (e *smartRecordClient) UpdateMany(ctx context.Context, recs map[string]xr.Dict, p peer.ID, ttl time.Duration) error {
+	defer func() {
+		for k := range recs {
+			e.invalidate(p, k)
+		}
+	}()
+	w, err := e.wireForPeer(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	batchErr := BatchError{}
+	// Fallback to one request per key if the peer doesn't support batches.
+	if !w.supports(pb.Message_BATCH_UPDATE) {
+		for k, rec := range recs {
+			if err := e.Update(ctx, k, p, rec, ttl); err != nil {
+				batchErr[k] = err
+			}
+		}
+		return batchErr.orNil()
+	}
+
+	req := &pb.Message{
+		Type:    pb.Message_BATCH_UPDATE,
+		Entries: make([]*pb.Message_Entry, 0, len(recs)),
+	}
+	for k, rec := range recs {
+		recB, err := vm.MarshalNode(w.codec, rec)
+		if err != nil {
+			batchErr[k] = err
+			continue
+		}
+		sig, err := e.sign(w, k, rec, uint64(ttl.Seconds()))
+		if err != nil {
+			batchErr[k] = err
+			continue
+		}
+		req.Entries = append(req.Entries, &pb.Message_Entry{
+			Key:       []byte(k),
+			Value:     recB,
+			TTL:       uint64(ttl.Seconds()),
+			Signature: sig,
+		})
+	}
+	if len(req.Entries) == 0 {
+		return batchErr.orNil()
+	}
+	resp, err := e.sendRequest(ctx, p, req)
+	if err != nil {
+		return err
+	}
+	for _, en := range resp.GetEntries() {
+		if en.GetError() != "" {
+			batchErr[string(en.GetKey())] = serverError(en.GetError(), en.GetAssemblyError())
+		}
+	}
+	return batchErr.orNil()
+}
+
+// sign signs an update if the version negotiated supports signatures and
+// the private key of the client is available. Otherwise, it returns nil and
+// the update is sent unsigned.
+func (e *smartRecordClient) sign(w wireProtocol, k string, rec xr.Dict, ttl uint64) ([]byte, error) {
+	if !w.spec().signatures {
+		return nil, nil
+	}
+	sk := e.host.Peerstore().PrivKey(e.self)
+	if sk == nil {
+		return nil, nil
+	}
+	return signUpdate(sk, k, rec, ttl)
+}
+
+// orNil returns nil if no key failed, so an empty BatchError
+// is not returned as a non-nil error.
+func (e BatchError) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
 }
-*/
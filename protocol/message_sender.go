@@ -18,16 +18,51 @@ import (
 // ErrReadTimeout is an error that occurs when no message is read within the timeout period.
 var ErrReadTimeout = fmt.Errorf("timed out reading response")
 
+// streamError is an error opening a stream with a peer.
+type streamError struct {
+	err error
+}
+
+func (e *streamError) Error() string { return e.err.Error() }
+
+func (e *streamError) Unwrap() error { return e.err }
+
 // messageSenderImpl is responsible for sending requests and messages to peers
 type messageSenderImpl struct {
 	host      host.Host // the network services we need
 	smlk      sync.Mutex
 	strmap    map[peer.ID]*peerMessageSender
 	protocols []protocol.ID
+	retry     retryPolicy
+
+	readTimeout    time.Duration // Timeout to read responses if the request has no deadline.
+	maxMessageSize int           // Max size of the responses read.
 }
 
-// SendRequest sends out a request
+// SendRequest sends out a request, retrying it according to the retry policy
+// of the sender if it fails with a retryable error.
 func (m *messageSenderImpl) SendRequest(ctx context.Context, p peer.ID, pmes *pb.Message) (*pb.Message, error) {
+	for attempt := 0; ; attempt++ {
+		rpmes, err := m.sendRequest(ctx, p, pmes)
+		if err == nil {
+			return rpmes, nil
+		}
+		if attempt >= m.retry.retries || !m.retry.retryable(pmes.GetType(), err) {
+			return nil, err
+		}
+		d := m.retry.backoff(attempt)
+		log.Debugw("retrying request", "error", err, "to", p, "attempt", attempt+1, "backoff", d)
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		}
+	}
+}
+
+func (m *messageSenderImpl) sendRequest(ctx context.Context, p peer.ID, pmes *pb.Message) (*pb.Message, error) {
 	ms, err := m.messageSenderForPeer(ctx, p)
 	if err != nil {
 		log.Debugw("request failed to open message sender", "error", err, "to", p)
@@ -58,6 +93,17 @@ func (m *messageSenderImpl) SendMessage(ctx context.Context, p peer.ID, pmes *pb
 	return nil
 }
 
+// ProtocolForPeer returns the protocol negotiated in the stream with a peer,
+// opening a new stream if needed.
+func (m *messageSenderImpl) ProtocolForPeer(ctx context.Context, p peer.ID) (protocol.ID, error) {
+	ms, err := m.messageSenderForPeer(ctx, p)
+	if err != nil {
+		log.Debugw("failed to open message sender", "error", err, "to", p)
+		return "", err
+	}
+	return ms.protocol(ctx)
+}
+
 func (m *messageSenderImpl) messageSenderForPeer(ctx context.Context, p peer.ID) (*peerMessageSender, error) {
 	m.smlk.Lock()
 	ms, ok := m.strmap[p]
@@ -138,15 +184,28 @@ func (ms *peerMessageSender) prep(ctx context.Context) error {
 	// backwards compatibility reasons).
 	nstr, err := ms.m.host.NewStream(ctx, ms.p, ms.m.protocols...)
 	if err != nil {
-		return err
+		return &streamError{err}
 	}
 
-	ms.r = msgio.NewVarintReaderSize(nstr, network.MessageSizeMax)
+	ms.r = msgio.NewVarintReaderSize(nstr, ms.m.maxMessageSize)
 	ms.s = nstr
 
 	return nil
 }
 
+// protocol returns the protocol of the stream with the peer.
+func (ms *peerMessageSender) protocol(ctx context.Context) (protocol.ID, error) {
+	if err := ms.lk.Lock(ctx); err != nil {
+		return "", err
+	}
+	defer ms.lk.Unlock()
+
+	if err := ms.prep(ctx); err != nil {
+		return "", err
+	}
+	return ms.s.Protocol(), nil
+}
+
 func (ms *peerMessageSender) sendMessage(ctx context.Context, pmes *pb.Message) error {
 	if err := ms.lk.Lock(ctx); err != nil {
 		return err
@@ -210,15 +269,20 @@ func (ms *peerMessageSender) ctxReadMsg(ctx context.Context, mes *pb.Message) er
 		errc <- mes.Unmarshal(bytes)
 	}(ms.r)
 
-	t := time.NewTimer(readMessageTimeout)
-	defer t.Stop()
+	// The deadline of the request overrides the read timeout.
+	var timeout <-chan time.Time
+	if _, ok := ctx.Deadline(); !ok {
+		t := time.NewTimer(ms.m.readTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
 
 	select {
 	case err := <-errc:
 		return err
 	case <-ctx.Done():
 		return ctx.Err()
-	case <-t.C:
+	case <-timeout:
 		return ErrReadTimeout
 	}
 }
@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+)
+
+// metricsNamespace is the namespace of every smart record metric.
+const metricsNamespace = "smart_record"
+
+// Status of the requests reported in metrics.
+const (
+	statusOK          = "ok"
+	statusError       = "error"
+	statusUnsupported = "unsupported"
+)
+
+// requestMetrics are the metrics of the requests sent by a client or handled
+// by a server. A nil requestMetrics records nothing.
+type requestMetrics struct {
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+
+	reg prometheus.Registerer
+}
+
+// newRequestMetrics registers the request metrics of a subsystem (client or server).
+func newRequestMetrics(reg prometheus.Registerer, subsystem string) (*requestMetrics, error) {
+	m := &requestMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: subsystem, Name: "requests_total",
+			Help: "Number of requests by message type and status.",
+		}, []string{"type", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace, Subsystem: subsystem, Name: "request_duration_seconds",
+			Help:    "Latency of requests by message type.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"type"}),
+		reg: reg,
+	}
+	var registered []prometheus.Collector
+	for _, c := range []prometheus.Collector{m.requests, m.latency} {
+		if err := reg.Register(c); err != nil {
+			// Don't leave the metrics registered so far behind.
+			for _, r := range registered {
+				reg.Unregister(r)
+			}
+			return nil, err
+		}
+		registered = append(registered, c)
+	}
+	return m, nil
+}
+
+// unregister removes the metrics from the registry they were registered in.
+func (m *requestMetrics) unregister() {
+	if m == nil {
+		return
+	}
+	m.reg.Unregister(m.requests)
+	m.reg.Unregister(m.latency)
+}
+
+// observe records a request of type t started at start that finished with err.
+func (m *requestMetrics) observe(t pb.Message_MessageType, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	status := statusOK
+	if err != nil {
+		status = statusError
+	}
+	m.requests.WithLabelValues(t.String(), status).Inc()
+	m.latency.WithLabelValues(t.String()).Observe(time.Since(start).Seconds())
+}
+
+// observeUnsupported records a request of a type not supported in the version negotiated.
+func (m *requestMetrics) observeUnsupported(t pb.Message_MessageType) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(t.String(), statusUnsupported).Inc()
+}
@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sreg, creg := prometheus.NewRegistry(), prometheus.NewRegistry()
+	s := setupServer(ctx, t, ServerMetrics(sreg))
+	c := setupClient(ctx, t, ClientMetrics(creg))
+	connect(ctx, t, c.host, s.host)
+
+	k := "234"
+	if err := c.Update(ctx, k, s.host.ID(), in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(ctx, k, s.host.ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, m := range []*requestMetrics{s.metrics, c.metrics} {
+		if got := testutil.ToFloat64(m.requests.WithLabelValues("UPDATE", statusOK)); got != 1 {
+			t.Fatal("wrong number of updates", got)
+		}
+		if got := testutil.ToFloat64(m.requests.WithLabelValues("GET", statusOK)); got != 2 {
+			t.Fatal("wrong number of gets", got)
+		}
+		if got := testutil.CollectAndCount(m.latency); got != 2 {
+			t.Fatal("latency not reported for every message type", got)
+		}
+	}
+
+	// The VM of the server registers its metrics in the same registry.
+	mfs, err := sreg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, mf := range mfs {
+		if mf.GetName() == "smart_record_vm_keys" {
+			found = mf.GetMetric()[0].GetGauge().GetValue() == 1
+		}
+	}
+	if !found {
+		t.Fatal("vm metrics not registered in the server registry")
+	}
+}
+
+func TestMetricsUnregisteredOnFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mn.Hosts()[0]
+
+	// Collectors registered by someone else with the same metrics.
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Subsystem: "server", Name: "request_duration_seconds",
+		Help:    "Latency of requests by message type.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"type"})
+	assemblyFailures := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "vm", Name: "assembly_failures_total",
+		Help: "Number of updates that failed to assemble.",
+	})
+	for _, tc := range []struct {
+		name     string
+		conflict prometheus.Collector
+		opts     []ServerOption
+	}{
+		{name: "request metrics", conflict: latency},
+		{name: "vm", conflict: assemblyFailures},
+		{name: "sync not supported", opts: []ServerOption{ServerVersions(Version030), SyncPeriod(time.Second)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			if tc.conflict != nil {
+				if err := reg.Register(tc.conflict); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opts := append([]ServerOption{ServerProtocolPrefix(prefix), ServerMetrics(reg)}, tc.opts...)
+			if _, err := newSmartRecordServer(ctx, h, opts...); err == nil {
+				t.Fatal("server should fail to start")
+			}
+			if tc.conflict != nil {
+				reg.Unregister(tc.conflict)
+			}
+			// Nothing registered by the failed server is left behind.
+			if _, err := newSmartRecordServer(ctx, h, ServerProtocolPrefix(prefix), ServerMetrics(reg)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
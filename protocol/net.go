@@ -10,13 +10,14 @@ import (
 	"github.com/libp2p/go-msgio"
 	"github.com/libp2p/go-msgio/protoio"
 	pb "github.com/libp2p/go-smart-record/protocol/pb"
+	"github.com/libp2p/go-smart-record/trace"
 )
 
-// Idle time before the stream is closed
-var streamIdleTimeout = 1 * time.Minute
+// Default idle time before the stream is closed
+const streamIdleTimeout = 1 * time.Minute
 
-// Timeout to wait for a response after a request is sent
-var readMessageTimeout = 10 * time.Second
+// Default timeout to wait for a response after a request is sent
+const readMessageTimeout = 10 * time.Second
 
 // The Protobuf writer performs multiple small writes when writing a message.
 // We need to buffer those writes, to make sure that we're not sending a new
@@ -62,11 +63,18 @@ func (e *smartRecordServer) handleNewStream(s network.Stream) {
 // Returns true on orderly completion of writes (so we can Close the stream conveniently).
 func (e *smartRecordServer) handleNewMessages(s network.Stream) bool {
 	ctx := e.ctx
-	r := msgio.NewVarintReaderSize(s, network.MessageSizeMax)
+	if e.tracer != nil {
+		ctx = trace.WithTracer(ctx, e.tracer)
+	}
+	r := msgio.NewVarintReaderSize(s, e.maxMessageSize)
 
 	mPeer := s.Conn().RemotePeer()
+	w, ok := e.wire[s.Protocol()]
+	if !ok {
+		return false
+	}
 
-	timer := time.AfterFunc(streamIdleTimeout, func() { _ = s.Reset() })
+	timer := time.AfterFunc(e.streamIdleTimeout, func() { _ = s.Reset() })
 	defer timer.Stop()
 
 	for {
@@ -82,16 +90,29 @@ func (e *smartRecordServer) handleNewMessages(s network.Stream) bool {
 			return false
 		}
 
-		timer.Reset(streamIdleTimeout)
+		timer.Reset(e.streamIdleTimeout)
 
-		handler := e.handlerForMsgType(req.GetType())
+		handler := e.handlerForMsgType(w, req.GetType())
 		if handler == nil {
+			e.metrics.observeUnsupported(req.GetType())
 			return false
 		}
 
-		resp, err := handler(ctx, mPeer, &req)
+		start := time.Now()
+		resp, err := handler(ctx, mPeer, w.codec, &req)
+		e.metrics.observe(req.GetType(), start, err)
 		if err != nil {
-			return false
+			// Versions without error responses reset the stream
+			// to notify the client that the request failed.
+			if !w.spec().errorResponses {
+				return false
+			}
+			resp = &pb.Message{
+				Type:          req.GetType(),
+				Key:           req.GetKey(),
+				Error:         err.Error(),
+				AssemblyError: assemblyErrorToPB(err),
+			}
 		}
 
 		if resp == nil {
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+func TestClientReadTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t)
+	// The server never responds.
+	s.setProtocolHandler(func(st network.Stream) {})
+	c := setupClient(ctx, t, ClientReadTimeout(100*time.Millisecond))
+	connect(ctx, t, c.host, s.host)
+
+	start := time.Now()
+	if _, err := c.Get(ctx, "234", s.host.ID()); !errors.Is(err, ErrReadTimeout) {
+		t.Fatal("expected read timeout", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatal("read timeout not applied", d)
+	}
+
+	// The deadline of the request overrides the read timeout.
+	dctx, dcancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer dcancel()
+	start = time.Now()
+	if _, err := c.Get(dctx, "234", s.host.ID()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected deadline exceeded", err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatal("request deadline didn't override the read timeout", d)
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t, StreamIdleTimeout(100*time.Millisecond))
+	c := setupClient(ctx, t)
+	connect(ctx, t, c.host, s.host)
+
+	st, err := c.host.NewStream(ctx, s.host.ID(), s.protocols...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := st.Read(make([]byte, 1))
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("idle stream should be reset")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle stream not reset")
+	}
+}
+
+func TestServerMaxMessageSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t, ServerMaxMessageSize(64))
+	c := setupClient(ctx, t)
+	connect(ctx, t, c.host, s.host)
+
+	k := "234"
+	if err := c.Update(ctx, k, s.host.ID(), in, 10*time.Second); err == nil {
+		t.Fatal("update larger than the max message size should fail")
+	}
+	if out := s.GetLocal(k); len(out) != 0 {
+		t.Fatal("update larger than the max message size was applied", out)
+	}
+	// Smaller requests are still served.
+	if _, err := c.Get(ctx, k, s.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSmartRecordServer(ctx, s.host, ServerMaxMessageSize(0)); err == nil {
+		t.Fatal("max message size 0 should fail")
+	}
+}
@@ -4,24 +4,67 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/libp2p/go-smart-record/ir"
 	"github.com/libp2p/go-smart-record/ir/base"
+	"github.com/libp2p/go-smart-record/trace"
+	"github.com/libp2p/go-smart-record/vm"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Protocol ID
 const (
-	srid          protocol.ID = "/smart-record/0.0.1"
+	srid          protocol.ID = "/smart-record"
 	DefaultPrefix protocol.ID = "/ipfs"
+
+	// replicationPeriod determines how often servers reconcile their state with replicas.
+	replicationPeriod = 60 * time.Second
+	// cacheMaxAge is the default time records are cached in clients at most.
+	cacheMaxAge = 60 * time.Second
+	// minBackoff and maxBackoff bound the time clients wait before retrying a request.
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+	// dialWorkers, dialQueue, dialTimeout and dialCacheWindow configure the dial checks of dialable smart tags.
+	dialWorkers     = 16
+	dialQueue       = 1024
+	dialTimeout     = 5 * time.Second
+	dialCacheWindow = 30 * time.Second
+	// maxRefDepth is the default depth up to which servers resolve references,
+	// so they don't resolve them unless configured to (see ServerMaxRefDepth).
+	maxRefDepth = 0
 )
 
+// defaultCodecs are the codecs supported by default, in order of preference.
+var defaultCodecs = []vm.Codec{vm.CodecProtobuf, vm.CodecCBOR, vm.CodecJSON}
+
 // Options is a structure containing all the options that can be used when constructing the smart records env
 type serverConfig struct {
 	//datastore          ds.Batching
 	updateContext  ir.UpdateContext
 	assembler      ir.AssemblerContext
+	strictAssembly bool
 	gcPeriod       time.Duration
+	vmOptions      []vm.VMOption
+	dialWorkers    int
+	dialQueue      int
+	dialTimeout    time.Duration
+	dialWindow     time.Duration
 	protocolPrefix protocol.ID
+	codecs         []vm.Codec
+	versions       []Version
+
+	streamIdleTimeout time.Duration
+	readTimeout       time.Duration
+	maxMessageSize    int
+	maxRefDepth       int
+	registry          prometheus.Registerer
+	tracer            trace.Tracer
+
+	replicas          []peer.ID
+	replicationPeriod time.Duration
+	syncPeriod        time.Duration
 }
 
 // Option type for smart records
@@ -31,8 +74,19 @@ type ServerOption func(*serverConfig) error
 // prepended to any options you pass to the constructor.
 var serverDefaults = func(o *serverConfig) error {
 	o.updateContext = ir.DefaultUpdateContext{}
-	o.assembler = ir.AssemblerContext{Grammar: base.BaseGrammar}
+	o.assembler = base.BaseTags.AssemblerContext()
 	o.protocolPrefix = DefaultPrefix
+	o.codecs = defaultCodecs
+	o.versions = supportedVersions
+	o.replicationPeriod = replicationPeriod
+	o.dialWorkers = dialWorkers
+	o.dialQueue = dialQueue
+	o.dialTimeout = dialTimeout
+	o.dialWindow = dialCacheWindow
+	o.streamIdleTimeout = streamIdleTimeout
+	o.readTimeout = readMessageTimeout
+	o.maxMessageSize = network.MessageSizeMax
+	o.maxRefDepth = maxRefDepth
 
 	return nil
 }
@@ -63,7 +117,55 @@ func ServerProtocolPrefix(p protocol.ID) ServerOption {
 	}
 }
 
-// Assembler  configures the assembler to use in the smart record VM
+// ServerCodecs configures the codecs the server accepts to serialize records.
+func ServerCodecs(codecs ...vm.Codec) ServerOption {
+	return func(c *serverConfig) error {
+		if len(codecs) == 0 {
+			return fmt.Errorf("at least one codec must be supported")
+		}
+		c.codecs = codecs
+		return nil
+	}
+}
+
+// ClientCodecs configures the codecs the client supports to serialize records,
+// in order of preference. The first codec also supported by the server is used.
+func ClientCodecs(codecs ...vm.Codec) ClientOption {
+	return func(c *clientConfig) error {
+		if len(codecs) == 0 {
+			return fmt.Errorf("at least one codec must be supported")
+		}
+		c.codecs = codecs
+		return nil
+	}
+}
+
+// ServerVersions configures the versions of the protocol served.
+func ServerVersions(versions ...Version) ServerOption {
+	return func(c *serverConfig) error {
+		if err := checkVersions(versions); err != nil {
+			return err
+		}
+		c.versions = versions
+		return nil
+	}
+}
+
+// ClientVersions configures the versions of the protocol the client speaks.
+// The newest version also supported by the server is used.
+func ClientVersions(versions ...Version) ClientOption {
+	return func(c *clientConfig) error {
+		if err := checkVersions(versions); err != nil {
+			return err
+		}
+		c.versions = versions
+		return nil
+	}
+}
+
+// Assembler  configures the assembler to use in the smart record VM.
+// To support custom smart tags, register them in a copy of base.BaseTags and
+// use its AssemblerContext, so the VM also calls their lifecycle hooks.
 func Assembler(asm ir.AssemblerContext) ServerOption {
 	return func(c *serverConfig) error {
 		c.assembler = asm
@@ -71,6 +173,16 @@ func Assembler(asm ir.AssemblerContext) ServerOption {
 	}
 }
 
+// StrictAssembly rejects updates with invalid smart tags, i.e. predicates
+// whose tag is registered as a smart tag but fail to assemble as one (see
+// ir.TagRegistry). By default, they are stored as plain predicates.
+func StrictAssembly() ServerOption {
+	return func(c *serverConfig) error {
+		c.strictAssembly = true
+		return nil
+	}
+}
+
 // UpdateContext configures the context to use for updates in the smart record VM
 func UpdateContext(uc ir.UpdateContext) ServerOption {
 	return func(c *serverConfig) error {
@@ -87,9 +199,166 @@ func VMGcPeriod(gcP time.Duration) ServerOption {
 	}
 }
 
+// DialChecks configures the dial checks of dialable smart tags. Dials run in the
+// background, at most workers at the same time, and the tag is pending until
+// they end. At most queue checks wait for a worker; while the queue is full, tags
+// are left unverified until they are verified again periodically. Checks to the
+// same peer are deduplicated and cached for window.
+func DialChecks(workers, queue int, timeout, window time.Duration) ServerOption {
+	return func(c *serverConfig) error {
+		if workers <= 0 {
+			return fmt.Errorf("dial workers must be positive")
+		}
+		if queue < 0 {
+			return fmt.Errorf("dial queue must not be negative")
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("dial timeout must be positive")
+		}
+		if window < 0 {
+			return fmt.Errorf("dial cache window must not be negative")
+		}
+		c.dialWorkers, c.dialQueue, c.dialTimeout, c.dialWindow = workers, queue, timeout, window
+		return nil
+	}
+}
+
+// VMHookPeriod configures the period of the periodic hook of the smart tag registered
+// for a tag in the server VM, e.g. the re-verification of Reachable smart tags.
+func VMHookPeriod(tag string, p time.Duration) ServerOption {
+	return func(c *serverConfig) error {
+		c.vmOptions = append(c.vmOptions, vm.HookPeriod(tag, p))
+		return nil
+	}
+}
+
+// VMHookStaleness configures the staleness bound of the nodes of the smart tag
+// registered for a tag in the server VM. Its periodic hook is only called for
+// nodes last checked more than the bound ago.
+func VMHookStaleness(tag string, s time.Duration) ServerOption {
+	return func(c *serverConfig) error {
+		c.vmOptions = append(c.vmOptions, vm.HookStaleness(tag, s))
+		return nil
+	}
+}
+
+// StreamIdleTimeout configures the time the server waits for a new request
+// in a stream before resetting it.
+func StreamIdleTimeout(d time.Duration) ServerOption {
+	return func(c *serverConfig) error {
+		if d <= 0 {
+			return fmt.Errorf("stream idle timeout must be positive")
+		}
+		c.streamIdleTimeout = d
+		return nil
+	}
+}
+
+// ServerReadTimeout configures the time the server waits for the response
+// of the requests it sends to other servers, like replicas.
+func ServerReadTimeout(d time.Duration) ServerOption {
+	return func(c *serverConfig) error {
+		if d <= 0 {
+			return fmt.Errorf("read timeout must be positive")
+		}
+		c.readTimeout = d
+		return nil
+	}
+}
+
+// ServerMaxMessageSize configures the max size of the messages read by the server.
+// Streams sending larger messages are reset.
+func ServerMaxMessageSize(n int) ServerOption {
+	return func(c *serverConfig) error {
+		if n <= 0 {
+			return fmt.Errorf("max message size must be positive")
+		}
+		c.maxMessageSize = n
+		return nil
+	}
+}
+
+// ServerMaxRefDepth configures the max depth up to which the server resolves
+// the references to other records requested in gets (see ResolveRefs).
+// Zero, the default, disables the resolution of references.
+func ServerMaxRefDepth(depth int) ServerOption {
+	return func(c *serverConfig) error {
+		if depth < 0 {
+			return fmt.Errorf("max reference depth can't be negative")
+		}
+		c.maxRefDepth = depth
+		return nil
+	}
+}
+
+// ServerMetrics registers the metrics of the server and its VM in a registry.
+// Metrics are disabled by default. Each server needs its own registry, or one
+// wrapped with distinct labels (see prometheus.WrapRegistererWith).
+func ServerMetrics(reg prometheus.Registerer) ServerOption {
+	return func(c *serverConfig) error {
+		c.registry = reg
+		return nil
+	}
+}
+
+// ServerTracer traces the stages of the requests handled by the server with t.
+// Tracing is disabled by default.
+func ServerTracer(t trace.Tracer) ServerOption {
+	return func(c *serverConfig) error {
+		c.tracer = t
+		return nil
+	}
+}
+
+// Replicas configures the peers the server replicates its records with. Accepted
+// updates are forwarded to them, and the server only accepts forwarded updates from
+// them. Replicas should be configured symmetrically in every server of the set.
+func Replicas(ps ...peer.ID) ServerOption {
+	return func(c *serverConfig) error {
+		c.replicas = ps
+		return nil
+	}
+}
+
+// ReplicationPeriod configures how often the server sends its full state to replicas
+// so they converge even if they missed some forwarded update.
+func ReplicationPeriod(p time.Duration) ServerOption {
+	return func(c *serverConfig) error {
+		if p <= 0 {
+			return fmt.Errorf("replication period must be positive")
+		}
+		c.replicationPeriod = p
+		return nil
+	}
+}
+
+// SyncPeriod enables syncing periodically with the replicas of the server (see
+// SmartRecordServer.SyncWith). Periodic sync is disabled by default.
+func SyncPeriod(p time.Duration) ServerOption {
+	return func(c *serverConfig) error {
+		if p <= 0 {
+			return fmt.Errorf("sync period must be positive")
+		}
+		c.syncPeriod = p
+		return nil
+	}
+}
+
 // Options is a structure containing all the options that can be used when constructing the smart records env
 type clientConfig struct {
 	protocolPrefix protocol.ID
+	codecs         []vm.Codec
+	versions       []Version
+	cacheSize      int
+	cacheMaxAge    time.Duration
+	serveStale     bool
+	readTimeout    time.Duration
+	registry       prometheus.Registerer
+
+	retries      int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	retryClasses map[RetryClass]bool
 }
 
 // Option type for smart records
@@ -107,5 +376,261 @@ func (c *clientConfig) apply(opts ...ClientOption) error {
 
 var clientDefaults = func(o *clientConfig) error {
 	o.protocolPrefix = DefaultPrefix
+	o.codecs = defaultCodecs
+	o.versions = supportedVersions
+	o.cacheMaxAge = cacheMaxAge
+	o.readTimeout = readMessageTimeout
+	o.minBackoff = minBackoff
+	o.maxBackoff = maxBackoff
+	o.retryClasses = map[RetryClass]bool{RetryStreamReset: true, RetryReadTimeout: true}
+	return nil
+}
+
+// getConfig holds the options of a single get.
+type getConfig struct {
+	refDepth int
+}
+
+// GetOption configures a single get of a record.
+type GetOption func(*getConfig) error
+
+// apply applies the given options to this Option
+func (c *getConfig) apply(opts ...GetOption) error {
+	for i, opt := range opts {
+		if err := opt(c); err != nil {
+			return fmt.Errorf("get option %d failed: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// ResolveRefs asks the server to resolve the references to other records
+// up to a depth, inlining the dicts referenced (see base.Ref). Servers limit
+// the depth (see ServerMaxRefDepth), and the ones that don't resolve references
+// return them unresolved. Records with resolved references are not cached.
+func ResolveRefs(depth int) GetOption {
+	return func(c *getConfig) error {
+		if depth < 0 {
+			return fmt.Errorf("reference depth can't be negative")
+		}
+		c.refDepth = depth
+		return nil
+	}
+}
+
+// ClientRetries configures the number of times a failed request is retried.
+// Requests are not retried by default. Updates are not retried if they may
+// have reached the server (see RetryClass).
+func ClientRetries(n int) ClientOption {
+	return func(c *clientConfig) error {
+		if n < 0 {
+			return fmt.Errorf("the number of retries can't be negative")
+		}
+		c.retries = n
+		return nil
+	}
+}
+
+// ClientBackoff configures the exponential backoff between retries, which starts
+// at min and doubles with every retry up to max. A random jitter is applied.
+func ClientBackoff(min, max time.Duration) ClientOption {
+	return func(c *clientConfig) error {
+		if min <= 0 || max < min {
+			return fmt.Errorf("invalid backoff range [%s, %s]", min, max)
+		}
+		c.minBackoff = min
+		c.maxBackoff = max
+		return nil
+	}
+}
+
+// ClientRetryOn configures the classes of errors retried. Stream resets and
+// read timeouts are retried by default, and protocol rejections are not.
+func ClientRetryOn(classes ...RetryClass) ClientOption {
+	return func(c *clientConfig) error {
+		c.retryClasses = make(map[RetryClass]bool, len(classes))
+		for _, cl := range classes {
+			c.retryClasses[cl] = true
+		}
+		return nil
+	}
+}
+
+// ClientReadTimeout configures the time the client waits for the response of a
+// request. It is only used if the context of the request has no deadline.
+func ClientReadTimeout(d time.Duration) ClientOption {
+	return func(c *clientConfig) error {
+		if d <= 0 {
+			return fmt.Errorf("read timeout must be positive")
+		}
+		c.readTimeout = d
+		return nil
+	}
+}
+
+// ClientMetrics registers the metrics of the client in a registry.
+// Metrics are disabled by default.
+func ClientMetrics(reg prometheus.Registerer) ClientOption {
+	return func(c *clientConfig) error {
+		c.registry = reg
+		return nil
+	}
+}
+
+// ClientCache enables caching up to size records in the client. Records are cached
+// per server and key until one of their nodes expires or they reach the max age of
+// the cache, and they are invalidated when the client updates them.
+func ClientCache(size int) ClientOption {
+	return func(c *clientConfig) error {
+		if size <= 0 {
+			return fmt.Errorf("cache size must be positive")
+		}
+		c.cacheSize = size
+		return nil
+	}
+}
+
+// ClientCacheMaxAge configures the time a record is cached at most, so updates
+// from other writers are seen even if the cached nodes haven't expired.
+func ClientCacheMaxAge(d time.Duration) ClientOption {
+	return func(c *clientConfig) error {
+		if d <= 0 {
+			return fmt.Errorf("cache max age must be positive")
+		}
+		c.cacheMaxAge = d
+		return nil
+	}
+}
+
+// ClientServeStale serves expired records from the cache when the server
+// can't be reached, but not when it rejects the request. It requires
+// enabling the cache.
+func ClientServeStale() ClientOption {
+	return func(c *clientConfig) error {
+		c.serveStale = true
+		return nil
+	}
+}
+
+// Options is a structure containing all the options that can be used when constructing a router
+type routerConfig struct {
+	maxPeers int
+	minAcks  int
+}
+
+// Option type for smart record routers
+type RouterOption func(*routerConfig) error
+
+// apply applies the given options to this Option
+func (c *routerConfig) apply(opts ...RouterOption) error {
+	for i, opt := range opts {
+		if err := opt(c); err != nil {
+			return fmt.Errorf("smart record router option %d failed: %s", i, err)
+		}
+	}
+	return nil
+}
+
+var routerDefaults = func(o *routerConfig) error {
+	// Use every peer returned by the routing (k in kad-dht).
+	o.maxPeers = 0
+	// Require a majority of them to store updates.
+	o.minAcks = 0
+	return nil
+}
+
+// RouterMaxPeers limits the number of closest peers the router sends requests to.
+func RouterMaxPeers(n int) RouterOption {
+	return func(c *routerConfig) error {
+		if n <= 0 {
+			return fmt.Errorf("the number of peers must be positive")
+		}
+		c.maxPeers = n
+		return nil
+	}
+}
+
+// RouterMinAcks configures the number of closest peers that need to store an
+// update for it to succeed. If fewer peers are found, all of them are required.
+// By default, a majority of the closest peers is required.
+func RouterMinAcks(n int) RouterOption {
+	return func(c *routerConfig) error {
+		if n <= 0 {
+			return fmt.Errorf("the number of acks must be positive")
+		}
+		c.minAcks = n
+		return nil
+	}
+}
+
+// Options is a structure containing all the options that can be used when constructing a quorum client
+type quorumConfig struct {
+	write         int
+	read          int
+	timeout       time.Duration
+	clientOptions []ClientOption
+}
+
+// Option type for quorum clients
+type QuorumOption func(*quorumConfig) error
+
+// apply applies the given options to this Option
+func (c *quorumConfig) apply(opts ...QuorumOption) error {
+	for i, opt := range opts {
+		if err := opt(c); err != nil {
+			return fmt.Errorf("smart record quorum client option %d failed: %s", i, err)
+		}
+	}
 	return nil
 }
+
+// quorumDefaults require a majority of n servers for reads and writes.
+func quorumDefaults(n int) QuorumOption {
+	return func(o *quorumConfig) error {
+		o.write = n/2 + 1
+		o.read = n/2 + 1
+		o.timeout = readMessageTimeout
+		return nil
+	}
+}
+
+// WriteQuorum configures the number of servers that need to acknowledge an update.
+func WriteQuorum(w int) QuorumOption {
+	return func(c *quorumConfig) error {
+		if w <= 0 {
+			return fmt.Errorf("write quorum must be positive")
+		}
+		c.write = w
+		return nil
+	}
+}
+
+// ReadQuorum configures the number of servers whose responses are merged in a get.
+func ReadQuorum(r int) QuorumOption {
+	return func(c *quorumConfig) error {
+		if r <= 0 {
+			return fmt.Errorf("read quorum must be positive")
+		}
+		c.read = r
+		return nil
+	}
+}
+
+// ServerTimeout configures the timeout of the request to each server.
+func ServerTimeout(t time.Duration) QuorumOption {
+	return func(c *quorumConfig) error {
+		if t <= 0 {
+			return fmt.Errorf("server timeout must be positive")
+		}
+		c.timeout = t
+		return nil
+	}
+}
+
+// QuorumClientOptions configures the options of the client used to send requests to each server.
+func QuorumClientOptions(opts ...ClientOption) QuorumOption {
+	return func(c *quorumConfig) error {
+		c.clientOptions = opts
+		return nil
+	}
+}
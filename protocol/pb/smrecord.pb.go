@@ -25,21 +25,36 @@ const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
 type Message_MessageType int32
 
 const (
-	Message_UPDATE Message_MessageType = 0
-	Message_GET    Message_MessageType = 1
-	Message_QUERY  Message_MessageType = 2
+	Message_UPDATE       Message_MessageType = 0
+	Message_GET          Message_MessageType = 1
+	Message_QUERY        Message_MessageType = 2
+	Message_BATCH_GET    Message_MessageType = 3
+	Message_BATCH_UPDATE Message_MessageType = 4
+	Message_REPLICATE    Message_MessageType = 5
+	Message_SYNC         Message_MessageType = 6
+	Message_SYNC_PULL    Message_MessageType = 7
 )
 
 var Message_MessageType_name = map[int32]string{
 	0: "UPDATE",
 	1: "GET",
 	2: "QUERY",
+	3: "BATCH_GET",
+	4: "BATCH_UPDATE",
+	5: "REPLICATE",
+	6: "SYNC",
+	7: "SYNC_PULL",
 }
 
 var Message_MessageType_value = map[string]int32{
-	"UPDATE": 0,
-	"GET":    1,
-	"QUERY":  2,
+	"UPDATE":       0,
+	"GET":          1,
+	"QUERY":        2,
+	"BATCH_GET":    3,
+	"BATCH_UPDATE": 4,
+	"REPLICATE":    5,
+	"SYNC":         6,
+	"SYNC_PULL":    7,
 }
 
 func (x Message_MessageType) String() string {
@@ -53,12 +68,46 @@ func (Message_MessageType) EnumDescriptor() ([]byte, []int) {
 type Message struct {
 	// defines what type of message it is.
 	Type Message_MessageType `protobuf:"varint,1,opt,name=type,proto3,enum=smrecord.pb.Message_MessageType" json:"type,omitempty"`
-	// Used to specify the key associated with this message.
+	// Used to specify the key associated with this message. In SYNC
+	// messages, the first key of the page of digests requested, and
+	// the first key of the next page in the response, if any.
 	Key []byte `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
 	// The actual value this record is storing
 	Value []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
 	// TTL metadata to use for the sr update
 	TTL uint64 `protobuf:"varint,4,opt,name=TTL,proto3" json:"TTL,omitempty"`
+	// Error reported by the server if the request failed.
+	// Only sent from version 0.1.0 of the protocol.
+	Error string `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	// Entries for each key in batch messages, and the expiration
+	// of the record of each writer in GET responses.
+	// Only sent from version 0.2.0 of the protocol.
+	Entries []*Message_Entry `protobuf:"bytes,6,rep,name=entries,proto3" json:"entries,omitempty"`
+	// Peer that wrote the record in REPLICATE messages.
+	// Only sent from version 0.3.0 of the protocol.
+	Writer []byte `protobuf:"bytes,7,opt,name=writer,proto3" json:"writer,omitempty"`
+	// Signature of the update by the writer.
+	// Only sent from version 0.3.0 of the protocol.
+	Signature []byte `protobuf:"bytes,8,opt,name=signature,proto3" json:"signature,omitempty"`
+	// Absolute expiration time of the record, as a Unix timestamp
+	// in seconds, in REPLICATE messages.
+	// Only sent from version 0.3.0 of the protocol.
+	Expiration uint64 `protobuf:"varint,9,opt,name=expiration,proto3" json:"expiration,omitempty"`
+	// Node that failed to assemble if the update failed.
+	AssemblyError *Message_AssemblyError `protobuf:"bytes,10,opt,name=assembly_error,json=assemblyError,proto3" json:"assembly_error,omitempty"`
+	// Depth up to which the server resolves the references to other
+	// records in GET requests. Zero doesn't resolve them.
+	RefDepth uint32 `protobuf:"varint,11,opt,name=ref_depth,json=refDepth,proto3" json:"ref_depth,omitempty"`
+	// Results of the aggregate smart tags of the record in GET responses,
+	// as a dict keyed by each aggregate smart tag.
+	Aggregates []byte `protobuf:"bytes,12,opt,name=aggregates,proto3" json:"aggregates,omitempty"`
+	// Update as signed by the writer in REPLICATE messages, sent along
+	// with its TTL and signature so replicas verify it. The value holds
+	// the part of the dict of the writer resolved by the server.
+	SignedValue []byte `protobuf:"bytes,13,opt,name=signed_value,json=signedValue,proto3" json:"signed_value,omitempty"`
+	// Last number of the sequence of the key assigned to seq() nodes
+	// in REPLICATE messages, so replicas don't assign it again.
+	Seq uint64 `protobuf:"varint,14,opt,name=seq,proto3" json:"seq,omitempty"`
 }
 
 func (m *Message) Reset()         { *m = Message{} }
@@ -122,28 +171,380 @@ func (m *Message) GetTTL() uint64 {
 	return 0
 }
 
+func (m *Message) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *Message) GetEntries() []*Message_Entry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+func (m *Message) GetWriter() []byte {
+	if m != nil {
+		return m.Writer
+	}
+	return nil
+}
+
+func (m *Message) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func (m *Message) GetExpiration() uint64 {
+	if m != nil {
+		return m.Expiration
+	}
+	return 0
+}
+
+func (m *Message) GetAssemblyError() *Message_AssemblyError {
+	if m != nil {
+		return m.AssemblyError
+	}
+	return nil
+}
+
+func (m *Message) GetRefDepth() uint32 {
+	if m != nil {
+		return m.RefDepth
+	}
+	return 0
+}
+
+func (m *Message) GetAggregates() []byte {
+	if m != nil {
+		return m.Aggregates
+	}
+	return nil
+}
+
+func (m *Message) GetSignedValue() []byte {
+	if m != nil {
+		return m.SignedValue
+	}
+	return nil
+}
+
+func (m *Message) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+// AssemblyError reports the node of a record that failed to assemble.
+// See ir.AssemblyError.
+type Message_AssemblyError struct {
+	Path       string                             `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Reason     string                             `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	Rejections []*Message_AssemblyError_Rejection `protobuf:"bytes,3,rep,name=rejections,proto3" json:"rejections,omitempty"`
+}
+
+func (m *Message_AssemblyError) Reset()         { *m = Message_AssemblyError{} }
+func (m *Message_AssemblyError) String() string { return proto.CompactTextString(m) }
+func (*Message_AssemblyError) ProtoMessage()    {}
+func (*Message_AssemblyError) Descriptor() ([]byte, []int) {
+	return fileDescriptor_37021b58bd064d4d, []int{0, 0}
+}
+func (m *Message_AssemblyError) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Message_AssemblyError) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Message_AssemblyError.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Message_AssemblyError) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Message_AssemblyError.Merge(m, src)
+}
+func (m *Message_AssemblyError) XXX_Size() int {
+	return m.Size()
+}
+func (m *Message_AssemblyError) XXX_DiscardUnknown() {
+	xxx_messageInfo_Message_AssemblyError.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Message_AssemblyError proto.InternalMessageInfo
+
+func (m *Message_AssemblyError) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *Message_AssemblyError) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func (m *Message_AssemblyError) GetRejections() []*Message_AssemblyError_Rejection {
+	if m != nil {
+		return m.Rejections
+	}
+	return nil
+}
+
+// Rejection is the reason an assembler rejected the node.
+type Message_AssemblyError_Rejection struct {
+	Assembler string `protobuf:"bytes,1,opt,name=assembler,proto3" json:"assembler,omitempty"`
+	Reason    string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+}
+
+func (m *Message_AssemblyError_Rejection) Reset()         { *m = Message_AssemblyError_Rejection{} }
+func (m *Message_AssemblyError_Rejection) String() string { return proto.CompactTextString(m) }
+func (*Message_AssemblyError_Rejection) ProtoMessage()    {}
+func (*Message_AssemblyError_Rejection) Descriptor() ([]byte, []int) {
+	return fileDescriptor_37021b58bd064d4d, []int{0, 0, 0}
+}
+func (m *Message_AssemblyError_Rejection) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Message_AssemblyError_Rejection) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Message_AssemblyError_Rejection.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Message_AssemblyError_Rejection) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Message_AssemblyError_Rejection.Merge(m, src)
+}
+func (m *Message_AssemblyError_Rejection) XXX_Size() int {
+	return m.Size()
+}
+func (m *Message_AssemblyError_Rejection) XXX_DiscardUnknown() {
+	xxx_messageInfo_Message_AssemblyError_Rejection.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Message_AssemblyError_Rejection proto.InternalMessageInfo
+
+func (m *Message_AssemblyError_Rejection) GetAssembler() string {
+	if m != nil {
+		return m.Assembler
+	}
+	return ""
+}
+
+func (m *Message_AssemblyError_Rejection) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+// Entry holds the request or response for a single key in batch messages.
+type Message_Entry struct {
+	Key   []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	TTL   uint64 `protobuf:"varint,3,opt,name=TTL,proto3" json:"TTL,omitempty"`
+	// Error reported by the server if the request for the key failed.
+	Error string `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	// Signature of the update by the writer.
+	// Only sent from version 0.3.0 of the protocol.
+	Signature []byte `protobuf:"bytes,5,opt,name=signature,proto3" json:"signature,omitempty"`
+	// Writer of the record in SYNC and SYNC_PULL messages.
+	// Only sent from version 0.4.0 of the protocol.
+	Writer []byte `protobuf:"bytes,6,opt,name=writer,proto3" json:"writer,omitempty"`
+	// Digest of the record of the writer in SYNC messages.
+	// Only sent from version 0.4.0 of the protocol.
+	Hash []byte `protobuf:"bytes,7,opt,name=hash,proto3" json:"hash,omitempty"`
+	// Absolute expiration time, as a Unix timestamp in seconds,
+	// in SYNC and SYNC_PULL messages.
+	// Only sent from version 0.4.0 of the protocol.
+	Expiration uint64 `protobuf:"varint,8,opt,name=expiration,proto3" json:"expiration,omitempty"`
+	// Earliest expiration time of the nodes in the record of the
+	// writer in GET responses, used by clients to cache records.
+	EarliestExpiration uint64 `protobuf:"varint,9,opt,name=earliest_expiration,json=earliestExpiration,proto3" json:"earliest_expiration,omitempty"`
+	// Node that failed to assemble if the update of the key failed.
+	AssemblyError *Message_AssemblyError `protobuf:"bytes,10,opt,name=assembly_error,json=assemblyError,proto3" json:"assembly_error,omitempty"`
+	// Last number of the sequence of the key assigned to seq()
+	// nodes in SYNC_PULL responses.
+	Seq uint64 `protobuf:"varint,11,opt,name=seq,proto3" json:"seq,omitempty"`
+}
+
+func (m *Message_Entry) Reset()         { *m = Message_Entry{} }
+func (m *Message_Entry) String() string { return proto.CompactTextString(m) }
+func (*Message_Entry) ProtoMessage()    {}
+func (*Message_Entry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_37021b58bd064d4d, []int{0, 1}
+}
+func (m *Message_Entry) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Message_Entry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Message_Entry.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Message_Entry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Message_Entry.Merge(m, src)
+}
+func (m *Message_Entry) XXX_Size() int {
+	return m.Size()
+}
+func (m *Message_Entry) XXX_DiscardUnknown() {
+	xxx_messageInfo_Message_Entry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Message_Entry proto.InternalMessageInfo
+
+func (m *Message_Entry) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *Message_Entry) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *Message_Entry) GetTTL() uint64 {
+	if m != nil {
+		return m.TTL
+	}
+	return 0
+}
+
+func (m *Message_Entry) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *Message_Entry) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func (m *Message_Entry) GetWriter() []byte {
+	if m != nil {
+		return m.Writer
+	}
+	return nil
+}
+
+func (m *Message_Entry) GetHash() []byte {
+	if m != nil {
+		return m.Hash
+	}
+	return nil
+}
+
+func (m *Message_Entry) GetExpiration() uint64 {
+	if m != nil {
+		return m.Expiration
+	}
+	return 0
+}
+
+func (m *Message_Entry) GetEarliestExpiration() uint64 {
+	if m != nil {
+		return m.EarliestExpiration
+	}
+	return 0
+}
+
+func (m *Message_Entry) GetAssemblyError() *Message_AssemblyError {
+	if m != nil {
+		return m.AssemblyError
+	}
+	return nil
+}
+
+func (m *Message_Entry) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterEnum("smrecord.pb.Message_MessageType", Message_MessageType_name, Message_MessageType_value)
 	proto.RegisterType((*Message)(nil), "smrecord.pb.Message")
+	proto.RegisterType((*Message_AssemblyError)(nil), "smrecord.pb.Message.AssemblyError")
+	proto.RegisterType((*Message_AssemblyError_Rejection)(nil), "smrecord.pb.Message.AssemblyError.Rejection")
+	proto.RegisterType((*Message_Entry)(nil), "smrecord.pb.Message.Entry")
 }
 
 func init() { proto.RegisterFile("smrecord.proto", fileDescriptor_37021b58bd064d4d) }
 
 var fileDescriptor_37021b58bd064d4d = []byte{
-	// 203 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x2b, 0xce, 0x2d, 0x4a,
-	0x4d, 0xce, 0x2f, 0x4a, 0xd1, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x46, 0xf0, 0x93, 0x94,
-	0x56, 0x30, 0x72, 0xb1, 0xfb, 0xa6, 0x16, 0x17, 0x27, 0xa6, 0xa7, 0x0a, 0x99, 0x70, 0xb1, 0x94,
-	0x54, 0x16, 0xa4, 0x4a, 0x30, 0x2a, 0x30, 0x6a, 0xf0, 0x19, 0x29, 0xe8, 0x21, 0xa9, 0xd3, 0x83,
-	0xaa, 0x81, 0xd1, 0x21, 0x95, 0x05, 0xa9, 0x41, 0x60, 0xd5, 0x42, 0x02, 0x5c, 0xcc, 0xd9, 0xa9,
-	0x95, 0x12, 0x4c, 0x0a, 0x8c, 0x1a, 0x3c, 0x41, 0x20, 0xa6, 0x90, 0x08, 0x17, 0x6b, 0x59, 0x62,
-	0x4e, 0x69, 0xaa, 0x04, 0x33, 0x58, 0x0c, 0xc2, 0x01, 0xa9, 0x0b, 0x09, 0xf1, 0x91, 0x60, 0x51,
-	0x60, 0xd4, 0x60, 0x09, 0x02, 0x31, 0x95, 0x74, 0xb9, 0xb8, 0x91, 0x8c, 0x13, 0xe2, 0xe2, 0x62,
-	0x0b, 0x0d, 0x70, 0x71, 0x0c, 0x71, 0x15, 0x60, 0x10, 0x62, 0xe7, 0x62, 0x76, 0x77, 0x0d, 0x11,
-	0x60, 0x14, 0xe2, 0xe4, 0x62, 0x0d, 0x0c, 0x75, 0x0d, 0x8a, 0x14, 0x60, 0x72, 0x92, 0x38, 0xf1,
-	0x48, 0x8e, 0xf1, 0xc2, 0x23, 0x39, 0xc6, 0x07, 0x8f, 0xe4, 0x18, 0x27, 0x3c, 0x96, 0x63, 0xb8,
-	0xf0, 0x58, 0x8e, 0xe1, 0xc6, 0x63, 0x39, 0x86, 0x24, 0x36, 0xb0, 0xc7, 0x8c, 0x01, 0x01, 0x00,
-	0x00, 0xff, 0xff, 0x10, 0xfd, 0xa8, 0x58, 0xea, 0x00, 0x00, 0x00,
+	// 581 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x94, 0xcf, 0x6e, 0xd3, 0x4c,
+	0x14, 0xc5, 0x33, 0xb1, 0xf3, 0xc7, 0xd7, 0x49, 0x64, 0xcd, 0xf7, 0x09, 0x8d, 0x02, 0xb2, 0x4c,
+	0x57, 0x5e, 0xa0, 0x20, 0x95, 0xbe, 0x40, 0xda, 0x5a, 0x50, 0x29, 0xa0, 0x32, 0xa4, 0x48, 0x5d,
+	0x45, 0xd3, 0xf6, 0x36, 0x31, 0xb4, 0xb1, 0x3b, 0xe3, 0x16, 0xf2, 0x16, 0x3c, 0x16, 0x0b, 0x24,
+	0xba, 0x64, 0x89, 0xda, 0x27, 0xe0, 0x0d, 0xd0, 0x8c, 0xed, 0xc6, 0x69, 0x83, 0x58, 0xb1, 0xea,
+	0x3d, 0xf7, 0x9e, 0x51, 0x4f, 0xee, 0xfd, 0x25, 0xd0, 0x53, 0xe7, 0x12, 0x8f, 0x13, 0x79, 0x32,
+	0x48, 0x65, 0x92, 0x25, 0xd4, 0x5d, 0xea, 0xa3, 0x8d, 0x5f, 0x6d, 0x68, 0xbd, 0x46, 0xa5, 0xc4,
+	0x14, 0xe9, 0x16, 0xd8, 0xd9, 0x22, 0x45, 0x46, 0x02, 0x12, 0xf6, 0x36, 0x83, 0x41, 0xc5, 0x37,
+	0x28, 0x3c, 0xe5, 0xdf, 0xf1, 0x22, 0x45, 0x6e, 0xdc, 0xd4, 0x03, 0xeb, 0x23, 0x2e, 0x58, 0x3d,
+	0x20, 0x61, 0x87, 0xeb, 0x92, 0xfe, 0x0f, 0x8d, 0x2b, 0x71, 0x76, 0x89, 0xcc, 0x32, 0xbd, 0x5c,
+	0x68, 0xdf, 0x78, 0x3c, 0x62, 0x76, 0x40, 0x42, 0x9b, 0xeb, 0x52, 0xfb, 0x50, 0xca, 0x44, 0xb2,
+	0x46, 0x40, 0x42, 0x87, 0xe7, 0x82, 0x6e, 0x41, 0x0b, 0xe7, 0x99, 0x8c, 0x51, 0xb1, 0x66, 0x60,
+	0x85, 0xee, 0x66, 0x7f, 0x6d, 0x90, 0x68, 0x9e, 0xc9, 0x05, 0x2f, 0xad, 0xf4, 0x11, 0x34, 0x3f,
+	0xc9, 0x38, 0x43, 0xc9, 0x5a, 0xe6, 0x9f, 0x16, 0x8a, 0x3e, 0x01, 0x47, 0xc5, 0xd3, 0xb9, 0xc8,
+	0x2e, 0x25, 0xb2, 0xb6, 0x19, 0x2d, 0x1b, 0xd4, 0x07, 0xc0, 0xcf, 0x69, 0x2c, 0x45, 0x16, 0x27,
+	0x73, 0xe6, 0x98, 0x68, 0x95, 0x0e, 0xdd, 0x83, 0x9e, 0x50, 0x0a, 0xcf, 0x8f, 0xce, 0x16, 0x93,
+	0x3c, 0x2a, 0x04, 0x24, 0x74, 0x37, 0x37, 0xd6, 0x46, 0x1a, 0x16, 0xd6, 0x48, 0x3b, 0x79, 0x57,
+	0x54, 0x25, 0x7d, 0x0c, 0x8e, 0xc4, 0xd3, 0xc9, 0x09, 0xa6, 0xd9, 0x8c, 0xb9, 0x01, 0x09, 0xbb,
+	0xbc, 0x2d, 0xf1, 0x74, 0x57, 0x6b, 0x9d, 0x43, 0x4c, 0xa7, 0x12, 0xa7, 0x22, 0x43, 0xc5, 0x3a,
+	0x26, 0x66, 0xa5, 0x43, 0x9f, 0x42, 0x47, 0x87, 0xc6, 0x93, 0x49, 0xbe, 0xd8, 0xae, 0x71, 0xb8,
+	0x79, 0xef, 0x7d, 0xb9, 0x5e, 0x85, 0x17, 0xac, 0x97, 0xaf, 0x57, 0xe1, 0x45, 0xff, 0x1b, 0x81,
+	0xee, 0x4a, 0x24, 0x4a, 0xc1, 0x4e, 0x45, 0x36, 0x33, 0x07, 0x76, 0xb8, 0xa9, 0xf5, 0xe2, 0x24,
+	0x0a, 0x95, 0xcc, 0xcd, 0x05, 0x1d, 0x5e, 0x28, 0x3a, 0x02, 0x90, 0xf8, 0x01, 0x8f, 0xf5, 0x1e,
+	0x14, 0xb3, 0xcc, 0x25, 0x9e, 0xfd, 0xfd, 0x63, 0x0f, 0x78, 0xf9, 0x88, 0x57, 0xde, 0xf7, 0x87,
+	0xe0, 0xdc, 0x0d, 0xf4, 0x4d, 0x8a, 0xdd, 0xa0, 0x2c, 0xb2, 0x2c, 0x1b, 0x7f, 0x0a, 0xd4, 0xff,
+	0x5e, 0x87, 0x86, 0x39, 0x7a, 0x49, 0x1c, 0x59, 0x43, 0x5c, 0x7d, 0x0d, 0x71, 0xd6, 0x1a, 0xe2,
+	0xec, 0x2a, 0x71, 0x2b, 0x8c, 0x34, 0xee, 0x33, 0xb2, 0x24, 0xab, 0xb9, 0x42, 0x16, 0x05, 0x7b,
+	0x26, 0xd4, 0xac, 0xe0, 0xcd, 0xd4, 0xf7, 0x78, 0x6a, 0x3f, 0xe0, 0xe9, 0x39, 0xfc, 0x87, 0x42,
+	0x9e, 0xc5, 0xa8, 0xb2, 0xc9, 0x03, 0xf0, 0x68, 0x39, 0x8a, 0xfe, 0x09, 0x80, 0x05, 0x20, 0xee,
+	0x1d, 0x20, 0x1b, 0x57, 0xe0, 0x56, 0xbe, 0xce, 0x14, 0xa0, 0x79, 0xb0, 0xbf, 0x3b, 0x1c, 0x47,
+	0x5e, 0x8d, 0xb6, 0xc0, 0x7a, 0x19, 0x8d, 0x3d, 0x42, 0x1d, 0x68, 0xbc, 0x3d, 0x88, 0xf8, 0xa1,
+	0x57, 0xa7, 0x5d, 0x70, 0xb6, 0x87, 0xe3, 0x9d, 0x57, 0x13, 0x3d, 0xb1, 0xa8, 0x07, 0x9d, 0x5c,
+	0x16, 0x8f, 0x6c, 0x6d, 0xe0, 0xd1, 0xfe, 0x68, 0x6f, 0x47, 0xcb, 0x06, 0x6d, 0x83, 0xfd, 0xee,
+	0xf0, 0xcd, 0x8e, 0xd7, 0xd4, 0x03, 0x5d, 0x4d, 0xf6, 0x0f, 0x46, 0x23, 0xaf, 0xb5, 0xcd, 0xbe,
+	0xde, 0xf8, 0xe4, 0xfa, 0xc6, 0x27, 0x3f, 0x6f, 0x7c, 0xf2, 0xe5, 0xd6, 0xaf, 0x5d, 0xdf, 0xfa,
+	0xb5, 0x1f, 0xb7, 0x7e, 0xed, 0xa8, 0x69, 0x7e, 0xa1, 0x5e, 0xfc, 0x1e, 0x00, 0x29, 0x55, 0x3a,
+	0x67, 0xb3, 0x04, 0x00, 0x00,
 }
 
 func (m *Message) Marshal() (dAtA []byte, err error) {
@@ -166,6 +567,82 @@ func (m *Message) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.Seq != 0 {
+		i = encodeVarintSmrecord(dAtA, i, uint64(m.Seq))
+		i--
+		dAtA[i] = 0x70
+	}
+	if len(m.SignedValue) > 0 {
+		i -= len(m.SignedValue)
+		copy(dAtA[i:], m.SignedValue)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.SignedValue)))
+		i--
+		dAtA[i] = 0x6a
+	}
+	if len(m.Aggregates) > 0 {
+		i -= len(m.Aggregates)
+		copy(dAtA[i:], m.Aggregates)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Aggregates)))
+		i--
+		dAtA[i] = 0x62
+	}
+	if m.RefDepth != 0 {
+		i = encodeVarintSmrecord(dAtA, i, uint64(m.RefDepth))
+		i--
+		dAtA[i] = 0x58
+	}
+	if m.AssemblyError != nil {
+		{
+			size, err := m.AssemblyError.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintSmrecord(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x52
+	}
+	if m.Expiration != 0 {
+		i = encodeVarintSmrecord(dAtA, i, uint64(m.Expiration))
+		i--
+		dAtA[i] = 0x48
+	}
+	if len(m.Signature) > 0 {
+		i -= len(m.Signature)
+		copy(dAtA[i:], m.Signature)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Signature)))
+		i--
+		dAtA[i] = 0x42
+	}
+	if len(m.Writer) > 0 {
+		i -= len(m.Writer)
+		copy(dAtA[i:], m.Writer)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Writer)))
+		i--
+		dAtA[i] = 0x3a
+	}
+	if len(m.Entries) > 0 {
+		for iNdEx := len(m.Entries) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Entries[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintSmrecord(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x32
+		}
+	}
+	if len(m.Error) > 0 {
+		i -= len(m.Error)
+		copy(dAtA[i:], m.Error)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Error)))
+		i--
+		dAtA[i] = 0x2a
+	}
 	if m.TTL != 0 {
 		i = encodeVarintSmrecord(dAtA, i, uint64(m.TTL))
 		i--
@@ -190,50 +667,1076 @@ func (m *Message) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i--
 		dAtA[i] = 0x8
 	}
-	return len(dAtA) - i, nil
-}
+	return len(dAtA) - i, nil
+}
+
+func (m *Message_AssemblyError) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Message_AssemblyError) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Message_AssemblyError) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Rejections) > 0 {
+		for iNdEx := len(m.Rejections) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Rejections[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintSmrecord(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
+	if len(m.Reason) > 0 {
+		i -= len(m.Reason)
+		copy(dAtA[i:], m.Reason)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Reason)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.Path) > 0 {
+		i -= len(m.Path)
+		copy(dAtA[i:], m.Path)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Path)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *Message_AssemblyError_Rejection) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Message_AssemblyError_Rejection) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Message_AssemblyError_Rejection) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Reason) > 0 {
+		i -= len(m.Reason)
+		copy(dAtA[i:], m.Reason)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Reason)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.Assembler) > 0 {
+		i -= len(m.Assembler)
+		copy(dAtA[i:], m.Assembler)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Assembler)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *Message_Entry) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Message_Entry) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Message_Entry) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.Seq != 0 {
+		i = encodeVarintSmrecord(dAtA, i, uint64(m.Seq))
+		i--
+		dAtA[i] = 0x58
+	}
+	if m.AssemblyError != nil {
+		{
+			size, err := m.AssemblyError.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintSmrecord(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x52
+	}
+	if m.EarliestExpiration != 0 {
+		i = encodeVarintSmrecord(dAtA, i, uint64(m.EarliestExpiration))
+		i--
+		dAtA[i] = 0x48
+	}
+	if m.Expiration != 0 {
+		i = encodeVarintSmrecord(dAtA, i, uint64(m.Expiration))
+		i--
+		dAtA[i] = 0x40
+	}
+	if len(m.Hash) > 0 {
+		i -= len(m.Hash)
+		copy(dAtA[i:], m.Hash)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Hash)))
+		i--
+		dAtA[i] = 0x3a
+	}
+	if len(m.Writer) > 0 {
+		i -= len(m.Writer)
+		copy(dAtA[i:], m.Writer)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Writer)))
+		i--
+		dAtA[i] = 0x32
+	}
+	if len(m.Signature) > 0 {
+		i -= len(m.Signature)
+		copy(dAtA[i:], m.Signature)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Signature)))
+		i--
+		dAtA[i] = 0x2a
+	}
+	if len(m.Error) > 0 {
+		i -= len(m.Error)
+		copy(dAtA[i:], m.Error)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Error)))
+		i--
+		dAtA[i] = 0x22
+	}
+	if m.TTL != 0 {
+		i = encodeVarintSmrecord(dAtA, i, uint64(m.TTL))
+		i--
+		dAtA[i] = 0x18
+	}
+	if len(m.Value) > 0 {
+		i -= len(m.Value)
+		copy(dAtA[i:], m.Value)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Value)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.Key) > 0 {
+		i -= len(m.Key)
+		copy(dAtA[i:], m.Key)
+		i = encodeVarintSmrecord(dAtA, i, uint64(len(m.Key)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func encodeVarintSmrecord(dAtA []byte, offset int, v uint64) int {
+	offset -= sovSmrecord(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return base
+}
+func (m *Message) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Type != 0 {
+		n += 1 + sovSmrecord(uint64(m.Type))
+	}
+	l = len(m.Key)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	l = len(m.Value)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	if m.TTL != 0 {
+		n += 1 + sovSmrecord(uint64(m.TTL))
+	}
+	l = len(m.Error)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	if len(m.Entries) > 0 {
+		for _, e := range m.Entries {
+			l = e.Size()
+			n += 1 + l + sovSmrecord(uint64(l))
+		}
+	}
+	l = len(m.Writer)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	l = len(m.Signature)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	if m.Expiration != 0 {
+		n += 1 + sovSmrecord(uint64(m.Expiration))
+	}
+	if m.AssemblyError != nil {
+		l = m.AssemblyError.Size()
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	if m.RefDepth != 0 {
+		n += 1 + sovSmrecord(uint64(m.RefDepth))
+	}
+	l = len(m.Aggregates)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	l = len(m.SignedValue)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	if m.Seq != 0 {
+		n += 1 + sovSmrecord(uint64(m.Seq))
+	}
+	return n
+}
+
+func (m *Message_AssemblyError) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Path)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	l = len(m.Reason)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	if len(m.Rejections) > 0 {
+		for _, e := range m.Rejections {
+			l = e.Size()
+			n += 1 + l + sovSmrecord(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *Message_AssemblyError_Rejection) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Assembler)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	l = len(m.Reason)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	return n
+}
+
+func (m *Message_Entry) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Key)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	l = len(m.Value)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	if m.TTL != 0 {
+		n += 1 + sovSmrecord(uint64(m.TTL))
+	}
+	l = len(m.Error)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	l = len(m.Signature)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	l = len(m.Writer)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	l = len(m.Hash)
+	if l > 0 {
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	if m.Expiration != 0 {
+		n += 1 + sovSmrecord(uint64(m.Expiration))
+	}
+	if m.EarliestExpiration != 0 {
+		n += 1 + sovSmrecord(uint64(m.EarliestExpiration))
+	}
+	if m.AssemblyError != nil {
+		l = m.AssemblyError.Size()
+		n += 1 + l + sovSmrecord(uint64(l))
+	}
+	if m.Seq != 0 {
+		n += 1 + sovSmrecord(uint64(m.Seq))
+	}
+	return n
+}
+
+func sovSmrecord(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
+func sozSmrecord(x uint64) (n int) {
+	return sovSmrecord(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *Message) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowSmrecord
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Message: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Message: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Type", wireType)
+			}
+			m.Type = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Type |= Message_MessageType(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Key", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Key = append(m.Key[:0], dAtA[iNdEx:postIndex]...)
+			if m.Key == nil {
+				m.Key = []byte{}
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Value = append(m.Value[:0], dAtA[iNdEx:postIndex]...)
+			if m.Value == nil {
+				m.Value = []byte{}
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TTL", wireType)
+			}
+			m.TTL = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TTL |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Error", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Error = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Entries", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Entries = append(m.Entries, &Message_Entry{})
+			if err := m.Entries[len(m.Entries)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Writer", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Writer = append(m.Writer[:0], dAtA[iNdEx:postIndex]...)
+			if m.Writer == nil {
+				m.Writer = []byte{}
+			}
+			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
+			if m.Signature == nil {
+				m.Signature = []byte{}
+			}
+			iNdEx = postIndex
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Expiration", wireType)
+			}
+			m.Expiration = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Expiration |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field AssemblyError", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.AssemblyError == nil {
+				m.AssemblyError = &Message_AssemblyError{}
+			}
+			if err := m.AssemblyError.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RefDepth", wireType)
+			}
+			m.RefDepth = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RefDepth |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 12:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Aggregates", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Aggregates = append(m.Aggregates[:0], dAtA[iNdEx:postIndex]...)
+			if m.Aggregates == nil {
+				m.Aggregates = []byte{}
+			}
+			iNdEx = postIndex
+		case 13:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SignedValue", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SignedValue = append(m.SignedValue[:0], dAtA[iNdEx:postIndex]...)
+			if m.SignedValue == nil {
+				m.SignedValue = []byte{}
+			}
+			iNdEx = postIndex
+		case 14:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Seq", wireType)
+			}
+			m.Seq = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Seq |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipSmrecord(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Message_AssemblyError) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowSmrecord
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: AssemblyError: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: AssemblyError: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Path", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Path = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Reason", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Reason = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Rejections", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Rejections = append(m.Rejections, &Message_AssemblyError_Rejection{})
+			if err := m.Rejections[len(m.Rejections)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipSmrecord(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Message_AssemblyError_Rejection) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowSmrecord
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Rejection: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Rejection: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Assembler", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Assembler = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Reason", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Reason = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipSmrecord(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
 
-func encodeVarintSmrecord(dAtA []byte, offset int, v uint64) int {
-	offset -= sovSmrecord(v)
-	base := offset
-	for v >= 1<<7 {
-		dAtA[offset] = uint8(v&0x7f | 0x80)
-		v >>= 7
-		offset++
-	}
-	dAtA[offset] = uint8(v)
-	return base
-}
-func (m *Message) Size() (n int) {
-	if m == nil {
-		return 0
-	}
-	var l int
-	_ = l
-	if m.Type != 0 {
-		n += 1 + sovSmrecord(uint64(m.Type))
-	}
-	l = len(m.Key)
-	if l > 0 {
-		n += 1 + l + sovSmrecord(uint64(l))
-	}
-	l = len(m.Value)
-	if l > 0 {
-		n += 1 + l + sovSmrecord(uint64(l))
-	}
-	if m.TTL != 0 {
-		n += 1 + sovSmrecord(uint64(m.TTL))
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
 	}
-	return n
-}
-
-func sovSmrecord(x uint64) (n int) {
-	return (math_bits.Len64(x|1) + 6) / 7
-}
-func sozSmrecord(x uint64) (n int) {
-	return sovSmrecord(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+	return nil
 }
-func (m *Message) Unmarshal(dAtA []byte) error {
+func (m *Message_Entry) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -256,17 +1759,17 @@ func (m *Message) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: Message: wiretype end group for non-group")
+			return fmt.Errorf("proto: Entry: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: Message: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: Entry: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Type", wireType)
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Key", wireType)
 			}
-			m.Type = 0
+			var byteLen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowSmrecord
@@ -276,14 +1779,29 @@ func (m *Message) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.Type |= Message_MessageType(b&0x7F) << shift
+				byteLen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			if byteLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Key = append(m.Key[:0], dAtA[iNdEx:postIndex]...)
+			if m.Key == nil {
+				m.Key = []byte{}
+			}
+			iNdEx = postIndex
 		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Key", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
 			}
 			var byteLen int
 			for shift := uint(0); ; shift += 7 {
@@ -310,14 +1828,65 @@ func (m *Message) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Key = append(m.Key[:0], dAtA[iNdEx:postIndex]...)
-			if m.Key == nil {
-				m.Key = []byte{}
+			m.Value = append(m.Value[:0], dAtA[iNdEx:postIndex]...)
+			if m.Value == nil {
+				m.Value = []byte{}
 			}
 			iNdEx = postIndex
 		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TTL", wireType)
+			}
+			m.TTL = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TTL |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Error", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Error = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
 			}
 			var byteLen int
 			for shift := uint(0); ; shift += 7 {
@@ -344,16 +1913,84 @@ func (m *Message) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Value = append(m.Value[:0], dAtA[iNdEx:postIndex]...)
-			if m.Value == nil {
-				m.Value = []byte{}
+			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
+			if m.Signature == nil {
+				m.Signature = []byte{}
 			}
 			iNdEx = postIndex
-		case 4:
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Writer", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Writer = append(m.Writer[:0], dAtA[iNdEx:postIndex]...)
+			if m.Writer == nil {
+				m.Writer = []byte{}
+			}
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Hash", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Hash = append(m.Hash[:0], dAtA[iNdEx:postIndex]...)
+			if m.Hash == nil {
+				m.Hash = []byte{}
+			}
+			iNdEx = postIndex
+		case 8:
 			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field TTL", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Expiration", wireType)
 			}
-			m.TTL = 0
+			m.Expiration = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowSmrecord
@@ -363,7 +2000,81 @@ func (m *Message) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.TTL |= uint64(b&0x7F) << shift
+				m.Expiration |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EarliestExpiration", wireType)
+			}
+			m.EarliestExpiration = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.EarliestExpiration |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field AssemblyError", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthSmrecord
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.AssemblyError == nil {
+				m.AssemblyError = &Message_AssemblyError{}
+			}
+			if err := m.AssemblyError.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Seq", wireType)
+			}
+			m.Seq = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSmrecord
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Seq |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -10,6 +11,10 @@ import (
 	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	xr "github.com/libp2p/go-routing-language/syntax"
+	"github.com/libp2p/go-smart-record/ir"
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+	"github.com/libp2p/go-smart-record/vm"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 // TTL for updates in test cases
@@ -45,7 +50,7 @@ var in = xr.Dict{
 	},
 }
 
-func setupServer(ctx context.Context, t *testing.T) *smartRecordServer {
+func setupServer(ctx context.Context, t *testing.T, opts ...ServerOption) *smartRecordServer {
 
 	h, err := bhost.NewHost(ctx, swarmt.GenSwarm(t, ctx, swarmt.OptDisableReuseport), nil)
 	if err != nil {
@@ -55,7 +60,7 @@ func setupServer(ctx context.Context, t *testing.T) *smartRecordServer {
 	s, err := newSmartRecordServer(
 		ctx,
 		h,
-		[]ServerOption{VMGcPeriod(gcPeriod), ServerProtocolPrefix(prefix)}...,
+		append([]ServerOption{VMGcPeriod(gcPeriod), ServerProtocolPrefix(prefix)}, opts...)...,
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -63,7 +68,7 @@ func setupServer(ctx context.Context, t *testing.T) *smartRecordServer {
 	return s
 }
 
-func setupClient(ctx context.Context, t *testing.T) *smartRecordClient {
+func setupClient(ctx context.Context, t *testing.T, opts ...ClientOption) *smartRecordClient {
 
 	h, err := bhost.NewHost(ctx, swarmt.GenSwarm(t, ctx, swarmt.OptDisableReuseport), nil)
 	if err != nil {
@@ -73,7 +78,7 @@ func setupClient(ctx context.Context, t *testing.T) *smartRecordClient {
 	c, err := newSmartRecordClient(
 		ctx,
 		h,
-		[]ClientOption{ClientProtocolPrefix(prefix)}...,
+		append([]ClientOption{ClientProtocolPrefix(prefix)}, opts...)...,
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -215,6 +220,253 @@ func TestUpdateSameKeySamePeer(t *testing.T) {
 	}
 }
 
+func TestCodecNegotiation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cases := []struct {
+		client   []vm.Codec
+		server   []vm.Codec
+		expected vm.Codec
+	}{
+		{nil, nil, vm.CodecProtobuf},
+		{[]vm.Codec{vm.CodecJSON}, nil, vm.CodecJSON},
+		{nil, []vm.Codec{vm.CodecJSON, vm.CodecCBOR}, vm.CodecCBOR},
+		{[]vm.Codec{vm.CodecCBOR, vm.CodecProtobuf}, nil, vm.CodecCBOR},
+	}
+
+	for _, cs := range cases {
+		var copts []ClientOption
+		var sopts []ServerOption
+		if cs.client != nil {
+			copts = append(copts, ClientCodecs(cs.client...))
+		}
+		if cs.server != nil {
+			sopts = append(sopts, ServerCodecs(cs.server...))
+		}
+		c := setupClient(ctx, t, copts...)
+		s := setupServer(ctx, t, sopts...)
+		connect(ctx, t, c.host, s.host)
+
+		k := "234"
+		err := c.Update(ctx, k, s.host.ID(), in1, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := c.Get(ctx, k, s.host.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !xr.IsEqual(in1, *(*out)[c.host.ID()]) {
+			t.Fatal("end-to-end update failed", in1, *out)
+		}
+
+		w, err := c.wireForPeer(ctx, s.host.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.codec != cs.expected {
+			t.Fatalf("wrong codec negotiated: expected %s, got %s", cs.expected, w.codec)
+		}
+	}
+}
+
+func TestNoCommonCodec(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupClient(ctx, t, ClientCodecs(vm.CodecJSON))
+	s := setupServer(ctx, t, ServerCodecs(vm.CodecProtobuf))
+	connect(ctx, t, c.host, s.host)
+
+	if _, err := c.Get(ctx, "234", s.host.ID()); err == nil {
+		t.Fatal("get should fail if there is no codec in common")
+	}
+}
+
+func TestBatchRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Batch messages, and fallback to a request per key with older versions.
+	for _, v := range []Version{Version020, Version010} {
+		c := setupClient(ctx, t)
+		s := setupServer(ctx, t, ServerVersions(v))
+		connect(ctx, t, c.host, s.host)
+
+		recs := map[string]xr.Dict{"k1": in1, "k2": in2}
+		err := c.UpdateMany(ctx, recs, s.host.ID(), ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := c.GetMany(ctx, []string{"k1", "k2", "k3"}, s.host.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !xr.IsEqual(in1, *(*out["k1"])[c.host.ID()]) || !xr.IsEqual(in2, *(*out["k2"])[c.host.ID()]) {
+			t.Fatal("batch update failed", out)
+		}
+		if len(*out["k3"]) != 0 {
+			t.Fatal("empty key should return an empty record", *out["k3"])
+		}
+	}
+}
+
+func TestBatchPartialFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupClient(ctx, t)
+	s := setupServer(ctx, t)
+	connect(ctx, t, c.host, s.host)
+
+	// Empty keys fail, but the rest of the batch is applied.
+	recs := map[string]xr.Dict{"k1": in1, "": in2}
+	err := c.UpdateMany(ctx, recs, s.host.ID(), ttl)
+	berr, ok := err.(BatchError)
+	if !ok {
+		t.Fatal("expected a batch error", err)
+	}
+	if len(berr) != 1 || berr[""] == nil {
+		t.Fatal("wrong keys reported in batch error", berr)
+	}
+
+	out, err := c.GetMany(ctx, []string{"k1", ""}, s.host.ID())
+	berr, ok = err.(BatchError)
+	if !ok || len(berr) != 1 || berr[""] == nil {
+		t.Fatal("expected a batch error for the empty key", err)
+	}
+	if !xr.IsEqual(in1, *(*out["k1"])[c.host.ID()]) {
+		t.Fatal("key not updated in a batch with failures", out)
+	}
+}
+
+func TestBatchMissingKeys(t *testing.T) {
+	rb, err := vm.MarshalRecordValueWith(vm.CodecJSON, vm.RecordValue{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// k2 is not returned by the server.
+	entries := []*pb.Message_Entry{{Key: []byte("k1"), Value: rb}}
+	out, err := batchGetResults(vm.CodecJSON, []string{"k1", "k2"}, entries)
+	berr, ok := err.(BatchError)
+	if !ok || len(berr) != 1 || berr["k2"] == nil {
+		t.Fatal("expected a batch error for the key not returned", err)
+	}
+	if rv, ok := out["k1"]; !ok || len(*rv) != 0 {
+		t.Fatal("key without record should return an empty record", out)
+	}
+}
+
+func TestAssemblyErrorResponse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupClient(ctx, t)
+	// The server doesn't accept lists.
+	grammar := ir.SequenceAssembler{ir.DictAssembler{}, ir.StringAssembler{}}
+	s := setupServer(ctx, t, Assembler(ir.AssemblerContext{Grammar: grammar}))
+	connect(ctx, t, c.host, s.host)
+
+	rec := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "profile"}, Value: xr.Dict{
+				Pairs: xr.Pairs{
+					xr.Pair{Key: xr.String{Value: "addrs"}, Value: xr.List{}},
+				},
+			}},
+		},
+	}
+	check := func(err error) {
+		var ae *ir.AssemblyError
+		if !errors.As(err, &ae) {
+			t.Fatal("expected an assembly error", err)
+		}
+		if ae.Path != ".profile.addrs" || len(ae.Rejections) != 2 {
+			t.Fatal("wrong assembly error", ae)
+		}
+	}
+	check(c.Update(ctx, "k1", s.host.ID(), rec, ttl))
+
+	err := c.UpdateMany(ctx, map[string]xr.Dict{"k1": rec, "k2": in1}, s.host.ID(), ttl)
+	berr, ok := err.(BatchError)
+	if !ok || len(berr) != 1 {
+		t.Fatal("expected a batch error for k1", err)
+	}
+	check(berr["k1"])
+}
+
+func TestStrictAssembly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupClient(ctx, t)
+	strict := setupServer(ctx, t, StrictAssembly())
+	lenient := setupServer(ctx, t)
+	connect(ctx, t, c.host, strict.host)
+	connect(ctx, t, c.host, lenient.host)
+
+	// Typo in the name of the address argument.
+	rec := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "addr"}, Value: xr.Predicate{
+				Tag:   "dialable",
+				Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "adress"}, Value: xr.String{Value: "/ip4/127.0.0.1/tcp/4001"}}},
+			}},
+		},
+	}
+	err := c.Update(ctx, "k1", strict.host.ID(), rec, ttl)
+	var ae *ir.AssemblyError
+	if !errors.As(err, &ae) || ae.Path != ".addr" {
+		t.Fatal("expected an invalid smart tag error", err)
+	}
+	if out := strict.GetLocal("k1"); len(out) != 0 {
+		t.Fatal("invalid smart tag was stored", out)
+	}
+	if err := c.Update(ctx, "k1", lenient.host.ID(), rec, ttl); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAsyncDialChecks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h, err := bhost.NewHost(ctx, swarmt.GenSwarm(t, ctx, swarmt.OptDisableReuseport), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSmartRecordServer(ctx, h, DialChecks(0, 1, time.Second, time.Minute)); err == nil {
+		t.Fatal("dial checks without workers should fail")
+	}
+	s := setupServer(ctx, t, DialChecks(1, 16, 5*time.Second, time.Minute))
+	var addr ma.Multiaddr
+	for _, a := range h.Addrs() {
+		if _, err := a.ValueForProtocol(ma.P_TCP); err == nil {
+			addr = a
+		}
+	}
+
+	rec := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "addr"}, Value: xr.Predicate{
+				Tag:   "dialable",
+				Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "address"}, Value: xr.String{Value: addr.String() + "/p2p/" + h.ID().String()}}},
+			}},
+		},
+	}
+	if err := s.UpdateLocal("k1", h.ID(), rec, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// The tag is pending until the dial ends.
+	for i := 0; ; i++ {
+		tag := s.GetLocal("k1")[h.ID()].Get(xr.String{Value: "addr"}).(xr.Predicate).Tag
+		if tag == "dialed" {
+			break
+		}
+		if tag != "pending" || i == 50 {
+			t.Fatal("dial check not resolved", tag)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 func TestParallelRequests(t *testing.T) {
 	//TODO
 }
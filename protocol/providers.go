@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/libp2p/go-smart-record/ir"
+	"github.com/libp2p/go-smart-record/ir/base"
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// Providers returns the providers of a CID in a record, aggregating the
+// provides smart tags of all its writers (see base.Provides). Like the
+// GET_PROVIDERS request of the DHT, each provider is returned once, with
+// all the addresses it provided, sorted by peer ID.
+func Providers(rv vm.RecordValue, c cid.Cid) []peer.AddrInfo {
+	addrs := make(map[peer.ID][]ma.Multiaddr)
+	for writer, d := range rv {
+		walkPredicates(*d, func(p xr.Predicate) {
+			if p.Tag != "provides" {
+				return
+			}
+			n, err := base.ProvidesAssembler{}.Assemble(ir.AssemblerContext{}, p)
+			if err != nil || !n.(*base.Provides).Cid().Equals(c) {
+				return
+			}
+			if _, ok := addrs[writer]; !ok {
+				addrs[writer] = []ma.Multiaddr{}
+			}
+			for _, a := range n.(*base.Provides).Addrs() {
+				if !containsAddr(addrs[writer], a) {
+					addrs[writer] = append(addrs[writer], a)
+				}
+			}
+		})
+	}
+	out := make([]peer.AddrInfo, 0, len(addrs))
+	for p, as := range addrs {
+		out = append(out, peer.AddrInfo{ID: p, Addrs: as})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func containsAddr(as []ma.Multiaddr, a ma.Multiaddr) bool {
+	for _, x := range as {
+		if x.Equal(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkPredicates calls f for every predicate in a syntactic node.
+func walkPredicates(n xr.Node, f func(xr.Predicate)) {
+	switch n1 := n.(type) {
+	case xr.Dict:
+		for _, p := range n1.Pairs {
+			walkPredicates(p.Key, f)
+			walkPredicates(p.Value, f)
+		}
+	case xr.List:
+		for _, e := range n1.Elements {
+			walkPredicates(e, f)
+		}
+	case xr.Predicate:
+		f(n1)
+		for _, e := range n1.Positional {
+			walkPredicates(e, f)
+		}
+		for _, p := range n1.Named {
+			walkPredicates(p.Key, f)
+			walkPredicates(p.Value, f)
+		}
+	}
+}
+
+// mapPredicates returns a copy of a syntactic node with
+// every predicate in it replaced by the result of f.
+func mapPredicates(n xr.Node, f func(xr.Predicate) xr.Node) xr.Node {
+	switch n1 := n.(type) {
+	case xr.Dict:
+		ps := make(xr.Pairs, len(n1.Pairs))
+		for i, p := range n1.Pairs {
+			ps[i] = xr.Pair{Key: mapPredicates(p.Key, f), Value: mapPredicates(p.Value, f)}
+		}
+		return xr.Dict{Pairs: ps}
+	case xr.List:
+		els := make(xr.Nodes, len(n1.Elements))
+		for i, e := range n1.Elements {
+			els[i] = mapPredicates(e, f)
+		}
+		return xr.List{Elements: els}
+	case xr.Predicate:
+		return f(n1)
+	}
+	return n
+}
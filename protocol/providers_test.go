@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	p2ptestutil "github.com/libp2p/go-libp2p-netutil"
+	xr "github.com/libp2p/go-routing-language/syntax"
+)
+
+func provides(c string, addrs ...string) xr.Node {
+	l := xr.List{Elements: xr.Nodes{}}
+	for _, a := range addrs {
+		l.Elements = append(l.Elements, xr.String{Value: a})
+	}
+	return xr.Predicate{
+		Tag: "provides",
+		Named: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "cid"}, Value: xr.String{Value: c}},
+			xr.Pair{Key: xr.String{Value: "addrs"}, Value: l},
+		},
+	}
+}
+
+func TestProviders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t)
+	c1, _ := cid.Decode("bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi")
+	c2, _ := cid.Decode("QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG")
+	w1, _ := p2ptestutil.RandTestBogusIdentity()
+	w2, _ := p2ptestutil.RandTestBogusIdentity()
+
+	rec1 := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "a"}, Value: provides(c1.String(), "/ip4/1.2.3.4/tcp/4001")},
+		xr.Pair{Key: xr.String{Value: "b"}, Value: xr.List{Elements: xr.Nodes{
+			provides(c1.String(), "/ip4/1.2.3.4/tcp/4001", "/ip4/1.2.3.4/udp/4001/quic"),
+			provides(c2.String(), "/ip4/5.6.7.8/tcp/4001"),
+		}}},
+	}}
+	rec2 := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "a"}, Value: provides(c1.String(), "/ip4/127.0.0.1/tcp/4001")},
+	}}
+	if err := s.UpdateLocal("k", w1.ID(), rec1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateLocal("k", w2.ID(), rec2, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	provs := s.GetProviders("k", c1)
+	if len(provs) != 2 {
+		t.Fatal("wrong number of providers", provs)
+	}
+	for _, p := range provs {
+		switch p.ID {
+		case w1.ID():
+			if len(p.Addrs) != 2 {
+				t.Fatal("addresses of the provider not aggregated", p.Addrs)
+			}
+		case w2.ID():
+			// Private addresses are filtered out.
+			if len(p.Addrs) != 0 {
+				t.Fatal("private addresses not filtered", p.Addrs)
+			}
+		default:
+			t.Fatal("unexpected provider", p.ID)
+		}
+	}
+	if provs := s.GetProviders("k", c2); len(provs) != 1 || provs[0].ID != w1.ID() {
+		t.Fatal("wrong providers", provs)
+	}
+}
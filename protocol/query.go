@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	"github.com/libp2p/go-smart-record/ir/base"
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// selector selects the parts of a record returned by a query.
+// Only ranges of sequence numbers of logs are understood for now.
+type selector struct {
+	from, to uint64 // Sequence numbers of the entries of logs, both included.
+}
+
+// parseSelector parses a selector of the form {from: INT, to: INT},
+// where both sequence numbers are optional.
+func parseSelector(d xr.Dict) (selector, error) {
+	s := selector{from: 0, to: math.MaxUint64}
+	for _, b := range []struct {
+		name string
+		v    *uint64
+	}{{"from", &s.from}, {"to", &s.to}} {
+		n := d.Get(xr.String{Value: b.name})
+		if n == nil {
+			continue
+		}
+		i, ok := n.(xr.Int)
+		if !ok || i.Int == nil || !i.IsUint64() {
+			return selector{}, fmt.Errorf("%s must be a non-negative integer", b.name)
+		}
+		*b.v = i.Uint64()
+	}
+	return s, nil
+}
+
+// apply returns the record with the entries of its logs
+// outside the range of the selector removed.
+func (s selector) apply(rv vm.RecordValue) vm.RecordValue {
+	out := make(vm.RecordValue, len(rv))
+	for writer, d := range rv {
+		sd := mapPredicates(*d, s.selectLog).(xr.Dict)
+		out[writer] = &sd
+	}
+	return out
+}
+
+func (s selector) selectLog(p xr.Predicate) xr.Node {
+	if p.Tag != "log" {
+		return p
+	}
+	// Stored logs are in the form resolved by the server.
+	l, err := base.LogAssembler{}.Assemble(ir.AssemblerContext{Grammar: ir.SyntacticGrammar, Merging: true}, p)
+	if err != nil {
+		return p
+	}
+	return l.(*base.Log).Range(s.from, s.to).Disassemble()
+}
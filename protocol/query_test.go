@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	"github.com/libp2p/go-smart-record/ir/base"
+)
+
+func TestQueryLogRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupClient(ctx, t)
+	s := setupServer(ctx, t)
+	connect(ctx, t, c.host, s.host)
+
+	k := "234"
+	for _, v := range []string{"a", "b", "c", "d"} {
+		rec := xr.Dict{Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "chat"}, Value: xr.Predicate{Tag: "appendLog", Positional: xr.Nodes{xr.String{Value: v}}}},
+		}}
+		if err := c.Update(ctx, k, s.host.ID(), rec, ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := func(sel xr.Dict) []uint64 {
+		out, err := c.Query(ctx, k, s.host.ID(), sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := (*out)[c.host.ID()].Get(xr.String{Value: "chat"})
+		n, err := base.LogAssembler{}.Assemble(ir.AssemblerContext{Grammar: ir.SyntacticGrammar, Merging: true}, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs, _ := n.(*base.Log).Entries()
+		return seqs
+	}
+	if seqs := entries(xr.Dict{}); len(seqs) != 4 {
+		t.Fatal("whole log not returned", seqs)
+	}
+	sel := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "from"}, Value: xr.NewInt64(2)},
+		xr.Pair{Key: xr.String{Value: "to"}, Value: xr.NewInt64(3)},
+	}}
+	if seqs := entries(sel); len(seqs) != 2 || seqs[0] != 2 || seqs[1] != 3 {
+		t.Fatal("wrong range of entries", seqs)
+	}
+	sel = xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "from"}, Value: xr.NewInt64(-1)}}}
+	if _, err := c.Query(ctx, k, s.host.ID(), sel); err == nil {
+		t.Fatal("invalid selector should fail")
+	}
+}
+
+func TestLogSetByWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupClient(ctx, t)
+	// Servers are lenient by default.
+	s := setupServer(ctx, t)
+	connect(ctx, t, c.host, s.host)
+
+	// Writers can't store logs that look like the ones numbered by the server.
+	k := "234"
+	rec := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "chat"}, Value: xr.Predicate{
+			Tag:   "log",
+			Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "last"}, Value: xr.NewInt64(100)}},
+		}},
+	}}
+	err := c.Update(ctx, k, s.host.ID(), rec, ttl)
+	var aerr *ir.AssemblyError
+	if !errors.As(err, &aerr) || aerr.Path != ".chat" {
+		t.Fatal("log set by a writer should fail to assemble", err)
+	}
+	if out := s.GetLocal(k); out[c.host.ID()] != nil {
+		t.Fatal("log set by a writer was stored", out)
+	}
+}
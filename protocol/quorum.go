@@ -0,0 +1,181 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// QuorumClient sends smart-record requests to a set of servers, requiring
+// a quorum of them to succeed.
+type QuorumClient interface {
+	// Get gets the record of a key from R servers and merges their responses.
+	Get(ctx context.Context, k string) (*vm.RecordValue, error)
+	// Update updates the record of a key, succeeding after W servers acknowledge it.
+	Update(ctx context.Context, k string, rec xr.Dict, ttl time.Duration) error
+}
+
+// QuorumError reports the servers that failed when a quorum was not reached.
+type QuorumError struct {
+	Required  int               // Number of servers required to succeed.
+	Succeeded int               // Number of servers that succeeded.
+	Errors    map[peer.ID]error // Error of each server that failed.
+}
+
+func (e *QuorumError) Error() string {
+	ps := make([]peer.ID, 0, len(e.Errors))
+	for p := range e.Errors {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+	errs := make([]string, len(ps))
+	for i, p := range ps {
+		errs[i] = fmt.Sprintf("%s: %s", p, e.Errors[p])
+	}
+	return fmt.Sprintf("quorum not reached (%d of %d servers required succeeded): %s",
+		e.Succeeded, e.Required, strings.Join(errs, "; "))
+}
+
+// quorumClient implements QuorumClient over a smartRecordClient.
+type quorumClient struct {
+	client  *smartRecordClient
+	servers []peer.ID
+	write   int
+	read    int
+	timeout time.Duration
+}
+
+// NewQuorumClient starts a QuorumClient sending requests to the servers specified.
+func NewQuorumClient(ctx context.Context, h host.Host, servers []peer.ID, options ...QuorumOption) (QuorumClient, error) {
+	return newQuorumClient(ctx, h, servers, options...)
+}
+
+func newQuorumClient(ctx context.Context, h host.Host, servers []peer.ID, options ...QuorumOption) (*quorumClient, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("at least one server must be provided")
+	}
+	var cfg quorumConfig
+	if err := cfg.apply(append([]QuorumOption{quorumDefaults(len(servers))}, options...)...); err != nil {
+		return nil, err
+	}
+	if cfg.write > len(servers) || cfg.read > len(servers) {
+		return nil, fmt.Errorf("quorum larger than the number of servers")
+	}
+	c, err := newSmartRecordClient(ctx, h, cfg.clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &quorumClient{
+		client:  c,
+		servers: servers,
+		write:   cfg.write,
+		read:    cfg.read,
+		timeout: cfg.timeout,
+	}, nil
+}
+
+// quorumResult is the result of a request to a server.
+type quorumResult struct {
+	p    peer.ID
+	rv   vm.RecordValue
+	exps map[peer.ID]writerExpiration
+	err  error
+}
+
+// do sends a request to every server in parallel, each of them with its own
+// timeout, and returns as soon as n of them succeed or the quorum can't be
+// reached anymore. If cancelPending is set, the requests still running are
+// cancelled; otherwise they run until they finish or their timeout expires,
+// so writes still reach every server once the quorum is reached.
+func (q *quorumClient) do(ctx context.Context, n int, cancelPending bool, req func(context.Context, peer.ID) quorumResult) ([]quorumResult, error) {
+	if cancelPending {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+	}
+	results := make(chan quorumResult, len(q.servers))
+	for _, p := range q.servers {
+		go func(p peer.ID) {
+			ctx, cancel := context.WithTimeout(ctx, q.timeout)
+			defer cancel()
+			results <- req(ctx, p)
+		}(p)
+	}
+
+	ok := []quorumResult{}
+	errs := make(map[peer.ID]error)
+	for range q.servers {
+		r := <-results
+		if r.err != nil {
+			errs[r.p] = r.err
+		} else {
+			ok = append(ok, r)
+		}
+		if len(ok) >= n {
+			return ok, nil
+		}
+		if len(errs) > len(q.servers)-n {
+			break
+		}
+	}
+	return nil, &QuorumError{Required: n, Succeeded: len(ok), Errors: errs}
+}
+
+// Update sends the update to every server and returns after W of them acknowledge it.
+// The update keeps being sent to the rest of the servers in the background.
+func (q *quorumClient) Update(ctx context.Context, k string, rec xr.Dict, ttl time.Duration) error {
+	_, err := q.do(ctx, q.write, false, func(ctx context.Context, p peer.ID) quorumResult {
+		return quorumResult{p: p, err: q.client.Update(ctx, k, p, rec, ttl)}
+	})
+	return err
+}
+
+// Get requests the record to every server and merges the first R responses.
+// The dict of each writer is merged in order of the expiration time reported
+// by each server, so values from the servers with later expirations win.
+func (q *quorumClient) Get(ctx context.Context, k string) (*vm.RecordValue, error) {
+	rs, err := q.do(ctx, q.read, true, func(ctx context.Context, p peer.ID) quorumResult {
+		rv, exps, err := q.client.getWithExpirations(ctx, k, p, 0)
+		return quorumResult{p: p, rv: rv, exps: exps, err: err}
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := mergeLatestRecordValues(rs)
+	return &out, nil
+}
+
+// mergeLatestRecordValues merges the dict of each writer in the results,
+// giving precedence to the results with the latest expiration for the writer.
+func mergeLatestRecordValues(rs []quorumResult) vm.RecordValue {
+	out := vm.RecordValue{}
+	writers := make(map[peer.ID]bool)
+	for _, r := range rs {
+		for w := range r.rv {
+			writers[w] = true
+		}
+	}
+	for w := range writers {
+		ordered := make([]quorumResult, 0, len(rs))
+		for _, r := range rs {
+			if r.rv[w] != nil {
+				ordered = append(ordered, r)
+			}
+		}
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].exps[w].latest > ordered[j].exps[w].latest
+		})
+		for _, r := range ordered {
+			mergeRecordValue(out, vm.RecordValue{w: r.rv[w]})
+		}
+	}
+	return out
+}
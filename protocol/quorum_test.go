@@ -0,0 +1,178 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	xr "github.com/libp2p/go-routing-language/syntax"
+)
+
+// setupQuorum starts n servers and a host without a smart record server,
+// and returns a quorum client for all of them.
+func setupQuorum(ctx context.Context, t *testing.T, n int, opts ...QuorumOption) (*quorumClient, []*smartRecordServer, peer.ID) {
+	mn, err := mocknet.FullMeshConnected(ctx, n+2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	servers := make([]*smartRecordServer, n)
+	ps := make([]peer.ID, 0, n+1)
+	for i := range servers {
+		s, err := newSmartRecordServer(ctx, hosts[i], ServerProtocolPrefix(prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers[i] = s
+		ps = append(ps, hosts[i].ID())
+	}
+	down := hosts[n].ID()
+	ps = append(ps, down)
+	opts = append([]QuorumOption{QuorumClientOptions(ClientProtocolPrefix(prefix))}, opts...)
+	q, err := newQuorumClient(ctx, hosts[n+1], ps, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q, servers, down
+}
+
+func TestQuorumUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Majority of 4 servers with one down.
+	q, _, _ := setupQuorum(ctx, t, 3)
+	if err := q.Update(ctx, "234", in1, ttl); err != nil {
+		t.Fatal(err)
+	}
+
+	q, _, down := setupQuorum(ctx, t, 3, WriteQuorum(4))
+	err := q.Update(ctx, "234", in1, ttl)
+	var qerr *QuorumError
+	if !errors.As(err, &qerr) {
+		t.Fatal("expected a quorum error", err)
+	}
+	if qerr.Required != 4 || len(qerr.Errors) != 1 || qerr.Errors[down] == nil {
+		t.Fatal("wrong servers reported in quorum error", qerr)
+	}
+}
+
+func TestQuorumGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q, servers, _ := setupQuorum(ctx, t, 3, ReadQuorum(3))
+
+	k := "234"
+	writer := q.client.self
+	newer := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "key"}, Value: xr.String{Value: "newer"}},
+	}}
+	// The server with the latest expiration has a different value.
+	if err := servers[0].UpdateLocal(k, writer, in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := servers[1].UpdateLocal(k, writer, in2, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := servers[2].UpdateLocal(k, writer, newer, 100*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := q.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := in.Copy()
+	expected.Pairs[0] = newer.Pairs[0]
+	if !xr.IsEqual(expected, *(*out)[writer]) {
+		t.Fatal("responses not merged successfully", *(*out)[writer])
+	}
+
+	// The read quorum can't be reached if another server is down.
+	q.read = 4
+	if _, err := q.Get(ctx, k); err == nil {
+		t.Fatal("get should fail if the read quorum is not reached")
+	}
+}
+
+func TestQuorumOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mn.Hosts()[0]
+	if _, err := newQuorumClient(ctx, h, nil); err == nil {
+		t.Fatal("quorum client shouldn't start without servers")
+	}
+	if _, err := newQuorumClient(ctx, h, []peer.ID{h.ID()}, WriteQuorum(2)); err == nil {
+		t.Fatal("quorum client shouldn't start with a quorum larger than the servers")
+	}
+}
+
+func TestQuorumCancelsPending(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q, _, down := setupQuorum(ctx, t, 2, ReadQuorum(2), ServerTimeout(time.Second))
+
+	for _, tc := range []struct {
+		name          string
+		cancelPending bool
+	}{
+		{name: "reads are cancelled", cancelPending: true},
+		{name: "writes run until their timeout", cancelPending: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The request to the last server only returns when it is cancelled
+			// or times out.
+			done := make(chan error, 1)
+			_, err := q.do(ctx, 2, tc.cancelPending, func(ctx context.Context, p peer.ID) quorumResult {
+				if p == down {
+					<-ctx.Done()
+					done <- ctx.Err()
+					return quorumResult{p: p, err: ctx.Err()}
+				}
+				return quorumResult{p: p}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case err := <-done:
+				if tc.cancelPending && err != context.Canceled {
+					t.Fatal("pending request not cancelled once the quorum was reached", err)
+				}
+				if !tc.cancelPending && err != context.DeadlineExceeded {
+					t.Fatal("pending write cancelled once the quorum was reached", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("pending request never finished")
+			}
+		})
+	}
+}
+
+func TestQuorumUpdateReachesAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q, servers, _ := setupQuorum(ctx, t, 3, WriteQuorum(1))
+
+	k := "234"
+	if err := q.Update(ctx, k, in1, ttl); err != nil {
+		t.Fatal(err)
+	}
+	// The writes to the servers that didn't count for the quorum still finish.
+	deadline := time.Now().Add(5 * time.Second)
+	for _, s := range servers {
+		for s.GetLocal(k)[q.client.self] == nil {
+			if time.Now().After(deadline) {
+				t.Fatal("update not stored in every server")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
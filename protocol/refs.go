@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	"github.com/libp2p/go-smart-record/ir/base"
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// refTarget is the dict of a writer in a key referenced by a ref smart tag.
+type refTarget struct {
+	key    string
+	writer peer.ID
+}
+
+// maxResolvedRefs is the max number of references resolved in a record.
+const maxResolvedRefs = 64
+
+// refResolver resolves the references of a record.
+type refResolver struct {
+	get func(k string) vm.RecordValue
+	// Dicts already in the record, which are not inlined again.
+	inlined map[refTarget]bool
+	// Number of references that can still be resolved.
+	left int
+}
+
+// resolveRefs resolves the ref smart tags of the record stored in a key up to
+// a depth, adding the dict referenced as the value of each reference (see
+// base.Ref). References to dicts that don't exist, or that would close a
+// cycle, are reported in the error of the reference instead. Each dict is
+// inlined once in the record, and at most maxResolvedRefs references are
+// resolved, so later references to the same dict, or past the limit, are
+// left unresolved with an error too.
+func resolveRefs(get func(k string) vm.RecordValue, k string, rv vm.RecordValue, depth int) vm.RecordValue {
+	r := &refResolver{get: get, inlined: make(map[refTarget]bool), left: maxResolvedRefs}
+	writers := make([]peer.ID, 0, len(rv))
+	for writer := range rv {
+		writers = append(writers, writer)
+		r.inlined[refTarget{key: k, writer: writer}] = true
+	}
+	// Resolve the dicts of the writers in order, so the
+	// same references are resolved on every get.
+	sort.Slice(writers, func(i, j int) bool { return writers[i] < writers[j] })
+	out := make(vm.RecordValue, len(rv))
+	for _, writer := range writers {
+		rd := r.resolveNode(*rv[writer], depth, []refTarget{{key: k, writer: writer}}).(xr.Dict)
+		out[writer] = &rd
+	}
+	return out
+}
+
+// resolveNode resolves the references in a node. path holds the dicts
+// being resolved, from the root of the record.
+func (r *refResolver) resolveNode(n xr.Node, depth int, path []refTarget) xr.Node {
+	if depth <= 0 {
+		return n
+	}
+	return mapPredicates(n, func(p xr.Predicate) xr.Node {
+		if p.Tag != "ref" {
+			return p
+		}
+		ref, err := base.RefAssembler{}.Assemble(ir.AssemblerContext{}, p)
+		if err != nil {
+			return p
+		}
+		t := refTarget{key: ref.(*base.Ref).Key(), writer: ref.(*base.Ref).Writer()}
+		out := ref.Disassemble().(xr.Predicate)
+		for _, pt := range path {
+			if pt == t {
+				return withNamed(out, "error", xr.String{Value: "cycle"})
+			}
+		}
+		if r.inlined[t] {
+			return withNamed(out, "error", xr.String{Value: "already inlined"})
+		}
+		if r.left == 0 {
+			return withNamed(out, "error", xr.String{Value: "limit reached"})
+		}
+		d := r.get(t.key)[t.writer]
+		if d == nil {
+			return withNamed(out, "error", xr.String{Value: "not found"})
+		}
+		r.inlined[t] = true
+		r.left--
+		// Copy the path, so references in sibling nodes don't share it.
+		path := append(path[:len(path):len(path)], t)
+		return withNamed(out, "value", r.resolveNode(*d, depth-1, path))
+	})
+}
+
+func withNamed(p xr.Predicate, name string, v xr.Node) xr.Predicate {
+	p.Named = append(p.Named, xr.Pair{Key: xr.String{Value: name}, Value: v})
+	return p
+}
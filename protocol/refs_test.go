@@ -0,0 +1,192 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	p2ptestutil "github.com/libp2p/go-libp2p-netutil"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+func refTo(k string, writer peer.ID) xr.Predicate {
+	return xr.Predicate{Tag: "ref", Named: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "key"}, Value: xr.String{Value: k}},
+		xr.Pair{Key: xr.String{Value: "writer"}, Value: xr.String{Value: writer.String()}},
+	}}
+}
+
+// named returns the named argument of a predicate, or nil if missing.
+func named(n xr.Node, name string) xr.Node {
+	p, ok := n.(xr.Predicate)
+	if !ok {
+		return nil
+	}
+	for _, a := range p.Named {
+		if xr.IsEqual(a.Key, xr.String{Value: name}) {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+func TestResolveRefs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := setupClient(ctx, t)
+	s := setupServer(ctx, t, ServerMaxRefDepth(2))
+	connect(ctx, t, c.host, s.host)
+	w := c.host.ID()
+
+	// a references b, which references a back.
+	b := xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "v"}, Value: xr.String{Value: "b"}}}}
+	if err := c.Update(ctx, "b", s.host.ID(), b, ttl); err != nil {
+		t.Fatal(err)
+	}
+	a := xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "next"}, Value: refTo("b", w)}}}
+	if err := c.Update(ctx, "a", s.host.ID(), a, ttl); err != nil {
+		t.Fatal(err)
+	}
+	back := xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "next"}, Value: refTo("a", w)}}}
+	if err := c.Update(ctx, "b", s.host.ID(), back, ttl); err != nil {
+		t.Fatal(err)
+	}
+
+	// References are not resolved by default.
+	out, err := c.Get(ctx, "a", s.host.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !xr.IsEqual(*(*out)[w], a) {
+		t.Fatal("references resolved by default", *(*out)[w])
+	}
+
+	out, err = c.Get(ctx, "a", s.host.ID(), ResolveRefs(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := named((*out)[w].Get(xr.String{Value: "next"}), "value")
+	if d, ok := next.(xr.Dict); !ok || !xr.IsEqual(d.Get(xr.String{Value: "v"}), xr.String{Value: "b"}) {
+		t.Fatal("reference not resolved", *(*out)[w])
+	}
+	if e := named(next.(xr.Dict).Get(xr.String{Value: "next"}), "error"); !xr.IsEqual(e, xr.String{Value: "cycle"}) {
+		t.Fatal("cycle not reported", next)
+	}
+
+	// Missing records are reported, and the depth is limited by the server.
+	other, _ := p2ptestutil.RandTestBogusIdentity()
+	c1 := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "missing"}, Value: refTo("c", other.ID())},
+		xr.Pair{Key: xr.String{Value: "next"}, Value: refTo("c2", w)},
+	}}
+	c2 := xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "next"}, Value: refTo("b", w)}}}
+	if err := s.vm.Merge(ctx, w, "c1", c1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.vm.Merge(ctx, w, "c2", c2); err != nil {
+		t.Fatal(err)
+	}
+	out, err = c.Get(ctx, "c1", s.host.ID(), ResolveRefs(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := named((*out)[w].Get(xr.String{Value: "missing"}), "error"); !xr.IsEqual(e, xr.String{Value: "not found"}) {
+		t.Fatal("missing record not reported", *(*out)[w])
+	}
+	// c1 -> c2 -> b is resolved, but b -> a is beyond the max depth.
+	next = named((*out)[w].Get(xr.String{Value: "next"}), "value")
+	if d, ok := next.(xr.Dict); ok {
+		next = named(d.Get(xr.String{Value: "next"}), "value")
+	}
+	if d, ok := next.(xr.Dict); !ok || !xr.IsEqual(d.Get(xr.String{Value: "next"}), refTo("a", w)) {
+		t.Fatal("depth not limited", next)
+	}
+}
+
+func TestResolveRefsLimits(t *testing.T) {
+	w, _ := p2ptestutil.RandTestBogusIdentity()
+	records := map[string]vm.RecordValue{}
+	get := func(k string) vm.RecordValue { return records[k] }
+	store := func(k string, d xr.Dict) {
+		records[k] = vm.RecordValue{w.ID(): &d}
+	}
+
+	// Each dict is inlined once in the record.
+	store("x", xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "v"}, Value: xr.String{Value: "x"}}}})
+	store("a", xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "first"}, Value: refTo("x", w.ID())},
+		xr.Pair{Key: xr.String{Value: "second"}, Value: refTo("x", w.ID())},
+	}})
+	out := resolveRefs(get, "a", get("a"), 5)[w.ID()]
+	if named(out.Get(xr.String{Value: "first"}), "value") == nil {
+		t.Fatal("reference not resolved", *out)
+	}
+	second := out.Get(xr.String{Value: "second"})
+	if named(second, "value") != nil || !xr.IsEqual(named(second, "error"), xr.String{Value: "already inlined"}) {
+		t.Fatal("dict inlined twice", *out)
+	}
+
+	// The number of references resolved is limited.
+	many := xr.Dict{}
+	for i := 0; i < maxResolvedRefs+1; i++ {
+		k := fmt.Sprintf("k%d", i)
+		store(k, xr.Dict{})
+		many.Pairs = append(many.Pairs, xr.Pair{Key: xr.String{Value: k}, Value: refTo(k, w.ID())})
+	}
+	store("many", many)
+	out = resolveRefs(get, "many", get("many"), 5)[w.ID()]
+	resolved := 0
+	for _, p := range out.Pairs {
+		if named(p.Value, "value") != nil {
+			resolved++
+		} else if !xr.IsEqual(named(p.Value, "error"), xr.String{Value: "limit reached"}) {
+			t.Fatal("reference past the limit without error", p.Value)
+		}
+	}
+	if resolved != maxResolvedRefs {
+		t.Fatal("wrong number of references resolved", resolved)
+	}
+}
+
+func TestResolveRefsMessageSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, _ := p2ptestutil.RandTestBogusIdentity()
+
+	// Servers don't resolve references unless configured to.
+	s := setupServer(ctx, t)
+	a := xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "next"}, Value: refTo("b", w.ID())}}}
+	b := xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "v"}, Value: xr.String{Value: strings.Repeat("b", 4000)}}}}
+	for _, s := range []*smartRecordServer{s, setupServer(ctx, t, ServerMaxRefDepth(2), ServerMaxMessageSize(2000))} {
+		if err := s.vm.Merge(ctx, w.ID(), "b", b); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.vm.Merge(ctx, w.ID(), "a", a); err != nil {
+			t.Fatal(err)
+		}
+		// References that don't fit in a message are left unresolved.
+		rb, _, _, err := s.getRecord(vm.CodecJSON, "a", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := vm.UnmarshalRecordValueWith(vm.CodecJSON, rb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !xr.IsEqual(*out[w.ID()], a) {
+			t.Fatal("references resolved", *out[w.ID()])
+		}
+	}
+	// Records that don't fit in a message fail.
+	s = setupServer(ctx, t, ServerMaxMessageSize(2000))
+	if err := s.vm.Merge(ctx, w.ID(), "b", b); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := s.getRecord(vm.CodecJSON, "b", 0); err == nil {
+		t.Fatal("record larger than a message should fail")
+	}
+}
@@ -0,0 +1,239 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jbenet/goprocess"
+	goprocessctx "github.com/jbenet/goprocess/context"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir/base"
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// replicationQueueSize is the number of updates waiting to be forwarded
+// to each replica. Updates are dropped when the queue of a replica is full,
+// and the replica receives them in the next reconciliation.
+const replicationQueueSize = 256
+
+// maxReplicaClockSkew is the difference tolerated between the clocks of
+// replicas when checking that a replicated update expires within its TTL.
+const maxReplicaClockSkew = time.Minute
+
+// replication is a fragment of the dict of a writer that needs to be
+// forwarded to replicas, along with the update of the writer that
+// produced it, and its signature if it was signed.
+type replication struct {
+	key        string
+	writer     peer.ID
+	value      xr.Dict
+	expiration uint64
+	seq        uint64 // Last number of the sequence of the key.
+
+	update    xr.Dict
+	ttl       uint64
+	signature []byte // Nil if the update was not signed.
+}
+
+// inUpdate returns true if a key of the dict of a writer was written by an
+// update, including the numbers assigned by the server to its seq() keys.
+func inUpdate(update xr.Dict, k xr.Node) bool {
+	if update.Get(k) != nil {
+		return true
+	}
+	if _, ok := k.(xr.Int); !ok {
+		return false
+	}
+	for _, p := range update.Pairs {
+		if pr, ok := p.Key.(xr.Predicate); ok && pr.Tag == base.SeqTag.Tags[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// replicator forwards the updates accepted by a server to its replicas,
+// and periodically pushes its full state to them so replicas that missed
+// updates converge to the same state. Each replica is served by its own
+// worker, so a slow replica doesn't delay the rest.
+type replicator struct {
+	ctx      context.Context
+	proc     goprocess.Process
+	vm       vm.Machine
+	replicas []peer.ID
+	period   time.Duration
+	queues   map[peer.ID]chan *replication // Queue of updates of each replica.
+	timeout  time.Duration
+
+	wire          map[protocol.ID]wireProtocol
+	senderManager *messageSenderImpl
+}
+
+func newReplicator(ctx context.Context, h host.Host, v vm.Machine, cfg *serverConfig) (*replicator, error) {
+	// Only versions that support replication are used between replicas.
+	sender, wire, err := newPeerSender(h, cfg, pb.Message_REPLICATE)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &replicator{
+		ctx:           ctx,
+		vm:            v,
+		replicas:      cfg.replicas,
+		period:        cfg.replicationPeriod,
+		queues:        make(map[peer.ID]chan *replication, len(cfg.replicas)),
+		timeout:       cfg.readTimeout,
+		wire:          wire,
+		senderManager: sender,
+	}
+	r.proc = goprocessctx.WithContext(ctx)
+	for _, p := range r.replicas {
+		q := make(chan *replication, replicationQueueSize)
+		r.queues[p] = q
+		r.proc.Go(r.worker(p, q))
+	}
+	return r, nil
+}
+
+// newPeerSender returns a message sender to send requests of type t to other
+// servers, using the versions served that support it, and the wireProtocol
+// negotiated with each of its protocol IDs.
+func newPeerSender(h host.Host, cfg *serverConfig, t pb.Message_MessageType) (*messageSenderImpl, map[protocol.ID]wireProtocol, error) {
+	versions := []Version{}
+	for _, ver := range cfg.versions {
+		if protocolVersions[ver].msgTypes[t] {
+			versions = append(versions, ver)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, nil, fmt.Errorf("%s is not supported by any of the versions served", t)
+	}
+	protocols, wire := wireProtocols(cfg.protocolPrefix, versions, cfg.codecs)
+	return &messageSenderImpl{
+		host:      h,
+		strmap:    make(map[peer.ID]*peerMessageSender),
+		protocols: protocols,
+
+		readTimeout:    cfg.readTimeout,
+		maxMessageSize: cfg.maxMessageSize,
+	}, wire, nil
+}
+
+// isReplica returns true if the peer is one of the replicas of the server.
+func (r *replicator) isReplica(p peer.ID) bool {
+	for _, rp := range r.replicas {
+		if rp == p {
+			return true
+		}
+	}
+	return false
+}
+
+// forward queues an update to be sent to the replicas.
+func (r *replicator) forward(u *replication) {
+	for p, q := range r.queues {
+		select {
+		case q <- u:
+		default:
+			log.Warnw("replication queue full, update left for reconciliation", "key", u.key, "to", p)
+		}
+	}
+}
+
+// worker sends the updates queued for a replica, and reconciles
+// with it periodically.
+func (r *replicator) worker(p peer.ID, q chan *replication) goprocess.ProcessFunc {
+	return func(proc goprocess.Process) {
+		ticker := time.NewTicker(r.period)
+		defer ticker.Stop()
+		for {
+			select {
+			case u := <-q:
+				if err := r.send(p, u); err != nil {
+					log.Debugw("failed forwarding update to replica", "error", err, "to", p, "key", u.key)
+				}
+			case <-ticker.C:
+				r.reconcile(proc, p)
+			case <-proc.Closing():
+				return
+			}
+		}
+	}
+}
+
+// reconcile sends the full state of the server to a replica. The state
+// of each writer is sent as fragments with the same expiration time, which
+// the replica merges keeping the latest values.
+func (r *replicator) reconcile(proc goprocess.Process, p peer.ID) {
+	now := uint64(time.Now().Unix())
+	for _, k := range r.vm.Keys() {
+		for w, frags := range r.vm.Snapshot(k) {
+			for _, f := range frags {
+				// Skip fragments that will be garbage collected.
+				if f.Expiration < now {
+					continue
+				}
+				select {
+				case <-proc.Closing():
+					return
+				default:
+				}
+				u := &replication{key: k, writer: w, value: f.Dict, expiration: f.Expiration, seq: r.vm.Seq(k)}
+				if err := r.send(p, u); err != nil {
+					log.Debugw("failed reconciling with replica", "error", err, "to", p, "key", k)
+				}
+			}
+		}
+	}
+}
+
+// send sends a REPLICATE request with an update to a replica.
+func (r *replicator) send(p peer.ID, u *replication) error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+	proto, err := r.senderManager.ProtocolForPeer(ctx, p)
+	if err != nil {
+		return err
+	}
+	w, ok := r.wire[proto]
+	if !ok {
+		return fmt.Errorf("unknown protocol negotiated %s", proto)
+	}
+	vb, err := vm.MarshalNode(w.codec, u.value)
+	if err != nil {
+		return err
+	}
+	req := &pb.Message{
+		Type:       pb.Message_REPLICATE,
+		Key:        []byte(u.key),
+		Value:      vb,
+		Writer:     []byte(u.writer),
+		Expiration: u.expiration,
+		Seq:        u.seq,
+	}
+	if u.signature != nil {
+		if req.SignedValue, err = vm.MarshalNode(w.codec, u.update); err != nil {
+			return err
+		}
+		req.TTL, req.Signature = u.ttl, u.signature
+	}
+	resp, err := r.senderManager.SendRequest(ctx, p, req)
+	if err != nil {
+		return err
+	}
+	if resp.GetError() != "" {
+		return fmt.Errorf("request failed in replica: %s", resp.GetError())
+	}
+	return nil
+}
+
+// Close stops the replication process.
+func (r *replicator) Close() error {
+	return r.proc.Close()
+}
@@ -0,0 +1,427 @@
+package protocol
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	xr "github.com/libp2p/go-routing-language/syntax"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/libp2p/go-smart-record/ir"
+	"github.com/libp2p/go-smart-record/ir/base"
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// ed25519Mocknet creates a mocknet whose peers use ed25519 keys, so
+// their public keys can be extracted from their peer IDs.
+func ed25519Mocknet(ctx context.Context, t *testing.T, n int) []host.Host {
+	mn := mocknet.New(ctx)
+	for i := 0; i < n; i++ {
+		sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", 4000+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mn.AddPeer(sk, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+	return mn.Hosts()
+}
+
+// replicaOpts returns the options to start a server in host i
+// replicating with the rest of hosts.
+func replicaOpts(hosts []host.Host, i int, period time.Duration) []ServerOption {
+	replicas := []peer.ID{}
+	for j, h := range hosts {
+		if j != i {
+			replicas = append(replicas, h.ID())
+		}
+	}
+	return []ServerOption{
+		ServerProtocolPrefix(prefix),
+		VMGcPeriod(gcPeriod),
+		Replicas(replicas...),
+		ReplicationPeriod(period),
+	}
+}
+
+// checkConverged waits until every server stores the record expected
+// by the writer in a key.
+func checkConverged(t *testing.T, servers []*smartRecordServer, k string, writer peer.ID, expected xr.Dict) {
+	deadline := time.Now().Add(5 * time.Second)
+	for _, s := range servers {
+		for {
+			out := s.GetLocal(k)
+			if out[writer] != nil && xr.IsEqual(expected, *out[writer]) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("server %s didn't converge: %v", s.host.ID(), out)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestReplicationForward(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Updates are signed by the client and verified by the server that receives them.
+	hosts := ed25519Mocknet(ctx, t, 4)
+	servers := make([]*smartRecordServer, 3)
+	for i := range servers {
+		// Large period so only forwarded updates are tested.
+		s, err := newSmartRecordServer(ctx, hosts[i], replicaOpts(hosts[:3], i, time.Hour)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers[i] = s
+	}
+	c, err := newSmartRecordClient(ctx, hosts[3], ClientProtocolPrefix(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := "234"
+	if err := c.Update(ctx, k, hosts[0].ID(), in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(ctx, k, hosts[1].ID(), in2, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkConverged(t, servers, k, c.host.ID(), in)
+
+	// Replicas keep the expiration time of the original update.
+	exp := servers[0].vm.Snapshot(k)[c.host.ID()]
+	for _, s := range servers[1:] {
+		if got := s.vm.Snapshot(k)[c.host.ID()]; len(got) != len(exp) || got[0].Expiration != exp[0].Expiration {
+			t.Fatalf("expiration not replicated: expected %v, got %v", exp, got)
+		}
+	}
+}
+
+func TestReplicationResolved(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	servers := make([]*smartRecordServer, 2)
+	for i := range servers {
+		s, err := newSmartRecordServer(ctx, hosts[i], replicaOpts(hosts[:2], i, time.Hour)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers[i] = s
+	}
+	writer := hosts[2].ID()
+
+	// Replicas store the values resolved by the server that accepted the
+	// update instead of resolving them again.
+	k := "234"
+	update := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "msgs"}, Value: xr.Dict{Pairs: xr.Pairs{
+			xr.Pair{Key: xr.Predicate{Tag: "seq"}, Value: xr.String{Value: "hi"}},
+		}}},
+		xr.Pair{Key: xr.String{Value: "at"}, Value: xr.Predicate{Tag: "now"}},
+		xr.Pair{Key: xr.String{Value: "log"}, Value: xr.Predicate{Tag: "appendLog", Positional: xr.Nodes{xr.String{Value: "a"}}}},
+	}}
+	for i := 0; i < 2; i++ {
+		if err := servers[0].UpdateLocal(k, writer, update, 10*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := *servers[0].GetLocal(k)[writer]
+	if msgs := expected.Get(xr.String{Value: "msgs"}).(xr.Dict); len(msgs.Pairs) != 2 {
+		t.Fatal("wrong sequence numbers", msgs)
+	}
+	checkConverged(t, servers[1:], k, writer, expected)
+	// The sequence of the key is replicated along with the numbers,
+	// and replicas reject sequences out of range.
+	if seq := servers[1].vm.Seq(k); seq != 2 {
+		t.Fatal("sequence not replicated", seq)
+	}
+	vb, err := vm.MarshalNode(vm.CodecCBOR, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &pb.Message{
+		Type:       pb.Message_REPLICATE,
+		Key:        []byte(k),
+		Value:      vb,
+		Writer:     []byte(writer),
+		Expiration: uint64(time.Now().Unix()) + 10,
+		Seq:        math.MaxUint64,
+	}
+	if _, err := servers[1].handleReplicate(ctx, hosts[0].ID(), vm.CodecCBOR, msg); err == nil {
+		t.Fatal("replicated sequence out of range should fail")
+	}
+	// Logs are replicated with the entries numbered by the server.
+	l, err := base.LogAssembler{}.Assemble(ir.AssemblerContext{Grammar: ir.SyntacticGrammar, Merging: true}, expected.Get(xr.String{Value: "log"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seqs, _ := l.(*base.Log).Entries(); len(seqs) != 2 || seqs[1] != 2 {
+		t.Fatal("wrong log entries replicated", seqs)
+	}
+
+	// The replica assigns the numbers following the ones replicated,
+	// and replicates them back.
+	update.Pairs = update.Pairs[:1]
+	if err := servers[1].UpdateLocal(k, writer, update, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expected = *servers[1].GetLocal(k)[writer]
+	msgs := expected.Get(xr.String{Value: "msgs"}).(xr.Dict)
+	if len(msgs.Pairs) != 3 || !xr.IsEqual(msgs.Pairs[2].Key, xr.NewInt64(3)) {
+		t.Fatal("sequence not continued in replica", msgs)
+	}
+	checkConverged(t, servers[:1], k, writer, expected)
+}
+
+func TestReplicationSigned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := ed25519Mocknet(ctx, t, 3)
+	servers := make([]*smartRecordServer, 2)
+	for i := range servers {
+		s, err := newSmartRecordServer(ctx, hosts[i], replicaOpts(hosts[:2], i, time.Hour)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers[i] = s
+	}
+	c, err := newSmartRecordClient(ctx, hosts[2], ClientProtocolPrefix(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := hosts[2]
+
+	// Signed updates are forwarded with their signature and verified by replicas.
+	k, ttl := "234", uint64(10)
+	if err := c.Update(ctx, k, hosts[0].ID(), in1, time.Duration(ttl)*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkConverged(t, servers[1:], k, writer.ID(), in1)
+
+	// Replications sent to the replica directly are checked the same way.
+	k = "235"
+	sig, err := signUpdate(writer.Peerstore().PrivKey(writer.ID()), k, in1, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := uint64(time.Now().Unix()) + ttl
+	extra := xr.Dict{Pairs: append(xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "other"}, Value: xr.String{Value: "x"}},
+	}, in1.Pairs...)}
+	for _, tc := range []struct {
+		name string
+		u    replication
+	}{
+		{"tampered update", replication{value: in2, expiration: exp, update: in2, ttl: ttl, signature: sig}},
+		{"tampered ttl", replication{value: in1, expiration: exp, update: in1, ttl: ttl + 1, signature: sig}},
+		{"key not in the update", replication{value: extra, expiration: exp, update: in1, ttl: ttl, signature: sig}},
+		{"expiration beyond the ttl", replication{value: in1, expiration: exp + 3600, update: in1, ttl: ttl, signature: sig}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u := tc.u
+			u.key, u.writer = k, writer.ID()
+			if err := servers[0].replicator.send(hosts[1].ID(), &u); err == nil {
+				t.Fatal("tampered replication should fail")
+			}
+			if out := servers[1].GetLocal(k); out[writer.ID()] != nil {
+				t.Fatal("tampered replication was applied", out)
+			}
+		})
+	}
+	valid := replication{key: k, writer: writer.ID(), value: in1, expiration: exp, update: in1, ttl: ttl, signature: sig}
+	if err := servers[0].replicator.send(hosts[1].ID(), &valid); err != nil {
+		t.Fatal("valid signed replication rejected", err)
+	}
+}
+
+func TestReplicationReconcile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	writer := hosts[2].ID()
+
+	// The first server accepts updates before its replicas are up,
+	// so forwarding them fails.
+	s0, err := newSmartRecordServer(ctx, hosts[0], replicaOpts(hosts, 0, 500*time.Millisecond)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := "234"
+	if err := s0.UpdateLocal(k, writer, in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	servers := []*smartRecordServer{s0}
+	for i := 1; i < 3; i++ {
+		s, err := newSmartRecordServer(ctx, hosts[i], replicaOpts(hosts, i, 500*time.Millisecond)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, s)
+	}
+	// The last server receives a different update of the same writer.
+	if err := servers[2].UpdateLocal(k, writer, in2, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkConverged(t, servers, k, writer, in)
+}
+
+func TestReplicationSlowReplica(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	s0, err := newSmartRecordServer(ctx, hosts[0], append(replicaOpts(hosts, 0, time.Hour), ServerReadTimeout(5*time.Second))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, err := newSmartRecordServer(ctx, hosts[1], replicaOpts(hosts[:2], 1, time.Hour)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last replica never responds.
+	for _, p := range s0.protocols {
+		hosts[2].SetStreamHandler(p, func(network.Stream) { <-ctx.Done() })
+	}
+
+	// Updates reach the other replica without waiting for the slow one.
+	writer := hosts[2].ID()
+	ks := []string{"k1", "k2", "k3"}
+	for _, k := range ks {
+		if err := s0.UpdateLocal(k, writer, in1, 10*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for _, k := range ks {
+		for {
+			if out := s1.GetLocal(k); out[writer] != nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("update of %s delayed by a slow replica", k)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestReplicateOnlyFromReplicas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	s, err := newSmartRecordServer(ctx, hosts[0], replicaOpts(hosts[:2], 0, time.Hour)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vb, err := vm.MarshalNode(vm.CodecJSON, in1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &pb.Message{
+		Type:       pb.Message_REPLICATE,
+		Key:        []byte("234"),
+		Value:      vb,
+		Writer:     []byte(hosts[2].ID()),
+		Expiration: uint64(time.Now().Unix()) + 10,
+	}
+	if _, err := s.handleReplicate(ctx, hosts[2].ID(), vm.CodecJSON, msg); err == nil {
+		t.Fatal("replicate request from a peer that is not a replica should fail")
+	}
+	if _, err := s.handleReplicate(ctx, hosts[1].ID(), vm.CodecJSON, msg); err != nil {
+		t.Fatal(err)
+	}
+	if out := s.GetLocal("234"); out[hosts[2].ID()] == nil || !xr.IsEqual(in1, *out[hosts[2].ID()]) {
+		t.Fatal("replicated update not applied", out)
+	}
+}
+
+func TestSignedUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := ed25519Mocknet(ctx, t, 3)
+	s, err := newSmartRecordServer(ctx, hosts[0], replicaOpts(hosts[:2], 0, time.Hour)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := hosts[2]
+
+	k, ttl := "234", uint64(10)
+	sig, err := signUpdate(writer.Peerstore().PrivKey(writer.ID()), k, in1, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vb, err := vm.MarshalNode(vm.CodecCBOR, in1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.updateRecord(ctx, writer.ID(), vm.CodecCBOR, k, vb, ttl, sig); err != nil {
+		t.Fatal("valid signature rejected", err)
+	}
+
+	// A forwarded update whose signature doesn't match is rejected.
+	vb, err = vm.MarshalNode(vm.CodecCBOR, in2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.updateRecord(ctx, writer.ID(), vm.CodecCBOR, k, vb, ttl, sig); err == nil {
+		t.Fatal("update with wrong signature should fail")
+	}
+	msg := &pb.Message{
+		Type:        pb.Message_REPLICATE,
+		Key:         []byte(k),
+		Value:       vb,
+		SignedValue: vb,
+		TTL:         ttl,
+		Writer:      []byte(writer.ID()),
+		Signature:   sig,
+		Expiration:  uint64(time.Now().Unix()) + ttl,
+	}
+	if _, err := s.handleReplicate(ctx, hosts[1].ID(), vm.CodecCBOR, msg); err == nil {
+		t.Fatal("replicated update with wrong signature should fail")
+	}
+	if out := s.GetLocal(k); !xr.IsEqual(in1, *out[writer.ID()]) {
+		t.Fatal("update with wrong signature was applied", out)
+	}
+}
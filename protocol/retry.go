@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	msmux "github.com/multiformats/go-multistream"
+
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+)
+
+// RetryClass is a class of errors that may be retried when sending a request.
+// Updates are only retried if their protocol was rejected, as the server may
+// have applied them before the stream failed.
+type RetryClass int
+
+const (
+	// RetryStreamReset retries requests whose stream was reset or closed by the peer.
+	RetryStreamReset RetryClass = iota
+	// RetryReadTimeout retries requests whose response was not read within the timeout.
+	RetryReadTimeout
+	// RetryProtocolRejected retries requests to peers that don't support any of the protocols.
+	RetryProtocolRejected
+)
+
+func (c RetryClass) String() string {
+	switch c {
+	case RetryStreamReset:
+		return "stream reset"
+	case RetryReadTimeout:
+		return "read timeout"
+	case RetryProtocolRejected:
+		return "protocol rejected"
+	}
+	return "unknown"
+}
+
+// retryPolicy determines how requests are retried. The zero value doesn't retry.
+type retryPolicy struct {
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	classes    map[RetryClass]bool
+}
+
+// classifyError returns the class of the error of a request,
+// and false if the error doesn't belong to any class.
+func classifyError(err error) (RetryClass, bool) {
+	switch {
+	case errors.Is(err, ErrReadTimeout):
+		return RetryReadTimeout, true
+	case errors.Is(err, mux.ErrReset), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return RetryStreamReset, true
+	case errors.Is(err, msmux.ErrNotSupported):
+		return RetryProtocolRejected, true
+	}
+	return 0, false
+}
+
+// retryable returns true if the error of a request of type t can be retried.
+// Errors of the context are never retried. Requests that are not idempotent
+// are only retried if they were rejected before reaching the server, as the
+// server may have applied them even if the stream failed afterwards.
+func (r *retryPolicy) retryable(t pb.Message_MessageType, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	c, ok := classifyError(err)
+	if !ok || !r.classes[c] {
+		return false
+	}
+	return idempotent(t) || c == RetryProtocolRejected
+}
+
+// idempotent returns true if sending a request of type t several
+// times has the same effect as sending it once.
+func idempotent(t pb.Message_MessageType) bool {
+	switch t {
+	case pb.Message_GET, pb.Message_BATCH_GET, pb.Message_QUERY, pb.Message_SYNC, pb.Message_SYNC_PULL:
+		return true
+	}
+	return false
+}
+
+// backoff returns the time to wait before retrying the request for the attempt
+// given, starting at 0. The backoff grows exponentially up to the max backoff,
+// and a random jitter of up to half of it is subtracted so clients that failed
+// at the same time don't retry together.
+func (r *retryPolicy) backoff(attempt int) time.Duration {
+	d := r.minBackoff
+	for i := 0; i < attempt && d < r.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.maxBackoff {
+		d = r.maxBackoff
+	}
+	if half := int64(d / 2); half > 0 {
+		d -= time.Duration(rand.Int63n(half + 1))
+	}
+	return d
+}
@@ -0,0 +1,179 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-msgio"
+	msmux "github.com/multiformats/go-multistream"
+
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+)
+
+// setFlakyHandler makes the server reset the first n streams
+// after reading a request from them. It returns the number of
+// streams opened.
+func setFlakyHandler(s *smartRecordServer, n int32) *int32 {
+	var streams int32
+	s.setProtocolHandler(func(st network.Stream) {
+		if atomic.AddInt32(&streams, 1) <= n {
+			r := msgio.NewVarintReaderSize(st, network.MessageSizeMax)
+			_, _ = r.ReadMsg()
+			_ = st.Reset()
+			return
+		}
+		s.handleNewStream(st)
+	})
+	return &streams
+}
+
+// setSlowHandler makes the server never respond to the requests of
+// the first n streams. It returns the number of streams opened.
+func setSlowHandler(ctx context.Context, s *smartRecordServer, n int32) *int32 {
+	var streams int32
+	s.setProtocolHandler(func(st network.Stream) {
+		if atomic.AddInt32(&streams, 1) <= n {
+			r := msgio.NewVarintReaderSize(st, network.MessageSizeMax)
+			_, _ = r.ReadMsg()
+			<-ctx.Done()
+			return
+		}
+		s.handleNewStream(st)
+	})
+	return &streams
+}
+
+func TestRetryStreamReset(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t)
+	c := setupClient(ctx, t, ClientRetries(2), ClientBackoff(time.Millisecond, 10*time.Millisecond))
+	connect(ctx, t, c.host, s.host)
+
+	k := "234"
+	if err := s.UpdateLocal(k, c.host.ID(), in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	setFlakyHandler(s, 2)
+	if out, err := c.Get(ctx, k, s.host.ID()); err != nil || (*out)[c.host.ID()] == nil {
+		t.Fatal("request not retried", err)
+	}
+
+	// Updates are not retried, as the server may have applied them.
+	// New clients open new streams handled by the flaky handler.
+	streams := setFlakyHandler(s, 1)
+	c = setupClient(ctx, t, ClientRetries(2), ClientBackoff(time.Millisecond, 10*time.Millisecond))
+	connect(ctx, t, c.host, s.host)
+	if err := c.Update(ctx, k, s.host.ID(), in2, 10*time.Second); err == nil {
+		t.Fatal("update should not be retried")
+	}
+	if n := atomic.LoadInt32(streams); n != 1 {
+		t.Fatal("update sent more than once", n)
+	}
+
+	// Requests fail when retries are exhausted.
+	setFlakyHandler(s, 1)
+	c = setupClient(ctx, t)
+	connect(ctx, t, c.host, s.host)
+	if _, err := c.Get(ctx, k, s.host.ID()); err == nil {
+		t.Fatal("get without retries should fail")
+	}
+	if _, err := c.Get(ctx, k, s.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryReadTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t)
+	c := setupClient(ctx, t, ClientRetries(1), ClientBackoff(time.Millisecond, 10*time.Millisecond),
+		ClientReadTimeout(100*time.Millisecond))
+	connect(ctx, t, c.host, s.host)
+
+	k := "234"
+	if err := s.UpdateLocal(k, c.host.ID(), in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	streams := setSlowHandler(ctx, s, 1)
+	if out, err := c.Get(ctx, k, s.host.ID()); err != nil || (*out)[c.host.ID()] == nil {
+		t.Fatal("request not retried after a read timeout", err)
+	}
+	if n := atomic.LoadInt32(streams); n != 2 {
+		t.Fatal("wrong number of attempts", n)
+	}
+
+	streams = setSlowHandler(ctx, s, 1)
+	c = setupClient(ctx, t, ClientRetries(1), ClientBackoff(time.Millisecond, 10*time.Millisecond),
+		ClientReadTimeout(100*time.Millisecond))
+	connect(ctx, t, c.host, s.host)
+	if err := c.Update(ctx, k, s.host.ID(), in2, 10*time.Second); !errors.Is(err, ErrReadTimeout) {
+		t.Fatal("update should fail with a read timeout without retrying", err)
+	}
+	if n := atomic.LoadInt32(streams); n != 1 {
+		t.Fatal("update sent more than once", n)
+	}
+}
+
+func TestRetryClasses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := setupServer(ctx, t)
+	setFlakyHandler(s, 1)
+	c := setupClient(ctx, t, ClientRetries(2), ClientBackoff(time.Millisecond, 10*time.Millisecond),
+		ClientRetryOn(RetryReadTimeout))
+	connect(ctx, t, c.host, s.host)
+
+	// Stream resets are not retried if not configured.
+	if _, err := c.Get(ctx, "234", s.host.ID()); err == nil {
+		t.Fatal("stream reset should not be retried")
+	}
+
+	r := retryPolicy{classes: map[RetryClass]bool{RetryStreamReset: true, RetryReadTimeout: true, RetryProtocolRejected: true}}
+	cases := []struct {
+		t         pb.Message_MessageType
+		err       error
+		retryable bool
+	}{
+		{pb.Message_GET, mux.ErrReset, true},
+		{pb.Message_QUERY, fmt.Errorf("read failed: %w", ErrReadTimeout), true},
+		{pb.Message_GET, context.DeadlineExceeded, false},
+		{pb.Message_GET, fmt.Errorf("request failed in server"), false},
+		// Updates are only retried if they didn't reach the server.
+		{pb.Message_UPDATE, mux.ErrReset, false},
+		{pb.Message_BATCH_UPDATE, fmt.Errorf("read failed: %w", ErrReadTimeout), false},
+		{pb.Message_REPLICATE, mux.ErrReset, false},
+		{pb.Message_UPDATE, msmux.ErrNotSupported, true},
+	}
+	for _, tc := range cases {
+		if r.retryable(tc.t, tc.err) != tc.retryable {
+			t.Fatalf("wrong retry decision for %s with %q", tc.t, tc.err)
+		}
+	}
+	delete(r.classes, RetryProtocolRejected)
+	if r.retryable(pb.Message_GET, msmux.ErrNotSupported) {
+		t.Fatal("classes not configured should not be retried")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	r := retryPolicy{minBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	for attempt := 0; attempt < 10; attempt++ {
+		max := r.minBackoff << uint(attempt)
+		if max > r.maxBackoff {
+			max = r.maxBackoff
+		}
+		if d := r.backoff(attempt); d < max/2 || d > max {
+			t.Fatalf("backoff %s of attempt %d out of range [%s, %s]", d, attempt, max/2, max)
+		}
+	}
+	if _, err := newSmartRecordClient(context.Background(), nil, ClientBackoff(time.Second, time.Millisecond)); err == nil {
+		t.Fatal("invalid backoff range should fail")
+	}
+}
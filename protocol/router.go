@@ -0,0 +1,195 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// ClosestPeersRouting finds the peers closest to a key. It is implemented
+// by the kad-dht IpfsDHT, so a DHT instance can be used to route smart records.
+type ClosestPeersRouting interface {
+	GetClosestPeers(ctx context.Context, key string) ([]peer.ID, error)
+}
+
+// SmartRecordRouter stores smart records in the peers closest to their key,
+// like DHT put/get, without specifying the peer for each request.
+type SmartRecordRouter interface {
+	// Get gets the record of a key from the closest peers and merges their responses.
+	Get(ctx context.Context, k string) (*vm.RecordValue, error)
+	// Update updates the record of a key in the closest peers, succeeding after
+	// enough of them acknowledge it (see RouterMinAcks).
+	Update(ctx context.Context, k string, rec xr.Dict, ttl time.Duration) error
+}
+
+// smartRecordRouter routes the requests of a client to the closest peers.
+type smartRecordRouter struct {
+	client   *smartRecordClient
+	routing  ClosestPeersRouting
+	maxPeers int
+	minAcks  int // Zero if a majority of the closest peers is required.
+}
+
+// NewSmartRecordRouter starts a SmartRecordRouter sending requests with a client
+// to the peers found by routing. The client must be one created by
+// NewSmartRecordClient, as the router uses the expirations its servers report.
+func NewSmartRecordRouter(c SmartRecordClient, r ClosestPeersRouting, options ...RouterOption) (SmartRecordRouter, error) {
+	return newSmartRecordRouter(c, r, options...)
+}
+
+func newSmartRecordRouter(c SmartRecordClient, r ClosestPeersRouting, options ...RouterOption) (*smartRecordRouter, error) {
+	var cfg routerConfig
+	if err := cfg.apply(append([]RouterOption{routerDefaults}, options...)...); err != nil {
+		return nil, err
+	}
+	sc, ok := c.(*smartRecordClient)
+	if !ok {
+		return nil, fmt.Errorf("router requires a client created by NewSmartRecordClient")
+	}
+	return &smartRecordRouter{
+		client:   sc,
+		routing:  r,
+		maxPeers: cfg.maxPeers,
+		minAcks:  cfg.minAcks,
+	}, nil
+}
+
+// closestPeers returns the peers closest to a key, nearest first.
+func (r *smartRecordRouter) closestPeers(ctx context.Context, k string) ([]peer.ID, error) {
+	ps, err := r.routing.GetClosestPeers(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	if len(ps) == 0 {
+		return nil, errors.New("no peers found for key")
+	}
+	if r.maxPeers > 0 && len(ps) > r.maxPeers {
+		ps = ps[:r.maxPeers]
+	}
+	return ps, nil
+}
+
+// Update sends the update to every closest peer concurrently. It succeeds
+// if enough of them stored the record: the number configured, or all of the
+// closest peers if there are fewer, or else a majority of them.
+func (r *smartRecordRouter) Update(ctx context.Context, k string, rec xr.Dict, ttl time.Duration) error {
+	ps, err := r.closestPeers(ctx, k)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(ps))
+	var wg sync.WaitGroup
+	for i, p := range ps {
+		wg.Add(1)
+		go func(i int, p peer.ID) {
+			defer wg.Done()
+			errs[i] = r.client.Update(ctx, k, p, rec, ttl)
+		}(i, p)
+	}
+	wg.Wait()
+
+	required := len(ps)/2 + 1
+	if r.minAcks > 0 {
+		required = r.minAcks
+		if required > len(ps) {
+			required = len(ps)
+		}
+	}
+	acks := 0
+	var lastErr error
+	for i, err := range errs {
+		if err == nil {
+			acks++
+			continue
+		}
+		lastErr = err
+		log.Debugw("update failed in closest peer", "error", err, "to", ps[i], "key", k)
+	}
+	if acks < required {
+		return fmt.Errorf("update stored in %d of the %d closest peers required: %s", acks, required, lastErr)
+	}
+	return nil
+}
+
+// Get requests the record to every closest peer concurrently and merges their
+// responses. The dict of each writer is merged in order of the expiration
+// reported by each peer, so values from the peers with later expirations win,
+// like in QuorumClient. It fails only if no peer responded.
+func (r *smartRecordRouter) Get(ctx context.Context, k string) (*vm.RecordValue, error) {
+	ps, err := r.closestPeers(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]quorumResult, len(ps))
+	var wg sync.WaitGroup
+	for i, p := range ps {
+		wg.Add(1)
+		go func(i int, p peer.ID) {
+			defer wg.Done()
+			rv, exps, err := r.client.getWithExpirations(ctx, k, p, 0)
+			results[i] = quorumResult{p: p, rv: rv, exps: exps, err: err}
+		}(i, p)
+	}
+	wg.Wait()
+
+	ok := make([]quorumResult, 0, len(results))
+	for _, res := range results {
+		if res.err != nil {
+			log.Debugw("get failed in closest peer", "error", res.err, "to", res.p, "key", k)
+			continue
+		}
+		ok = append(ok, res)
+	}
+	if len(ok) == 0 {
+		return nil, fmt.Errorf("get failed in all %d closest peers: %s", len(ps), results[0].err)
+	}
+	out := mergeLatestRecordValues(ok)
+	return &out, nil
+}
+
+// mergeRecordValue merges the dicts of each writer in src into dst.
+// Values already in dst take precedence.
+func mergeRecordValue(dst, src vm.RecordValue) {
+	for w, d := range src {
+		if dst[w] == nil {
+			c := *d
+			dst[w] = &c
+			continue
+		}
+		m, err := mergeDicts(*dst[w], *d)
+		if err != nil {
+			log.Debugw("failed merging dicts", "error", err, "writer", w)
+			continue
+		}
+		dst[w] = &m
+	}
+}
+
+// mergeDicts merges two syntactic dicts with ir.MergeLatest, so they are merged
+// like replicas merge them. Conflicts are resolved in favor of x, which is
+// assembled as expiring after y.
+func mergeDicts(x, y xr.Dict) (xr.Dict, error) {
+	asm := ir.AssemblerContext{Grammar: ir.SyntacticGrammar}
+	nx, err := asm.Assemble(x, meta.Expiration(2))
+	if err != nil {
+		return xr.Dict{}, err
+	}
+	ny, err := asm.Assemble(y, meta.Expiration(1))
+	if err != nil {
+		return xr.Dict{}, err
+	}
+	m, ok := ir.MergeLatest(ir.DefaultUpdateContext{}, nx, ny).Disassemble().(xr.Dict)
+	if !ok {
+		return xr.Dict{}, errors.New("merge didn't generate a dict")
+	}
+	return m, nil
+}
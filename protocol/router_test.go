@@ -0,0 +1,230 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// staticRouting returns always the same closest peers.
+type staticRouting []peer.ID
+
+func (r staticRouting) GetClosestPeers(ctx context.Context, key string) ([]peer.ID, error) {
+	return r, nil
+}
+
+func setupRouter(ctx context.Context, t *testing.T, n int, opts ...RouterOption) (*smartRecordRouter, []*smartRecordServer) {
+	mn, err := mocknet.FullMeshConnected(ctx, n+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	servers := make([]*smartRecordServer, n)
+	ps := make(staticRouting, n)
+	for i := range servers {
+		s, err := newSmartRecordServer(ctx, hosts[i], ServerProtocolPrefix(prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers[i] = s
+		ps[i] = hosts[i].ID()
+	}
+	c, err := newSmartRecordClient(ctx, hosts[n], ClientProtocolPrefix(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newSmartRecordRouter(c, ps, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, servers
+}
+
+func TestRouterUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, servers := setupRouter(ctx, t, 4, RouterMaxPeers(3))
+
+	k := "234"
+	if err := r.Update(ctx, k, in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	writer := r.client.self
+	for i, s := range servers {
+		out := s.GetLocal(k)
+		if i < 3 && (out[writer] == nil || !xr.IsEqual(in1, *out[writer])) {
+			t.Fatalf("record not stored in closest peer %d: %v", i, out)
+		}
+		if i == 3 && out[writer] != nil {
+			t.Fatal("record stored in a peer that is not one of the closest", out)
+		}
+	}
+}
+
+func TestRouterGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, servers := setupRouter(ctx, t, 3)
+
+	k := "234"
+	writer := r.client.self
+	conflict := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "key"}, Value: xr.String{Value: "other"}},
+	}}
+	// Each server has a different part of the record, and the farthest
+	// one has an older conflicting value.
+	for i, rec := range []xr.Dict{in1, in2, conflict} {
+		d := 10 * time.Second
+		if i == 2 {
+			d = 5 * time.Second
+		}
+		if err := servers[i].UpdateLocal(k, writer, rec, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := r.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The older conflicting value is ignored.
+	if !xr.IsEqual(in, *(*out)[writer]) {
+		t.Fatal("responses not merged successfully", *(*out)[writer])
+	}
+}
+
+func TestRouterGetStaleClosest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, servers := setupRouter(ctx, t, 2)
+
+	k := "234"
+	writer := r.client.self
+	stale := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "key"}, Value: xr.String{Value: "stale"}},
+	}}
+	fresh := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "key"}, Value: xr.String{Value: "fresh"}},
+	}}
+	if err := servers[0].UpdateLocal(k, writer, stale, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := servers[1].UpdateLocal(k, writer, fresh, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	out, err := r.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The value with the later expiration wins even if the closest peer is stale.
+	if !xr.IsEqual(fresh, *(*out)[writer]) {
+		t.Fatal("stale value of the closest peer returned", *(*out)[writer])
+	}
+}
+
+func TestRouterMinAcks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	// Two of the three closest peers are smart record servers.
+	ps := staticRouting{hosts[0].ID(), hosts[1].ID(), hosts[2].ID()}
+	for _, h := range hosts[:2] {
+		if _, err := newSmartRecordServer(ctx, h, ServerProtocolPrefix(prefix)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := newSmartRecordClient(ctx, hosts[3], ClientProtocolPrefix(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []RouterOption
+		fail bool
+	}{
+		{name: "majority by default", fail: false},
+		{name: "enough acks", opts: []RouterOption{RouterMinAcks(2)}, fail: false},
+		{name: "too few acks", opts: []RouterOption{RouterMinAcks(3)}, fail: true},
+		{name: "more acks than peers", opts: []RouterOption{RouterMinAcks(5)}, fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := newSmartRecordRouter(c, ps, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = r.Update(ctx, "234", in1, ttl)
+			if tc.fail && err == nil {
+				t.Fatal("update should fail without enough acks")
+			}
+			if !tc.fail && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	if _, err := newSmartRecordRouter(c, ps, RouterMinAcks(0)); err == nil {
+		t.Fatal("router should not accept a non-positive number of acks")
+	}
+}
+
+func TestRouterNoPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	c, err := newSmartRecordClient(ctx, hosts[0], ClientProtocolPrefix(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newSmartRecordRouter(c, staticRouting{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(ctx, "234"); err == nil {
+		t.Fatal("get should fail if there are no peers")
+	}
+
+	// The only peer is not a smart record server.
+	r, err = newSmartRecordRouter(c, staticRouting{hosts[1].ID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Update(ctx, "234", in1, ttl); err == nil {
+		t.Fatal("update should fail if no peer stores the record")
+	}
+	if _, err := r.Get(ctx, "234"); err == nil {
+		t.Fatal("get should fail if no peer responds")
+	}
+}
+
+func TestMergeRecordValue(t *testing.T) {
+	p, _ := peer.Decode("QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN")
+	list := func(els ...xr.Node) xr.Dict {
+		return xr.Dict{Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "list"}, Value: xr.List{Elements: els}},
+			xr.Pair{Key: xr.String{Value: "key"}, Value: els[0]},
+		}}
+	}
+	x, y := list(xr.String{Value: "a"}, xr.String{Value: "b"}), list(xr.String{Value: "c"}, xr.String{Value: "a"})
+	out := vm.RecordValue{p: &x}
+	mergeRecordValue(out, vm.RecordValue{p: &y})
+
+	// Lists are merged as sets, and conflicts are resolved in favor of dst.
+	expected := list(xr.String{Value: "a"}, xr.String{Value: "b"}, xr.String{Value: "c"})
+	if !xr.IsEqual(expected, *out[p]) {
+		t.Fatal("dicts not merged like replicas merge them", *out[p])
+	}
+}
@@ -4,17 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"path"
 	"time"
 
+	"github.com/ipfs/go-cid"
+	goprocessctx "github.com/jbenet/goprocess/context"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
 	xr "github.com/libp2p/go-routing-language/syntax"
 
+	"github.com/libp2p/go-smart-record/ir/base"
 	meta "github.com/libp2p/go-smart-record/ir/metadata"
 	pb "github.com/libp2p/go-smart-record/protocol/pb"
+	"github.com/libp2p/go-smart-record/trace"
 	"github.com/libp2p/go-smart-record/vm"
 )
 
@@ -22,6 +25,10 @@ type SmartRecordServer interface {
 	setProtocolHandler(network.StreamHandler)
 	UpdateLocal(k string, p peer.ID, rec xr.Dict, ttl time.Duration) error
 	GetLocal(k string) vm.RecordValue
+	// GetProviders returns the providers of a CID in a key (see Providers).
+	GetProviders(k string, c cid.Cid) []peer.AddrInfo
+	// SyncWith pulls the records that differ in another server.
+	SyncWith(ctx context.Context, p peer.ID) error
 }
 
 // SmartRecordServer handles smart-record requests
@@ -31,6 +38,19 @@ type smartRecordServer struct {
 	self      peer.ID
 	vm        vm.Machine
 	protocols []protocol.ID
+	wire      map[protocol.ID]wireProtocol // Version and codec negotiated with each protocol.
+
+	replicator *replicator // Replicates accepted updates. Nil if there are no replicas.
+
+	syncSender *messageSenderImpl           // Sends sync requests to other servers.
+	syncWire   map[protocol.ID]wireProtocol // Version and codec negotiated to sync.
+
+	streamIdleTimeout time.Duration // Idle time before streams are reset.
+	maxMessageSize    int           // Max size of the requests read.
+	maxRefDepth       int           // Max depth up to which references are resolved.
+
+	metrics *requestMetrics // Nil if metrics are disabled.
+	tracer  trace.Tracer    // Nil if tracing is disabled.
 }
 
 // NewSmartRecordServer starts a smartRecordServer instance
@@ -52,20 +72,49 @@ func newSmartRecordServer(ctx context.Context, h host.Host, options ...ServerOpt
 		return nil, err
 	}
 
-	// protocols := []protocol.ID{cfg.protocolPrefix + srid}
-	protocols := protocol.ConvertFromStrings([]string{path.Join(string(cfg.protocolPrefix) + string(srid))})
+	protocols, wire := wireProtocols(cfg.protocolPrefix, cfg.versions, cfg.codecs)
 
 	// Add host to assemblerContext
 	cfg.assembler.Host = h
+	// Check dialable smart tags asynchronously.
+	dc, err := base.NewDialChecker(ctx, h, cfg.dialWorkers, cfg.dialQueue, cfg.dialTimeout, cfg.dialWindow)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]interface{}{base.DialCheckerKey: dc}
+	for k, v := range cfg.assembler.Keys {
+		keys[k] = v
+	}
+	cfg.assembler.Keys = keys
+	if cfg.strictAssembly {
+		cfg.assembler.Strict = true
+	}
 
-	vmOptions := []vm.VMOption{}
+	vmOptions := append([]vm.VMOption{}, cfg.vmOptions...)
 	// Set gcPeriod in VM if it exists.
 	if cfg.gcPeriod != 0 {
 		vmOptions = append(vmOptions, vm.GCPeriod(cfg.gcPeriod))
 	}
+	// The VM registers its metrics in the registry of the server.
+	var metrics *requestMetrics
+	if cfg.registry != nil {
+		vmOptions = append(vmOptions, vm.Metrics(cfg.registry))
+		m, err := newRequestMetrics(cfg.registry, "server")
+		if err != nil {
+			return nil, err
+		}
+		metrics = m
+	}
 
 	vm, err := vm.NewVM(ctx, h, cfg.updateContext, cfg.assembler, vmOptions...)
 	if err != nil {
+		metrics.unregister()
+		return nil, err
+	}
+	// Don't leave the VM running nor the metrics registered if the server fails to start.
+	fail := func(err error) (*smartRecordServer, error) {
+		vm.Close()
+		metrics.unregister()
 		return nil, err
 	}
 	// Start a smartRecordServer with an initialized VM.
@@ -75,6 +124,33 @@ func newSmartRecordServer(ctx context.Context, h host.Host, options ...ServerOpt
 		self:      h.ID(),
 		vm:        vm,
 		protocols: protocols,
+		wire:      wire,
+
+		streamIdleTimeout: cfg.streamIdleTimeout,
+		maxMessageSize:    cfg.maxMessageSize,
+		maxRefDepth:       cfg.maxRefDepth,
+
+		metrics: metrics,
+		tracer:  cfg.tracer,
+	}
+
+	// Start replicating updates if there are replicas.
+	if len(cfg.replicas) > 0 {
+		e.replicator, err = newReplicator(ctx, h, vm, &cfg)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	// Sync is only available if a version served supports it.
+	if sender, wire, err := newPeerSender(h, &cfg, pb.Message_SYNC); err == nil {
+		e.syncSender, e.syncWire = sender, wire
+	} else if cfg.syncPeriod > 0 {
+		return fail(err)
+	}
+	// Start syncing periodically with replicas.
+	if cfg.syncPeriod > 0 && len(cfg.replicas) > 0 {
+		goprocessctx.WithContext(ctx).Go(e.syncLoop(cfg.syncPeriod, cfg.replicas))
 	}
 
 	// Set streamhandler for smart-record protocol.
@@ -84,9 +160,14 @@ func newSmartRecordServer(ctx context.Context, h host.Host, options ...ServerOpt
 }
 
 // smartRecordHandler specifies the signature of functions that handle smart record messages.
-type smartRecordHandler func(context.Context, peer.ID, *pb.Message) (*pb.Message, error)
+// Records in messages are serialized with the codec negotiated for the stream.
+type smartRecordHandler func(context.Context, peer.ID, vm.Codec, *pb.Message) (*pb.Message, error)
 
-func (e *smartRecordServer) handlerForMsgType(t pb.Message_MessageType) smartRecordHandler {
+func (e *smartRecordServer) handlerForMsgType(w wireProtocol, t pb.Message_MessageType) smartRecordHandler {
+	// Message types not understood in the version negotiated are not handled.
+	if !w.supports(t) {
+		return nil
+	}
 	switch t {
 	case pb.Message_GET:
 		return e.handleGet
@@ -94,12 +175,22 @@ func (e *smartRecordServer) handlerForMsgType(t pb.Message_MessageType) smartRec
 		return e.handleUpdate
 	case pb.Message_QUERY:
 		return e.handleQuery
+	case pb.Message_BATCH_GET:
+		return e.handleBatchGet
+	case pb.Message_BATCH_UPDATE:
+		return e.handleBatchUpdate
+	case pb.Message_REPLICATE:
+		return e.handleReplicate
+	case pb.Message_SYNC:
+		return e.handleSync
+	case pb.Message_SYNC_PULL:
+		return e.handleSyncPull
 	}
 
 	return nil
 }
 
-func (e *smartRecordServer) handleGet(ctx context.Context, p peer.ID, msg *pb.Message) (*pb.Message, error) {
+func (e *smartRecordServer) handleGet(ctx context.Context, p peer.ID, c vm.Codec, msg *pb.Message) (*pb.Message, error) {
 	k := msg.GetKey()
 	if len(k) == 0 {
 		return nil, errors.New("handleGet: no key was provided")
@@ -110,71 +201,345 @@ func (e *smartRecordServer) handleGet(ctx context.Context, p peer.ID, msg *pb.Me
 		Type: msg.GetType(),
 		Key:  k,
 	}
-	// Get record from VM
-	r := e.vm.Get(string(k))
-	// Marshal record
-	rb, err := vm.MarshalRecordValue(r)
-	//rb, err := ir.Marshal(r)
+	rb, ab, exps, err := e.getRecord(c, string(k), int(msg.GetRefDepth()))
 	if err != nil {
 		return nil, err
 	}
 
 	resp.Value = rb
+	resp.Aggregates = ab
+	// Report the expiration of the record of each writer so clients
+	// can merge responses from several servers and cache them.
+	for w, exp := range exps {
+		resp.Entries = append(resp.Entries, &pb.Message_Entry{
+			Key:                k,
+			Writer:             []byte(w),
+			Expiration:         exp.Latest,
+			EarliestExpiration: exp.Earliest,
+		})
+	}
 	return resp, nil
 }
 
-func (e *smartRecordServer) handleUpdate(ctx context.Context, p peer.ID, msg *pb.Message) (*pb.Message, error) {
+// getRecord gets the record stored in a key, serialized with codec c,
+// resolving its references up to a depth, along with the results of its
+// aggregate smart tags, if any, and the expiration of the dict of each
+// writer. References are left unresolved if the record doesn't fit in a
+// message with them resolved.
+func (e *smartRecordServer) getRecord(c vm.Codec, k string, refDepth int) ([]byte, []byte, map[peer.ID]vm.Expiration, error) {
+	r, aggs, exps := e.record(k, refDepth)
+	// Marshal record
+	rb, err := vm.MarshalRecordValueWith(c, r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var ab []byte
+	if len(aggs.Pairs) > 0 {
+		if ab, err = vm.MarshalNode(c, aggs); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if size := len(rb) + len(ab); size > e.maxMessageSize {
+		// Records that only exceed the max size of messages
+		// with their references resolved are sent unresolved.
+		if refDepth > 0 && e.maxRefDepth > 0 {
+			return e.getRecord(c, k, 0)
+		}
+		return nil, nil, nil, fmt.Errorf("record of %d bytes exceeds the max message size", size)
+	}
+	return rb, ab, exps, nil
+}
+
+// record gets the record stored in a key from the VM, resolving its references
+// up to a depth, along with the results of its aggregate smart tags and the
+// expiration of the dict of each writer.
+func (e *smartRecordServer) record(k string, refDepth int) (vm.RecordValue, xr.Dict, map[peer.ID]vm.Expiration) {
+	r, exps := e.vm.GetWithExpirations(k)
+	// Aggregates are evaluated over the dicts of the writers only.
+	aggs := Aggregates(r)
+	if refDepth > e.maxRefDepth {
+		refDepth = e.maxRefDepth
+	}
+	if refDepth > 0 {
+		r = resolveRefs(e.vm.Get, k, r, refDepth)
+	}
+	return r, aggs, exps
+}
+
+func (e *smartRecordServer) handleUpdate(ctx context.Context, p peer.ID, c vm.Codec, msg *pb.Message) (_ *pb.Message, err error) {
+	ctx, span := trace.Start(ctx, "handleUpdate")
+	span.SetAttributes("key", string(msg.GetKey()), "writer", p.String(), "codec", c.String())
+	defer func() { trace.End(span, err) }()
 
 	k := msg.GetKey()
 	if len(k) == 0 {
 		return nil, errors.New("handleUpdate: no key was provided")
 	}
 
-	v := msg.GetValue()
-	if len(k) == 0 {
-		return nil, errors.New("handleUpdate: no value was provided")
+	resp := &pb.Message{
+		Type: msg.GetType(),
+		Key:  k,
+	}
+	if err := e.updateRecord(ctx, p, c, string(k), msg.GetValue(), msg.GetTTL(), msg.GetSignature()); err != nil {
+		return nil, err
+	}
+
+	// NOTE: If the update is successful we just send an empty response
+	// with the same key and the same type. If the update fails, the error
+	// is sent back to the client from version 0.1.0 of the protocol, and
+	// the stream is reset in previous versions so the other peer is notified
+	// that it failed.
+	return resp, nil
+}
+
+// updateRecord updates the record of peer p in a key with the value
+// serialized with codec c, and a TTL in seconds. If the update is signed,
+// the signature is verified before applying it.
+func (e *smartRecordServer) updateRecord(ctx context.Context, p peer.ID, c vm.Codec, k string, v []byte, ttl uint64, sig []byte) error {
+	_, span := trace.Start(ctx, "unmarshal")
+	rdict, err := unmarshalRecord(c, v)
+	trace.End(span, err)
+	if err != nil {
+		return err
+	}
+	_, span = trace.Start(ctx, "verifySignature")
+	span.SetAttributes("signed", len(sig) > 0)
+	err = e.verifySignature(p, k, rdict, ttl, sig)
+	trace.End(span, err)
+	if err != nil {
+		return err
+	}
+
+	// Update in VM with an absolute expiration so replicas
+	// expire the record at the same time.
+	exp := uint64(time.Now().Unix()) + ttl
+	err = e.vm.Update(ctx, p, k, rdict, []meta.Metadata{meta.Expiration(exp)}...)
+	if err != nil {
+		return fmt.Errorf("failed updating dict: %w", err)
+	}
+	e.replicate(&replication{key: k, writer: p, expiration: exp, update: rdict, ttl: ttl, signature: sig})
+	return nil
+}
+
+// verifySignature verifies the signature of an update by its writer if the
+// update was signed and the public key of the writer is known.
+func (e *smartRecordServer) verifySignature(writer peer.ID, k string, rec xr.Dict, ttl uint64, sig []byte) error {
+	if len(sig) == 0 {
+		return nil
+	}
+	pk := writerKey(e.host.Peerstore(), writer)
+	if pk == nil {
+		return nil
+	}
+	return verifyUpdate(pk, k, rec, ttl, sig)
+}
+
+// unmarshalRecord unmarshals the record sent in an update.
+func unmarshalRecord(c vm.Codec, v []byte) (xr.Dict, error) {
+	if len(v) == 0 {
+		return xr.Dict{}, errors.New("no value was provided")
 	}
-	ttl := msg.GetTTL()
 
 	// Unmarshal the record sent
-	smrec, err := xr.UnmarshalJSON(v)
+	smrec, err := vm.UnmarshalNode(c, v)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshalling record: %s", err)
+		return xr.Dict{}, fmt.Errorf("error unmarshalling record: %s", err)
 	}
 	rdict, ok := smrec.(xr.Dict)
 	if !ok {
-		return nil, fmt.Errorf("value sent is not a record. Won't update")
+		return xr.Dict{}, fmt.Errorf("value sent is not a record. Won't update")
 	}
+	return rdict, nil
+}
 
-	resp := &pb.Message{
-		Type: msg.GetType(),
-		Key:  k,
+// replicate forwards an update accepted in the dict of a writer to the
+// replicas of the server. The keys of the update in the fragment of the dict
+// that expires with it are forwarded along with the update, so replicas store
+// the values resolved by the server (e.g. the numbers assigned to seq() and
+// to the entries appended to logs) instead of resolving them again, and
+// verify the signature of the writer, if any.
+func (e *smartRecordServer) replicate(u *replication) {
+	if e.replicator == nil {
+		return
 	}
-	// Update in VM
-	err = e.vm.Update(p, string(k), rdict, []meta.Metadata{meta.TTL(time.Duration(ttl) * time.Second)}...)
+	for _, f := range e.vm.Snapshot(u.key)[u.writer] {
+		if f.Expiration != u.expiration {
+			continue
+		}
+		value := xr.Dict{Pairs: xr.Pairs{}}
+		for _, p := range f.Dict.Pairs {
+			if inUpdate(u.update, p.Key) {
+				value.Pairs = append(value.Pairs, p)
+			}
+		}
+		r := *u
+		r.value = value
+		r.seq = e.vm.Seq(u.key)
+		e.replicator.forward(&r)
+	}
+}
+
+// isReplica returns true if the peer is one of the replicas of the server.
+func (e *smartRecordServer) isReplica(p peer.ID) bool {
+	return e.replicator != nil && e.replicator.isReplica(p)
+}
+
+// handleReplicate applies an update forwarded by a replica. The update
+// is merged in the private space of its original writer keeping its
+// expiration time, and it is not forwarded again.
+func (e *smartRecordServer) handleReplicate(ctx context.Context, p peer.ID, c vm.Codec, msg *pb.Message) (*pb.Message, error) {
+	if !e.isReplica(p) {
+		return nil, errors.New("handleReplicate: peer is not a replica")
+	}
+	k := msg.GetKey()
+	if len(k) == 0 {
+		return nil, errors.New("handleReplicate: no key was provided")
+	}
+	writer, err := peer.IDFromBytes(msg.GetWriter())
+	if err != nil {
+		return nil, fmt.Errorf("handleReplicate: wrong writer: %s", err)
+	}
+	rdict, err := unmarshalRecord(c, msg.GetValue())
 	if err != nil {
-		return nil, fmt.Errorf("failed updating dict: %s", err)
+		return nil, err
+	}
+	if err := e.verifyReplicated(writer, string(k), c, rdict, msg); err != nil {
+		return nil, err
 	}
+	if err := e.vm.AdvanceSeq(string(k), msg.GetSeq()); err != nil {
+		return nil, fmt.Errorf("handleReplicate: %s", err)
+	}
+
+	err = e.vm.Merge(ctx, writer, string(k), rdict, meta.Expiration(msg.GetExpiration()))
+	if err != nil {
+		return nil, fmt.Errorf("failed merging dict: %s", err)
+	}
+	return &pb.Message{Type: msg.GetType(), Key: k}, nil
+}
 
-	// NOTE: For now if the update is successful we just send an empty response
-	// with the same key and the same type. We could reutrn a response type
-	// saying if the Update was OK or KO but will avoid it for now. If the
-	// update fails the stream will be closed with error so the other peer
-	// will be notified that it failed.
+// verifyReplicated verifies the update signed by the writer that is sent
+// along with a replicated fragment of its dict, if any. The fragment must
+// only hold keys of the update, and expire within its TTL. Fragments sent
+// without a signed update, like the ones of unsigned updates or the ones
+// pushed in reconciliations, are trusted from the replica.
+func (e *smartRecordServer) verifyReplicated(writer peer.ID, k string, c vm.Codec, rdict xr.Dict, msg *pb.Message) error {
+	if len(msg.GetSignature()) == 0 {
+		return nil
+	}
+	signed, err := unmarshalRecord(c, msg.GetSignedValue())
+	if err != nil {
+		return fmt.Errorf("handleReplicate: wrong signed update: %s", err)
+	}
+	if err := e.verifySignature(writer, k, signed, msg.GetTTL(), msg.GetSignature()); err != nil {
+		return err
+	}
+	for _, p := range rdict.Pairs {
+		if !inUpdate(signed, p.Key) {
+			return fmt.Errorf("handleReplicate: key %v not in the signed update", p.Key)
+		}
+	}
+	maxExp := uint64(time.Now().Add(maxReplicaClockSkew).Unix()) + msg.GetTTL()
+	if msg.GetExpiration() > maxExp {
+		return errors.New("handleReplicate: expiration beyond the TTL of the signed update")
+	}
+	return nil
+}
+
+// handleBatchGet gets the record of every key in the entries of the request.
+// Keys that fail report their error in their entry of the response.
+func (e *smartRecordServer) handleBatchGet(ctx context.Context, p peer.ID, c vm.Codec, msg *pb.Message) (*pb.Message, error) {
+	if len(msg.GetEntries()) == 0 {
+		return nil, errors.New("handleBatchGet: no entries were provided")
+	}
+
+	resp := &pb.Message{
+		Type:    msg.GetType(),
+		Entries: make([]*pb.Message_Entry, len(msg.GetEntries())),
+	}
+	for i, en := range msg.GetEntries() {
+		out := &pb.Message_Entry{Key: en.GetKey()}
+		if len(en.GetKey()) == 0 {
+			out.Error = "no key was provided"
+		} else if rb, _, _, err := e.getRecord(c, string(en.GetKey()), 0); err != nil {
+			out.Error = err.Error()
+		} else {
+			out.Value = rb
+		}
+		resp.Entries[i] = out
+	}
 	return resp, nil
 }
 
-func (e *smartRecordServer) handleQuery(ctx context.Context, p peer.ID, msg *pb.Message) (_ *pb.Message, err error) {
-	// TODO: For now query is the same as get. We don't understand selectors yet.
-	return e.handleGet(ctx, p, msg)
+// handleBatchUpdate applies the update of every key in the entries of the request.
+// Updates are applied independently, so a failed key doesn't prevent the rest from
+// being updated. Keys that fail report their error in their entry of the response.
+func (e *smartRecordServer) handleBatchUpdate(ctx context.Context, p peer.ID, c vm.Codec, msg *pb.Message) (*pb.Message, error) {
+	if len(msg.GetEntries()) == 0 {
+		return nil, errors.New("handleBatchUpdate: no entries were provided")
+	}
+
+	resp := &pb.Message{
+		Type:    msg.GetType(),
+		Entries: make([]*pb.Message_Entry, len(msg.GetEntries())),
+	}
+	for i, en := range msg.GetEntries() {
+		out := &pb.Message_Entry{Key: en.GetKey()}
+		if len(en.GetKey()) == 0 {
+			out.Error = "no key was provided"
+		} else if err := e.updateRecord(ctx, p, c, string(en.GetKey()), en.GetValue(), en.GetTTL(), en.GetSignature()); err != nil {
+			out.Error = err.Error()
+			out.AssemblyError = assemblyErrorToPB(err)
+		}
+		resp.Entries[i] = out
+	}
+	return resp, nil
+}
+
+// handleQuery returns the record of a key like handleGet, selecting the parts
+// of it matched by the selector sent in the value of the request, if any.
+func (e *smartRecordServer) handleQuery(ctx context.Context, p peer.ID, c vm.Codec, msg *pb.Message) (_ *pb.Message, err error) {
+	if len(msg.GetValue()) == 0 {
+		return e.handleGet(ctx, p, c, msg)
+	}
+	k := msg.GetKey()
+	if len(k) == 0 {
+		return nil, errors.New("handleQuery: no key was provided")
+	}
+	n, err := vm.UnmarshalNode(c, msg.GetValue())
+	if err != nil {
+		return nil, err
+	}
+	d, ok := n.(xr.Dict)
+	if !ok {
+		return nil, errors.New("handleQuery: selector is not a dict")
+	}
+	sel, err := parseSelector(d)
+	if err != nil {
+		return nil, fmt.Errorf("handleQuery: %w", err)
+	}
+	r, _, _ := e.record(string(k), 0)
+	rb, err := vm.MarshalRecordValueWith(c, sel.apply(r))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Message{Type: msg.GetType(), Key: k, Value: rb}, nil
 }
 
 func (e *smartRecordServer) UpdateLocal(k string, p peer.ID, rec xr.Dict, ttl time.Duration) error {
+	exp := uint64(time.Now().Unix()) + uint64(ttl.Seconds())
 	// Update in VM
-	return e.vm.Update(p, k, rec, []meta.Metadata{meta.TTL(ttl)}...)
+	if err := e.vm.Update(e.ctx, p, k, rec, []meta.Metadata{meta.Expiration(exp)}...); err != nil {
+		return err
+	}
+	e.replicate(&replication{key: k, writer: p, expiration: exp, update: rec})
+	return nil
 }
 
 func (e *smartRecordServer) GetLocal(k string) vm.RecordValue {
 	// Update in VM
 	return e.vm.Get(k)
 }
+
+func (e *smartRecordServer) GetProviders(k string, c cid.Cid) []peer.AddrInfo {
+	return Providers(e.vm.Get(k), c)
+}
@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// updateSignatureDomain is prepended to the payload signed by writers
+// so signatures of updates can't be reused in other contexts.
+const updateSignatureDomain = "libp2p-smart-record-update:"
+
+// updatePayload returns the payload signed by the writer of an update.
+// The record is serialized in CBOR independently of the codec negotiated,
+// so the signature can be verified by any server the update is forwarded to.
+func updatePayload(k string, rec xr.Dict, ttl uint64) ([]byte, error) {
+	rb, err := vm.MarshalNode(vm.CodecCBOR, rec)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(updateSignatureDomain)+len(k)+len(rb)+3*binary.MaxVarintLen64)
+	out = append(out, updateSignatureDomain...)
+	out = appendBytes(out, []byte(k))
+	out = appendBytes(out, rb)
+	out = appendUvarint(out, ttl)
+	return out, nil
+}
+
+// appendBytes appends b to out prefixed by its length.
+func appendBytes(out []byte, b []byte) []byte {
+	out = appendUvarint(out, uint64(len(b)))
+	return append(out, b...)
+}
+
+func appendUvarint(out []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(out, buf[:n]...)
+}
+
+// signUpdate signs an update with the private key of the writer.
+func signUpdate(sk crypto.PrivKey, k string, rec xr.Dict, ttl uint64) ([]byte, error) {
+	payload, err := updatePayload(k, rec, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return sk.Sign(payload)
+}
+
+// verifyUpdate verifies the signature of an update by its writer.
+func verifyUpdate(pk crypto.PubKey, k string, rec xr.Dict, ttl uint64, sig []byte) error {
+	payload, err := updatePayload(k, rec, ttl)
+	if err != nil {
+		return err
+	}
+	ok, err := pk.Verify(payload, sig)
+	if err != nil {
+		return fmt.Errorf("error verifying signature: %s", err)
+	}
+	if !ok {
+		return errors.New("invalid signature of the writer")
+	}
+	return nil
+}
+
+// writerKey returns the public key of a writer, from the peerstore or
+// extracted from its peer ID. It returns nil if it is not available.
+func writerKey(ps peerstore.Peerstore, p peer.ID) crypto.PubKey {
+	if pk := ps.PubKey(p); pk != nil {
+		return pk
+	}
+	pk, err := p.ExtractPublicKey()
+	if err != nil {
+		return nil
+	}
+	return pk
+}
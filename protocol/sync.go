@@ -0,0 +1,306 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jbenet/goprocess"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+const (
+	// syncPageSize is the maximum number of keys whose digests are sent
+	// in a SYNC response. Responses are also bounded by the max message size.
+	syncPageSize = 1024
+	// syncPullSize is the maximum number of dicts pulled in a SYNC_PULL request.
+	syncPullSize = 64
+)
+
+// slot identifies the dict stored by a writer in a key.
+type slot struct {
+	key    string
+	writer peer.ID
+}
+
+// SyncWith synchronizes the state of the server with another server. The digests
+// of the records stored by both servers are compared, and the dicts of the writers
+// whose digest differs are pulled and merged keeping the latest values. The other
+// server converges to the same state when it syncs with this one. Servers only
+// sync with their replicas.
+func (e *smartRecordServer) SyncWith(ctx context.Context, p peer.ID) error {
+	if e.syncSender == nil {
+		return errors.New("sync is not supported by any of the versions served")
+	}
+	w, err := e.syncWireForPeer(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	// Get the digests of the other server, a page of keys at a time.
+	slots := []slot{}
+	var next []byte
+	for {
+		resp, err := e.sendSyncRequest(ctx, p, &pb.Message{Type: pb.Message_SYNC, Key: next})
+		if err != nil {
+			return err
+		}
+		s, err := e.diffDigests(resp.GetEntries())
+		if err != nil {
+			return err
+		}
+		slots = append(slots, s...)
+		if len(resp.GetKey()) == 0 {
+			break
+		}
+		if bytes.Compare(resp.GetKey(), next) <= 0 {
+			return errors.New("sync response doesn't advance to the next page")
+		}
+		next = resp.GetKey()
+	}
+
+	// Pull the slots that differ. Slots left out of a response
+	// to keep it small are pulled again in the next request.
+	for len(slots) > 0 {
+		req := &pb.Message{Type: pb.Message_SYNC_PULL, Entries: []*pb.Message_Entry{}}
+		for size := 0; len(req.Entries) < len(slots) && len(req.Entries) < syncPullSize; {
+			s := slots[len(req.Entries)]
+			en := &pb.Message_Entry{Key: []byte(s.key), Writer: []byte(s.writer)}
+			if size += entrySize(en); len(req.Entries) > 0 && size > e.maxMessageSize/2 {
+				break
+			}
+			req.Entries = append(req.Entries, en)
+		}
+		n, err := e.pull(ctx, p, w, req)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return errors.New("sync pull response doesn't include any dict")
+		}
+		slots = slots[n:]
+	}
+	return nil
+}
+
+// pull sends a SYNC_PULL request to another server and merges the dicts
+// received. It returns the number of slots of the request served.
+func (e *smartRecordServer) pull(ctx context.Context, p peer.ID, w wireProtocol, req *pb.Message) (int, error) {
+	resp, err := e.sendSyncRequest(ctx, p, req)
+	if err != nil {
+		return 0, err
+	}
+	n := len(req.GetEntries())
+	for _, en := range resp.GetEntries() {
+		writer, err := peer.IDFromBytes(en.GetWriter())
+		if err != nil {
+			return 0, fmt.Errorf("wrong writer in sync response: %s", err)
+		}
+		// The slots from the one reported were left out of the response.
+		if en.GetError() != "" {
+			for i, s := range req.GetEntries() {
+				if bytes.Equal(s.GetKey(), en.GetKey()) && bytes.Equal(s.GetWriter(), en.GetWriter()) {
+					n = i
+					break
+				}
+			}
+			continue
+		}
+		rdict, err := unmarshalRecord(w.codec, en.GetValue())
+		if err != nil {
+			return 0, err
+		}
+		if err := e.vm.AdvanceSeq(string(en.GetKey()), en.GetSeq()); err != nil {
+			return 0, fmt.Errorf("wrong sequence in sync response: %s", err)
+		}
+		err = e.vm.Merge(ctx, writer, string(en.GetKey()), rdict, meta.Expiration(en.GetExpiration()))
+		if err != nil {
+			return 0, fmt.Errorf("failed merging dict: %s", err)
+		}
+	}
+	return n, nil
+}
+
+// diffDigests returns the slots whose digest in the entries received differs
+// from the local one. Slots that already expired are skipped.
+func (e *smartRecordServer) diffDigests(entries []*pb.Message_Entry) ([]slot, error) {
+	now := uint64(time.Now().Unix())
+	local := make(map[string]map[peer.ID]vm.Digest)
+	out := []slot{}
+	for _, en := range entries {
+		if en.GetExpiration() < now {
+			continue
+		}
+		k := string(en.GetKey())
+		writer, err := peer.IDFromBytes(en.GetWriter())
+		if err != nil {
+			return nil, fmt.Errorf("wrong writer in sync response: %s", err)
+		}
+		if _, ok := local[k]; !ok {
+			d, err := e.vm.Digest(k)
+			if err != nil {
+				return nil, err
+			}
+			local[k] = d
+		}
+		if d, ok := local[k][writer]; ok && bytes.Equal(d.Hash, en.GetHash()) {
+			continue
+		}
+		out = append(out, slot{key: k, writer: writer})
+	}
+	return out, nil
+}
+
+// handleSync responds with the digest of the dict of every writer in a page
+// of keys, starting from the key of the request. The key of the response is
+// the first key of the next page, or empty if it is the last page. Only
+// replicas can sync.
+func (e *smartRecordServer) handleSync(ctx context.Context, p peer.ID, c vm.Codec, msg *pb.Message) (*pb.Message, error) {
+	if !e.isReplica(p) {
+		return nil, errors.New("handleSync: peer is not a replica")
+	}
+	resp := &pb.Message{Type: msg.GetType(), Entries: []*pb.Message_Entry{}}
+	ks := e.vm.Keys()
+	i := sort.SearchStrings(ks, string(msg.GetKey()))
+	for size, n := 0, 0; i < len(ks) && n < syncPageSize; i, n = i+1, n+1 {
+		ds, err := e.vm.Digest(ks[i])
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]*pb.Message_Entry, 0, len(ds))
+		s := 0
+		for w, d := range ds {
+			en := &pb.Message_Entry{
+				Key:        []byte(ks[i]),
+				Writer:     []byte(w),
+				Hash:       d.Hash,
+				Expiration: d.Expiration,
+			}
+			s += entrySize(en)
+			entries = append(entries, en)
+		}
+		// Every page has at least one key.
+		if n > 0 && size+s > e.maxMessageSize/2 {
+			break
+		}
+		size += s
+		resp.Entries = append(resp.Entries, entries...)
+	}
+	if i < len(ks) {
+		resp.Key = []byte(ks[i])
+	}
+	return resp, nil
+}
+
+// entrySize returns the size of an entry in a message.
+func entrySize(en *pb.Message_Entry) int {
+	return en.Size() + binary.MaxVarintLen64 + 1
+}
+
+// handleSyncPull responds with the fragments of the dicts requested. Each fragment
+// is sent in its own entry with its expiration time. Only replicas can pull dicts,
+// and at most syncPullSize of them in each request. Dicts are sent in the order
+// requested until the response is full. The first dict left out is then reported
+// in an entry with an error, and it is pulled again with the rest in another request.
+func (e *smartRecordServer) handleSyncPull(ctx context.Context, p peer.ID, c vm.Codec, msg *pb.Message) (*pb.Message, error) {
+	if !e.isReplica(p) {
+		return nil, errors.New("handleSyncPull: peer is not a replica")
+	}
+	if len(msg.GetEntries()) == 0 {
+		return nil, errors.New("handleSyncPull: no entries were provided")
+	}
+	if len(msg.GetEntries()) > syncPullSize {
+		return nil, fmt.Errorf("handleSyncPull: more than %d entries requested", syncPullSize)
+	}
+	resp := &pb.Message{Type: msg.GetType(), Entries: []*pb.Message_Entry{}}
+	size := 0
+	for i, en := range msg.GetEntries() {
+		writer, err := peer.IDFromBytes(en.GetWriter())
+		if err != nil {
+			return nil, fmt.Errorf("handleSyncPull: wrong writer: %s", err)
+		}
+		entries := []*pb.Message_Entry{}
+		s := 0
+		for _, f := range e.vm.Snapshot(string(en.GetKey()))[writer] {
+			vb, err := vm.MarshalNode(c, f.Dict)
+			if err != nil {
+				return nil, err
+			}
+			out := &pb.Message_Entry{
+				Key:        en.GetKey(),
+				Writer:     en.GetWriter(),
+				Value:      vb,
+				Expiration: f.Expiration,
+				Seq:        e.vm.Seq(string(en.GetKey())),
+			}
+			s += entrySize(out)
+			entries = append(entries, out)
+		}
+		// Every response has at least one dict.
+		if i > 0 && size+s > e.maxMessageSize/2 {
+			resp.Entries = append(resp.Entries, &pb.Message_Entry{
+				Key:    en.GetKey(),
+				Writer: en.GetWriter(),
+				Error:  "response full",
+			})
+			break
+		}
+		size += s
+		resp.Entries = append(resp.Entries, entries...)
+	}
+	return resp, nil
+}
+
+// syncWireForPeer returns the version and codec negotiated with a peer to sync.
+func (e *smartRecordServer) syncWireForPeer(ctx context.Context, p peer.ID) (wireProtocol, error) {
+	proto, err := e.syncSender.ProtocolForPeer(ctx, p)
+	if err != nil {
+		return wireProtocol{}, err
+	}
+	w, ok := e.syncWire[proto]
+	if !ok {
+		return wireProtocol{}, fmt.Errorf("unknown protocol negotiated %s", proto)
+	}
+	return w, nil
+}
+
+func (e *smartRecordServer) sendSyncRequest(ctx context.Context, p peer.ID, req *pb.Message) (*pb.Message, error) {
+	resp, err := e.syncSender.SendRequest(ctx, p, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetError() != "" {
+		return nil, fmt.Errorf("request failed in server: %s", resp.GetError())
+	}
+	return resp, nil
+}
+
+// syncLoop periodically syncs the server with its replicas.
+func (e *smartRecordServer) syncLoop(period time.Duration, replicas []peer.ID) func(goprocess.Process) {
+	return func(proc goprocess.Process) {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, p := range replicas {
+					ctx, cancel := context.WithTimeout(e.ctx, period)
+					if err := e.SyncWith(ctx, p); err != nil {
+						log.Debugw("failed syncing with replica", "error", err, "to", p)
+					}
+					cancel()
+				}
+			case <-proc.Closing():
+				return
+			}
+		}
+	}
+}
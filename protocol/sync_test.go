@@ -0,0 +1,194 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+)
+
+func TestSyncWith(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	// Large period so replicas only converge with sync.
+	s0, err := newSmartRecordServer(ctx, hosts[0], replicaOpts(hosts[:2], 0, time.Hour)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, err := newSmartRecordServer(ctx, hosts[1], replicaOpts(hosts[:2], 1, time.Hour)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := hosts[2].ID()
+
+	// Servers diverge in one key and have the same record in other.
+	k, same := "234", "same"
+	exp := uint64(time.Now().Unix()) + 10
+	if err := s0.vm.Update(ctx, writer, k, in1, meta.Expiration(exp)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s1.vm.Update(ctx, writer, k, in2, meta.Expiration(exp)); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*smartRecordServer{s0, s1} {
+		if err := s.vm.Update(ctx, writer, same, in1, meta.Expiration(exp)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the slot that differs is pulled.
+	resp, err := s0.handleSync(ctx, hosts[1].ID(), 0, &pb.Message{Type: pb.Message_SYNC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slots, err := s1.diffDigests(resp.GetEntries())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 1 || slots[0].key != k || slots[0].writer != writer {
+		t.Fatal("wrong slots to pull", slots)
+	}
+
+	if err := s0.SyncWith(ctx, hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	if out := s0.GetLocal(k); !xr.IsEqual(in, *out[writer]) {
+		t.Fatal("record not pulled", out)
+	}
+	if out := s1.GetLocal(k); !xr.IsEqual(in2, *out[writer]) {
+		t.Fatal("sync shouldn't change the other server", out)
+	}
+
+	if err := s1.SyncWith(ctx, hosts[0].ID()); err != nil {
+		t.Fatal(err)
+	}
+	if out := s1.GetLocal(k); !xr.IsEqual(in, *out[writer]) {
+		t.Fatal("record not pulled", out)
+	}
+	// Both servers have the same digests now.
+	resp, err = s0.handleSync(ctx, hosts[1].ID(), 0, &pb.Message{Type: pb.Message_SYNC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slots, err := s1.diffDigests(resp.GetEntries()); err != nil || len(slots) != 0 {
+		t.Fatal("servers didn't converge", slots, err)
+	}
+	if d, _ := s1.vm.Digest(same); d[writer].Expiration != exp {
+		t.Fatal("wrong expiration in digest", d[writer].Expiration, exp)
+	}
+}
+
+func TestSyncPages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	// Small messages so digests are sent in several pages.
+	opts := func(i int) []ServerOption {
+		return append(replicaOpts(hosts[:2], i, time.Hour), ServerMaxMessageSize(1024))
+	}
+	s0, err := newSmartRecordServer(ctx, hosts[0], opts(0)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, err := newSmartRecordServer(ctx, hosts[1], opts(1)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := hosts[2].ID()
+	exp := uint64(time.Now().Unix()) + 10
+	for i := 0; i < 20; i++ {
+		if err := s0.vm.Update(ctx, writer, fmt.Sprintf("k%02d", i), in1, meta.Expiration(exp)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := s0.handleSync(ctx, hosts[1].ID(), 0, &pb.Message{Type: pb.Message_SYNC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetKey()) == 0 || len(resp.GetEntries()) == 0 || len(resp.GetEntries()) == 20 {
+		t.Fatal("digests not paginated", len(resp.GetEntries()), string(resp.GetKey()))
+	}
+	if err := s1.SyncWith(ctx, hosts[0].ID()); err != nil {
+		t.Fatal(err)
+	}
+	if ks := s1.vm.Keys(); len(ks) != 20 {
+		t.Fatal("not every page was pulled", ks)
+	}
+
+	// Peers that are not replicas can't sync.
+	if _, err := s0.handleSync(ctx, hosts[2].ID(), 0, &pb.Message{Type: pb.Message_SYNC}); err == nil {
+		t.Fatal("sync from a peer that is not a replica should fail")
+	}
+	pull := &pb.Message{Type: pb.Message_SYNC_PULL, Entries: []*pb.Message_Entry{{Key: []byte("k00"), Writer: []byte(writer)}}}
+	if _, err := s0.handleSyncPull(ctx, hosts[2].ID(), 0, pull); err == nil {
+		t.Fatal("sync pull from a peer that is not a replica should fail")
+	}
+}
+
+func TestPeriodicSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	writer := hosts[2].ID()
+	opts := func(i int) []ServerOption {
+		// Only sync makes servers converge.
+		return append(replicaOpts(hosts[:2], i, time.Hour), SyncPeriod(200*time.Millisecond))
+	}
+
+	// The first server is updated before the other one is up,
+	// so forwarding the update fails.
+	s0, err := newSmartRecordServer(ctx, hosts[0], opts(0)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := "234"
+	if err := s0.UpdateLocal(k, writer, in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	s1, err := newSmartRecordServer(ctx, hosts[1], opts(1)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkConverged(t, []*smartRecordServer{s0, s1}, k, writer, in1)
+}
+
+func TestSyncNotSupported(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	s0, err := newSmartRecordServer(ctx, hosts[0], ServerProtocolPrefix(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSmartRecordServer(ctx, hosts[1], ServerProtocolPrefix(prefix), ServerVersions(Version030)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s0.SyncWith(ctx, hosts[1].ID()); err == nil {
+		t.Fatal("sync should fail with a server that doesn't support it")
+	}
+}
@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+	"github.com/libp2p/go-smart-record/trace"
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// recordingTracer records the spans started in a test.
+type recordingTracer struct {
+	lk    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name   string
+	parent string
+	attrs  map[interface{}]interface{}
+	events []map[interface{}]interface{}
+	err    error
+	ended  bool
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) trace.Span {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	s := &recordedSpan{name: name, attrs: map[interface{}]interface{}{}}
+	if p, ok := trace.SpanFromContext(ctx).(*recordedSpan); ok {
+		s.parent = p.name
+	}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+// span returns the last span recorded with a name.
+func (t *recordingTracer) span(name string) *recordedSpan {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	for i := len(t.spans) - 1; i >= 0; i-- {
+		if t.spans[i].name == name {
+			return t.spans[i]
+		}
+	}
+	return nil
+}
+
+func keyValues(kv []interface{}) map[interface{}]interface{} {
+	m := make(map[interface{}]interface{})
+	for i := 0; i+1 < len(kv); i += 2 {
+		m[kv[i]] = kv[i+1]
+	}
+	return m
+}
+
+func (s *recordedSpan) SetAttributes(kv ...interface{}) {
+	for k, v := range keyValues(kv) {
+		s.attrs[k] = v
+	}
+}
+func (s *recordedSpan) AddEvent(name string, kv ...interface{}) {
+	s.events = append(s.events, keyValues(append(kv, "event", name)))
+}
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+func (s *recordedSpan) End()                  { s.ended = true }
+
+func TestTraceUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr := &recordingTracer{}
+	s := setupServer(ctx, t, ServerTracer(tr))
+	c := setupClient(ctx, t)
+	connect(ctx, t, c.host, s.host)
+
+	k := "234"
+	if err := c.Update(ctx, k, s.host.ID(), in1, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(ctx, k, s.host.ID(), in2, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every stage is traced as a child of the previous one.
+	parents := map[string]string{
+		"handleUpdate":    "",
+		"unmarshal":       "handleUpdate",
+		"verifySignature": "handleUpdate",
+		"vm.Update":       "handleUpdate",
+		"vm.assemble":     "vm.Update",
+		"vm.trigger":      "vm.Update",
+		"vm.merge":        "vm.Update",
+	}
+	for name, parent := range parents {
+		sp := tr.span(name)
+		if sp == nil || sp.parent != parent || !sp.ended || sp.err != nil {
+			t.Fatalf("wrong span %s: %+v", name, sp)
+		}
+	}
+	if got := tr.span("handleUpdate").attrs["key"]; got != k {
+		t.Fatal("key not traced", got)
+	}
+	// The assembler that matched each node is traced.
+	matched := map[interface{}]bool{}
+	for _, ev := range tr.span("vm.assemble").events {
+		matched[ev["assembler"]] = true
+	}
+	if !matched["ir.DictAssembler"] || !matched["ir.StringAssembler"] {
+		t.Fatal("assemblers not traced", tr.span("vm.assemble").events)
+	}
+
+	// Errors are recorded in the stage that failed and its parents.
+	tctx := trace.WithTracer(ctx, tr)
+	msg := &pb.Message{Type: pb.Message_UPDATE, Key: []byte(k), Value: []byte("not a record"), TTL: 10}
+	if _, err := s.handleUpdate(tctx, c.host.ID(), vm.CodecJSON, msg); err == nil {
+		t.Fatal("update that doesn't unmarshal should fail")
+	}
+	if tr.span("unmarshal").err == nil || tr.span("handleUpdate").err == nil {
+		t.Fatal("error not traced")
+	}
+}
@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pb "github.com/libp2p/go-smart-record/protocol/pb"
+	"github.com/libp2p/go-smart-record/vm"
+)
+
+// Version identifies a version of the smart-record protocol.
+type Version string
+
+const (
+	// Version001 is the original version of the protocol. Failed requests
+	// reset the stream without reporting the error to the client.
+	Version001 Version = "0.0.1"
+	// Version010 reports failed requests to the client in the Error
+	// field of the response.
+	Version010 Version = "0.1.0"
+	// Version020 adds BATCH_GET and BATCH_UPDATE messages to request
+	// several keys at once.
+	Version020 Version = "0.2.0"
+	// Version030 adds REPLICATE messages between servers, and the
+	// signature of updates by their writer.
+	Version030 Version = "0.3.0"
+	// Version040 adds SYNC and SYNC_PULL messages to compare the digests
+	// of records between servers and pull the ones that differ.
+	Version040 Version = "0.4.0"
+)
+
+// protocolVersion determines the behavior of a version of the protocol.
+type protocolVersion struct {
+	// Message types understood in this version.
+	msgTypes map[pb.Message_MessageType]bool
+	// Send errors to the client in a response instead of resetting the stream.
+	errorResponses bool
+	// Updates can be signed by their writer.
+	signatures bool
+	// Order of the version. Higher is newer.
+	order int
+}
+
+// protocolVersions holds the specification of every version supported.
+var protocolVersions = map[Version]protocolVersion{
+	Version001: {
+		msgTypes: msgTypes(pb.Message_GET, pb.Message_UPDATE, pb.Message_QUERY),
+		order:    0,
+	},
+	Version010: {
+		msgTypes:       msgTypes(pb.Message_GET, pb.Message_UPDATE, pb.Message_QUERY),
+		errorResponses: true,
+		order:          1,
+	},
+	Version020: {
+		msgTypes: msgTypes(pb.Message_GET, pb.Message_UPDATE, pb.Message_QUERY,
+			pb.Message_BATCH_GET, pb.Message_BATCH_UPDATE),
+		errorResponses: true,
+		order:          2,
+	},
+	Version030: {
+		msgTypes: msgTypes(pb.Message_GET, pb.Message_UPDATE, pb.Message_QUERY,
+			pb.Message_BATCH_GET, pb.Message_BATCH_UPDATE, pb.Message_REPLICATE),
+		errorResponses: true,
+		signatures:     true,
+		order:          3,
+	},
+	Version040: {
+		msgTypes: msgTypes(pb.Message_GET, pb.Message_UPDATE, pb.Message_QUERY,
+			pb.Message_BATCH_GET, pb.Message_BATCH_UPDATE, pb.Message_REPLICATE,
+			pb.Message_SYNC, pb.Message_SYNC_PULL),
+		errorResponses: true,
+		signatures:     true,
+		order:          4,
+	},
+}
+
+func msgTypes(ts ...pb.Message_MessageType) map[pb.Message_MessageType]bool {
+	out := make(map[pb.Message_MessageType]bool, len(ts))
+	for _, t := range ts {
+		out[t] = true
+	}
+	return out
+}
+
+// supportedVersions lists every version supported, newest first.
+var supportedVersions = []Version{Version040, Version030, Version020, Version010, Version001}
+
+func checkVersions(versions []Version) error {
+	if len(versions) == 0 {
+		return fmt.Errorf("at least one version must be supported")
+	}
+	for _, v := range versions {
+		if _, ok := protocolVersions[v]; !ok {
+			return fmt.Errorf("unsupported protocol version %s", v)
+		}
+	}
+	return nil
+}
+
+// wireProtocol is the version and codec negotiated through a protocol ID.
+type wireProtocol struct {
+	version Version
+	codec   vm.Codec
+}
+
+func (w wireProtocol) spec() protocolVersion {
+	return protocolVersions[w.version]
+}
+
+// supports returns true if the message type is understood in the version.
+func (w wireProtocol) supports(t pb.Message_MessageType) bool {
+	return w.spec().msgTypes[t]
+}
+
+// protocolID returns the protocol ID used to negotiate a version and codec.
+// JSON uses the bare protocol ID of the version to remain compatible with
+// peers that don't support binary codecs.
+func protocolID(prefix protocol.ID, w wireProtocol) protocol.ID {
+	id := string(prefix) + string(srid) + "/" + string(w.version)
+	if w.codec != vm.CodecJSON {
+		id = id + "/" + w.codec.String()
+	}
+	return protocol.ID(path.Clean(id))
+}
+
+// wireProtocols returns the protocol IDs for every combination of version
+// and codec, and the wireProtocol negotiated with each of them. Protocol IDs
+// are ordered by preference: newest version first and, for each version,
+// following the order of codecs.
+func wireProtocols(prefix protocol.ID, versions []Version, codecs []vm.Codec) ([]protocol.ID, map[protocol.ID]wireProtocol) {
+	vs := make([]Version, len(versions))
+	copy(vs, versions)
+	sort.SliceStable(vs, func(i, j int) bool {
+		return protocolVersions[vs[i]].order > protocolVersions[vs[j]].order
+	})
+
+	protocols := make([]protocol.ID, 0, len(vs)*len(codecs))
+	byProtocol := make(map[protocol.ID]wireProtocol, len(vs)*len(codecs))
+	for _, v := range vs {
+		for _, c := range codecs {
+			w := wireProtocol{version: v, codec: c}
+			id := protocolID(prefix, w)
+			protocols = append(protocols, id)
+			byProtocol[id] = w
+		}
+	}
+	return protocols, byProtocol
+}
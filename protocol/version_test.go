@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	xr "github.com/libp2p/go-routing-language/syntax"
+)
+
+// setupMocknet creates a client and a server in a mocknet speaking the
+// versions specified.
+func setupMocknet(ctx context.Context, t *testing.T, cv []Version, sv []Version) (*smartRecordClient, *smartRecordServer) {
+	mn, err := mocknet.FullMeshConnected(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+
+	c, err := newSmartRecordClient(ctx, hosts[0], ClientProtocolPrefix(prefix), ClientVersions(cv...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSmartRecordServer(ctx, hosts[1], ServerProtocolPrefix(prefix), ServerVersions(sv...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, s
+}
+
+func TestProtocolIDs(t *testing.T) {
+	protocols, _ := wireProtocols(prefix, []Version{Version001, Version010}, defaultCodecs)
+	expected := []string{
+		"/test/smart-record/0.1.0/protobuf",
+		"/test/smart-record/0.1.0/cbor",
+		"/test/smart-record/0.1.0",
+		"/test/smart-record/0.0.1/protobuf",
+		"/test/smart-record/0.0.1/cbor",
+		"/test/smart-record/0.0.1",
+	}
+	if len(protocols) != len(expected) {
+		t.Fatal("wrong number of protocols", protocols)
+	}
+	for i := range protocols {
+		if string(protocols[i]) != expected[i] {
+			t.Fatalf("wrong protocol ID in position %d: %s", i, protocols[i])
+		}
+	}
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cases := []struct {
+		client   []Version
+		server   []Version
+		expected Version
+	}{
+		{supportedVersions, supportedVersions, Version040},
+		{supportedVersions, []Version{Version001}, Version001},
+		{[]Version{Version001}, supportedVersions, Version001},
+		{[]Version{Version001, Version010}, []Version{Version010}, Version010},
+	}
+
+	for _, cs := range cases {
+		c, s := setupMocknet(ctx, t, cs.client, cs.server)
+
+		k := "234"
+		if err := c.Update(ctx, k, s.host.ID(), in1, ttl); err != nil {
+			t.Fatal(err)
+		}
+		out, err := c.Get(ctx, k, s.host.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !xr.IsEqual(in1, *(*out)[c.host.ID()]) {
+			t.Fatal("end-to-end update failed", in1, *out)
+		}
+
+		w, err := c.wireForPeer(ctx, s.host.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.version != cs.expected {
+			t.Fatalf("wrong version negotiated: expected %s, got %s", cs.expected, w.version)
+		}
+	}
+}
+
+func TestNoCommonVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, s := setupMocknet(ctx, t, []Version{Version001}, []Version{Version010})
+
+	if _, err := c.Get(ctx, "234", s.host.ID()); err == nil {
+		t.Fatal("get should fail if there is no version in common")
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Updates with an empty key fail in the server.
+	k := ""
+
+	// Version 0.1.0 reports the error and keeps the stream open.
+	c, s := setupMocknet(ctx, t, supportedVersions, []Version{Version010})
+	err := c.Update(ctx, k, s.host.ID(), in1, ttl)
+	if err == nil || !strings.Contains(err.Error(), "no key was provided") {
+		t.Fatal("server error not reported to the client", err)
+	}
+	if _, err := c.Get(ctx, "234", s.host.ID()); err != nil {
+		t.Fatal("stream should be usable after an error response", err)
+	}
+
+	// Version 0.0.1 resets the stream.
+	c, s = setupMocknet(ctx, t, supportedVersions, []Version{Version001})
+	err = c.Update(ctx, k, s.host.ID(), in1, ttl)
+	if err == nil {
+		t.Fatal("update should fail")
+	}
+	if strings.Contains(err.Error(), "no key was provided") {
+		t.Fatal("version 0.0.1 shouldn't report errors", err)
+	}
+	if _, err := c.Get(ctx, "234", s.host.ID()); err != nil {
+		t.Fatal("client should open a new stream after a reset", err)
+	}
+}
+
+func TestInvalidVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSmartRecordServer(ctx, mn.Hosts()[0], ServerVersions("9.9.9")); err == nil {
+		t.Fatal("server shouldn't start with an unknown version")
+	}
+	if _, err := newSmartRecordClient(ctx, mn.Hosts()[0], ClientVersions()); err == nil {
+		t.Fatal("client shouldn't start without versions")
+	}
+}
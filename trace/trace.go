@@ -0,0 +1,69 @@
+// Package trace defines a pluggable tracer for the stages of smart record
+// requests, so they can be exported to any tracing backend (e.g. OpenTelemetry).
+//
+// The tracer is carried in the context of the request: stages started with Start
+// from a context holding a tracer are traced as children of the span in the context.
+// If the context has no tracer, spans are no-ops.
+package trace
+
+import "context"
+
+// Tracer starts spans in a tracing backend.
+type Tracer interface {
+	// Start starts a span with the name given. The span in ctx, if any, is its parent.
+	Start(ctx context.Context, name string) Span
+}
+
+// Span is a stage of a request being traced.
+type Span interface {
+	// SetAttributes sets attributes of the span as key-value pairs.
+	SetAttributes(keysAndValues ...interface{})
+	// AddEvent adds an event to the span with attributes as key-value pairs.
+	AddEvent(name string, keysAndValues ...interface{})
+	// RecordError records that the stage failed.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+type tracerKey struct{}
+type spanKey struct{}
+
+// WithTracer returns a context that traces the stages started from it with t.
+func WithTracer(ctx context.Context, t Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// Start starts a span with the tracer in ctx and returns a context holding
+// it, so stages started from the context returned are its children.
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	t, ok := ctx.Value(tracerKey{}).(Tracer)
+	if !ok {
+		return ctx, noopSpan{}
+	}
+	s := t.Start(ctx, name)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SpanFromContext returns the span in ctx, or a no-op span if there is none.
+func SpanFromContext(ctx context.Context) Span {
+	if s, ok := ctx.Value(spanKey{}).(Span); ok {
+		return s
+	}
+	return noopSpan{}
+}
+
+// End records err in the span, if not nil, and ends it.
+func End(s Span, err error) {
+	if err != nil {
+		s.RecordError(err)
+	}
+	s.End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...interface{})    {}
+func (noopSpan) AddEvent(string, ...interface{}) {}
+func (noopSpan) RecordError(error)               {}
+func (noopSpan) End()                            {}
@@ -29,40 +29,76 @@ func (v *vm) gcLoop(proc goprocess.Process) {
 }
 
 func (v *vm) garbageCollect() {
+	start := time.Now()
+	evicted := 0
 	// For each record
-	for _, r := range v.keys {
+	for k, r := range v.keys {
 		// And the datastore of each peer
 		for p, entry := range *r {
+			// Call the OnExpire hooks of the smart tags removed.
+			expired := func(n ir.Node) {
+				v.runHooks(v.hookContext(v.ctx, k, p), n, onExpire)
+			}
+			// Count nodes only if they are reported.
+			before := 0
+			if v.metrics != nil {
+				before = countNodes(entry)
+			}
 			// Run garbage collection
-			if gcDict(entry) {
+			if gcDict(entry, expired) {
 				// Delete that entry if dict for peer expired.
 				delete(*r, p)
+				evicted += before
+				v.metrics.observeSize(k, p, nil)
+			} else if v.metrics != nil {
+				// Measure the dict again only if something was removed.
+				if n := before - countNodes(entry); n > 0 {
+					evicted += n
+					v.metrics.observeSize(k, p, entry)
+				}
 			}
 		}
 	}
+	v.metrics.observeGC(start, evicted)
 }
 
-func gcNode(n ir.Node) bool {
+// gcNode garbage collects the expired children of a node and returns
+// true if the node itself can be removed. expired, if not nil, is called
+// with every node removed.
+func gcNode(n ir.Node, expired func(ir.Node)) bool {
 	switch n1 := n.(type) {
 	case *ir.Dict:
-		return gcDict(n1)
+		return gcDict(n1, expired)
 	case *ir.List:
-		return gcList(n1)
+		return gcList(n1, expired)
+	case ir.Collection:
+		return gcCollection(n1, expired)
 	default:
 		return isTTLExpired(n1)
 	}
 }
 
-func gcDict(d *ir.Dict) bool {
+func gcDict(d *ir.Dict, expired func(ir.Node)) bool {
 	// Check if we can remove Dict if all children have expired.
 	gcFlag := isTTLExpired(d)
 	// For each pair.
 	for k := len(d.Pairs) - 1; k >= 0; k-- {
 		// Check if pair has expired and garbage collect.
-		gcP := gcNode(d.Pairs[k].Key) && gcNode(d.Pairs[k].Value)
+		gcK := gcNode(d.Pairs[k].Key, expired)
+		gcP := gcK && gcNode(d.Pairs[k].Value, expired)
+		if c, ok := d.Pairs[k].Value.(ir.Collection); ok && !gcK {
+			// Collections drop their expired children even if the key is alive,
+			// as their children expire on their own.
+			gcCollection(c, expired)
+		}
 		if gcP {
 			// Remove pair if both expired
-			d.Remove(d.Pairs[k].Key)
+			p := d.Pairs[k]
+			d.Remove(p.Key)
+			if expired != nil {
+				expired(p.Key)
+				expired(p.Value)
+			}
 		}
 		// Accummulate the result for the child in dict.
 		gcFlag = gcFlag && gcP
@@ -70,16 +106,20 @@ func gcDict(d *ir.Dict) bool {
 	return gcFlag
 }
 
-func gcList(s *ir.List) bool {
+func gcList(s *ir.List, expired func(ir.Node)) bool {
 	// Check if we can remove Dict if all children have expired.
 	gcFlag := isTTLExpired(s)
 	// For each element
 	for k := len(s.Elements) - 1; k >= 0; k-- {
 		// Check if element has expired
-		gcP := gcNode(s.Elements[k])
+		gcP := gcNode(s.Elements[k], expired)
 		if gcP {
 			// Remove element if expired
+			e := s.Elements[k]
 			s.Elements = append(s.Elements[:k], s.Elements[k+1:]...)
+			if expired != nil {
+				expired(e)
+			}
 		}
 		// Accummulate the result for the child in set
 		gcFlag = gcFlag && gcP
@@ -87,6 +127,22 @@ func gcList(s *ir.List) bool {
 	return gcFlag
 }
 
+// gcCollection garbage collects the children of a collection one by one.
+// The collection can be removed once it expires, as it lives as long as
+// its latest child.
+func gcCollection(c ir.Collection, expired func(ir.Node)) bool {
+	c.Remove(func(n ir.Node) bool {
+		if !gcNode(n, expired) {
+			return false
+		}
+		if expired != nil {
+			expired(n)
+		}
+		return true
+	})
+	return isTTLExpired(c)
+}
+
 func isTTLExpired(n ir.Node) bool {
 	return uint64(time.Now().Unix()) > n.Metadata().ExpirationTime
 }
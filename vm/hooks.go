@@ -0,0 +1,125 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jbenet/goprocess"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-smart-record/ir"
+)
+
+// Selectors of the lifecycle hooks of a smart tag.
+func onAssemble(st *ir.SmartTag) ir.Hook { return st.OnAssemble }
+func onUpdate(st *ir.SmartTag) ir.Hook   { return st.OnUpdate }
+func onExpire(st *ir.SmartTag) ir.Hook   { return st.OnExpire }
+
+// hookContext returns the context of the hooks called for the dict of a writer.
+// The VM must be locked.
+func (v *vm) hookContext(ctx context.Context, k string, writer peer.ID) ir.HookContext {
+	return ir.HookContext{
+		Ctx: ctx, Host: v.host, Key: k, Writer: writer, Keys: v.asm.Keys,
+		NextSeq: func() (uint64, bool) { return v.nextSeq(k) },
+	}
+}
+
+// runHooks calls the hook selected for every node of a smart tag in n.
+func (v *vm) runHooks(hctx ir.HookContext, n ir.Node, hook func(*ir.SmartTag) ir.Hook) {
+	ir.Walk(n, func(n ir.Node) {
+		if st, ok := v.tags.SmartTagOf(n); ok {
+			if h := hook(st); h != nil {
+				h(hctx, n)
+			}
+		}
+	})
+}
+
+// startPeriodicHooks starts a loop for each smart tag with a periodic hook,
+// with the period and staleness configured for any of its tags.
+// Smart tags registered after the VM is created are not considered.
+func (v *vm) startPeriodicHooks(cfg vmConfig) error {
+	for _, overrides := range []map[string]time.Duration{cfg.hookPeriods, cfg.hookStaleness} {
+		for tag := range overrides {
+			if _, ok := v.tags.Lookup(tag); !ok {
+				return fmt.Errorf("no smart tag registered for %s", tag)
+			}
+		}
+	}
+	for _, st := range v.tags.SmartTags() {
+		if st.Periodic == nil {
+			continue
+		}
+		period, staleness := st.Period, st.Staleness
+		for _, tag := range st.Tags {
+			if p, ok := cfg.hookPeriods[tag]; ok {
+				period = p
+			}
+			if s, ok := cfg.hookStaleness[tag]; ok {
+				staleness = s
+			}
+		}
+		v.proc.Go(v.periodicLoop(st, period, staleness))
+	}
+	return nil
+}
+
+func (v *vm) periodicLoop(st *ir.SmartTag, period, staleness time.Duration) goprocess.ProcessFunc {
+	return func(proc goprocess.Process) {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				v.runPeriodic(st, staleness)
+			case <-proc.Closing():
+				return
+			}
+		}
+	}
+}
+
+// runPeriodic calls the periodic hook of a smart tag for all its nodes
+// stored that are stale. The checks returned run without the VM locked,
+// so slow checks (e.g. dials) don't block other operations, and their
+// results are applied with the VM locked again.
+func (v *vm) runPeriodic(st *ir.SmartTag, staleness time.Duration) {
+	now := time.Now()
+	var checks, applies []func()
+	v.lk.RLock()
+	for k, r := range v.keys {
+		for p, d := range *r {
+			hctx := v.hookContext(v.ctx, k, p)
+			ir.Walk(d, func(n ir.Node) {
+				if s, ok := v.tags.SmartTagOf(n); !ok || s != st {
+					return
+				}
+				checked := time.Unix(int64(n.Metadata().LastChecked), 0)
+				if now.Sub(checked) < staleness {
+					return
+				}
+				check, apply := st.Periodic(hctx, n)
+				if check != nil {
+					checks = append(checks, check)
+				}
+				if apply != nil {
+					applies = append(applies, apply)
+				}
+			})
+		}
+	}
+	v.lk.RUnlock()
+
+	for _, check := range checks {
+		check()
+	}
+	if len(applies) == 0 {
+		return
+	}
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	for _, apply := range applies {
+		apply()
+	}
+}
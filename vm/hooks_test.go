@@ -0,0 +1,367 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	p2ptestutil "github.com/libp2p/go-libp2p-netutil"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+	"github.com/libp2p/go-smart-record/ir/base"
+	meta "github.com/libp2p/go-smart-record/ir/metadata"
+)
+
+// ticket is a custom smart tag of the form ticket(id=STRING).
+type ticket struct {
+	id          string
+	metadataCtx *meta.Meta
+}
+
+func (t *ticket) Disassemble() xr.Node {
+	return xr.Predicate{
+		Tag:   "ticket",
+		Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "id"}, Value: xr.String{Value: t.id}}},
+	}
+}
+
+func (t *ticket) Metadata() meta.MetadataInfo {
+	return t.metadataCtx.Get()
+}
+
+func (t *ticket) UpdateWith(ctx ir.UpdateContext, with ir.Node) error {
+	w, ok := with.(*ticket)
+	if !ok {
+		return fmt.Errorf("cannot update with a non-ticket node")
+	}
+	t.id = w.id
+	t.metadataCtx.Update(w.metadataCtx)
+	return nil
+}
+
+type ticketAssembler struct{}
+
+func (ticketAssembler) Assemble(ctx ir.AssemblerContext, src xr.Node, metadata ...meta.Metadata) (ir.Node, error) {
+	p, ok := src.(xr.Predicate)
+	if !ok || p.Tag != "ticket" || len(p.Named) != 1 {
+		return nil, fmt.Errorf("not a ticket")
+	}
+	id, ok := p.Named[0].Value.(xr.String)
+	if !ok {
+		return nil, fmt.Errorf("ticket id must be a string")
+	}
+	m := meta.New()
+	if err := m.Apply(metadata...); err != nil {
+		return nil, err
+	}
+	return &ticket{id: id.Value, metadataCtx: m}, nil
+}
+
+// hookCalls records the calls to the hooks of a smart tag.
+type hookCalls struct {
+	lk    sync.Mutex
+	calls map[string][]ir.HookContext
+}
+
+func (c *hookCalls) hook(name string) ir.Hook {
+	return func(ctx ir.HookContext, n ir.Node) {
+		if _, ok := n.(*ticket); !ok {
+			panic("hook called with a node of another smart tag")
+		}
+		c.lk.Lock()
+		defer c.lk.Unlock()
+		c.calls[name] = append(c.calls[name], ctx)
+	}
+}
+
+// periodic records the calls to a periodic hook, recording the checks
+// run and the results applied as calls to name.check and name.apply.
+func (c *hookCalls) periodic(name string) ir.PeriodicHook {
+	hook, check, apply := c.hook(name), c.hook(name+".check"), c.hook(name+".apply")
+	return func(ctx ir.HookContext, n ir.Node) (func(), func()) {
+		hook(ctx, n)
+		return func() { check(ctx, n) }, func() { apply(ctx, n) }
+	}
+}
+
+func (c *hookCalls) get(name string) []ir.HookContext {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.calls[name]
+}
+
+func TestSmartTagHooks(t *testing.T) {
+	h := setupHost(context.Background(), t)
+	calls := &hookCalls{calls: make(map[string][]ir.HookContext)}
+	tags := base.BaseTags.Copy()
+	err := tags.RegisterSmartTag(ir.SmartTag{
+		Tags:       []string{"ticket"},
+		Assembler:  ticketAssembler{},
+		OnAssemble: calls.hook("assemble"),
+		OnUpdate:   calls.hook("update"),
+		Periodic:   calls.periodic("periodic"),
+		Period:     100 * time.Millisecond,
+		OnExpire:   calls.hook("expire"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := base.BaseTags.Lookup("ticket"); ok {
+		t.Fatal("registering in a copy should not modify the original registry")
+	}
+	v, err := newVM(context.Background(), h, ir.DefaultUpdateContext{}, tags.AssemblerContext(), gcPeriodOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+
+	in := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "t"}, Value: xr.Predicate{
+				Tag:   "ticket",
+				Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "id"}, Value: xr.String{Value: "a"}}},
+			}},
+			// Base smart tags are still verified.
+			xr.Pair{Key: xr.String{Value: "addr"}, Value: xr.Predicate{
+				Tag: "connectivity",
+				Named: xr.Pairs{xr.Pair{
+					Key:   xr.String{Value: "address"},
+					Value: xr.String{Value: "/ip4/127.0.0.1/tcp/4001/p2p/" + h.ID().String()},
+				}},
+			}},
+		},
+	}
+	if err := v.Update(context.Background(), p.ID(), k, in, meta.TTL(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"assemble", "update"} {
+		c := calls.get(name)
+		if len(c) != 1 || c[0].Key != k || c[0].Writer != p.ID() || c[0].Host != h {
+			t.Fatalf("wrong calls to %s hook: %v", name, c)
+		}
+	}
+	out := v.Get(k)[p.ID()]
+	if tag := out.Get(xr.String{Value: "addr"}).(xr.Predicate).Tag; tag != "connected" {
+		t.Fatal("reachable not verified", tag)
+	}
+
+	// The node expires and is garbage collected.
+	time.Sleep(3 * time.Second)
+	for _, name := range []string{"periodic", "periodic.check", "periodic.apply"} {
+		if len(calls.get(name)) < 2 {
+			t.Fatalf("%s hook not called", name)
+		}
+	}
+	if c := calls.get("expire"); len(c) != 1 || c[0].Key != k || c[0].Writer != p.ID() {
+		t.Fatal("wrong calls to expire hook", c)
+	}
+	if len(v.Get(k)) != 0 {
+		t.Fatal("record not garbage collected")
+	}
+}
+
+func TestReverifyReachable(t *testing.T) {
+	ctx := context.Background()
+	h := setupHost(ctx, t)
+	s := setupHost(ctx, t)
+	if _, err := newVM(ctx, h, ir.DefaultUpdateContext{}, base.BaseTags.AssemblerContext(),
+		HookPeriod("unknown", time.Second)); err == nil {
+		t.Fatal("configuring hooks of unknown tags should fail")
+	}
+	v, err := newVM(ctx, h, ir.DefaultUpdateContext{}, base.BaseTags.AssemblerContext(),
+		HookPeriod("connectivity", 100*time.Millisecond), HookStaleness("connectivity", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+
+	in := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "addr"}, Value: xr.Predicate{
+				Tag: "connectivity",
+				Named: xr.Pairs{xr.Pair{
+					Key:   xr.String{Value: "address"},
+					Value: xr.String{Value: s.Addrs()[0].String() + "/p2p/" + s.ID().String()},
+				}},
+			}},
+		},
+	}
+	if err := v.Update(ctx, p.ID(), k, in, meta.TTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	tag := func() string {
+		return v.Get(k)[p.ID()].Get(xr.String{Value: "addr"}).(xr.Predicate).Tag
+	}
+	if got := tag(); got != "notConnected" {
+		t.Fatal("wrong verification", got)
+	}
+
+	// The stored result is re-verified once the hosts are connected.
+	if err := h.Connect(ctx, peer.AddrInfo{ID: s.ID(), Addrs: s.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if got := tag(); got != "connected" {
+		t.Fatal("result not re-verified", got)
+	}
+	v.lk.RLock()
+	checked := (*v.keys[k])[p.ID()].Pairs[0].Value.Metadata().LastChecked
+	v.lk.RUnlock()
+	if checked == 0 {
+		t.Fatal("verification time not recorded")
+	}
+}
+
+func TestPeriodicHooksUnlocked(t *testing.T) {
+	h := setupHost(context.Background(), t)
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	tags := base.BaseTags.Copy()
+	err := tags.RegisterSmartTag(ir.SmartTag{
+		Tags:      []string{"ticket"},
+		Assembler: ticketAssembler{},
+		// The first check blocks until released.
+		Periodic: func(ir.HookContext, ir.Node) (func(), func()) {
+			return func() {
+				once.Do(func() {
+					close(started)
+					<-release
+				})
+			}, nil
+		},
+		Period: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := newVM(context.Background(), h, ir.DefaultUpdateContext{}, tags.AssemblerContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	defer close(release)
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+
+	in := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "t"}, Value: xr.Predicate{
+				Tag:   "ticket",
+				Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "id"}, Value: xr.String{Value: "a"}}},
+			}},
+		},
+	}
+	if err := v.Update(context.Background(), p.ID(), k, in, meta.TTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("periodic check not run")
+	}
+
+	// The record can be updated while the check is running.
+	done := make(chan error, 1)
+	go func() { done <- v.Update(context.Background(), p.ID(), k, in, meta.TTL(time.Minute)) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("update blocked by a periodic check")
+	}
+}
+
+func TestSeq(t *testing.T) {
+	h := setupHost(context.Background(), t)
+	v, err := newVM(context.Background(), h, ir.DefaultUpdateContext{}, base.BaseTags.AssemblerContext(), gcPeriodOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+	p2, _ := p2ptestutil.RandTestBogusIdentity()
+
+	msg := func(text string) xr.Dict {
+		return xr.Dict{Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "msgs"}, Value: xr.Dict{Pairs: xr.Pairs{
+				xr.Pair{Key: xr.Predicate{Tag: "seq"}, Value: xr.String{Value: text}},
+			}}},
+		}}
+	}
+	// The sequence of a key is shared by its writers.
+	for _, w := range []peer.ID{p.ID(), p2.ID(), p.ID()} {
+		if err := v.Update(context.Background(), w, k, msg("hi"), meta.TTL(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs := v.Get(k)[p.ID()].Get(xr.String{Value: "msgs"}).(xr.Dict)
+	if len(msgs.Pairs) != 2 || !xr.IsEqual(msgs.Pairs[0].Key, xr.NewInt64(1)) || !xr.IsEqual(msgs.Pairs[1].Key, xr.NewInt64(3)) {
+		t.Fatal("wrong sequence numbers", msgs)
+	}
+	msgs = v.Get(k)[p2.ID()].Get(xr.String{Value: "msgs"}).(xr.Dict)
+	if len(msgs.Pairs) != 1 || !xr.IsEqual(msgs.Pairs[0].Key, xr.NewInt64(2)) {
+		t.Fatal("wrong sequence numbers", msgs)
+	}
+	// Integers written by writers don't advance it.
+	p3, _ := p2ptestutil.RandTestBogusIdentity()
+	forged := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "msgs"}, Value: xr.Dict{Pairs: xr.Pairs{
+			xr.Pair{Key: xr.NewInt64(math.MaxInt64), Value: xr.String{Value: "hi"}},
+		}}},
+	}}
+	if err := v.Update(context.Background(), p3.ID(), k, forged, meta.TTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Merge(context.Background(), p3.ID(), k, forged, meta.TTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if v.Seq(k) != 3 {
+		t.Fatal("sequence advanced by a writer", v.Seq(k))
+	}
+	// It continues from the numbers assigned by other replicas, which
+	// must fit in an int64.
+	if err := v.AdvanceSeq(k, math.MaxUint64); err == nil {
+		t.Fatal("sequence advanced out of range")
+	}
+	if err := v.AdvanceSeq(k, 9); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.AdvanceSeq(k, 5); err != nil || v.Seq(k) != 9 {
+		t.Fatal("sequence moved back", v.Seq(k), err)
+	}
+	if err := v.Update(context.Background(), p.ID(), k, msg("hi"), meta.TTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	msgs = v.Get(k)[p.ID()].Get(xr.String{Value: "msgs"}).(xr.Dict)
+	if len(msgs.Pairs) != 3 || !xr.IsEqual(msgs.Pairs[2].Key, xr.NewInt64(10)) {
+		t.Fatal("sequence doesn't continue from the numbers of replicas", msgs)
+	}
+
+	// It is kept once the record is garbage collected.
+	k2 := "seq-gc"
+	if err := v.Update(context.Background(), p.ID(), k2, msg("hi"), meta.TTL(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(v.Get(k2)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("record not garbage collected")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := v.Update(context.Background(), p.ID(), k2, msg("hi"), meta.TTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	msgs = v.Get(k2)[p.ID()].Get(xr.String{Value: "msgs"}).(xr.Dict)
+	if len(msgs.Pairs) != 1 || !xr.IsEqual(msgs.Pairs[0].Key, xr.NewInt64(2)) {
+		t.Fatal("sequence restarted after garbage collection", msgs)
+	}
+}
@@ -3,11 +3,89 @@ package vm
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 
 	"github.com/libp2p/go-libp2p-core/peer"
 	xr "github.com/libp2p/go-routing-language/syntax"
 )
 
+// Codec determines the encoding used to serialize syntactic nodes and
+// RecordValues when they are sent through the libp2p protocol.
+type Codec int
+
+const (
+	// CodecJSON serializes nodes using the JSON representation of the routing-language.
+	CodecJSON Codec = iota
+	// CodecCBOR serializes nodes in CBOR.
+	CodecCBOR
+	// CodecProtobuf serializes nodes using the protobuf schema in vm/pb.
+	CodecProtobuf
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecJSON:
+		return "json"
+	case CodecCBOR:
+		return "cbor"
+	case CodecProtobuf:
+		return "protobuf"
+	}
+	return fmt.Sprintf("codec(%d)", int(c))
+}
+
+// MarshalRecordValueWith serializes RecordValue using the codec specified.
+func MarshalRecordValueWith(c Codec, r RecordValue) ([]byte, error) {
+	switch c {
+	case CodecJSON:
+		return MarshalRecordValue(r)
+	case CodecCBOR:
+		return MarshalRecordValueCBOR(r)
+	case CodecProtobuf:
+		return MarshalRecordValueProto(r)
+	}
+	return nil, fmt.Errorf("unknown codec %s", c)
+}
+
+// UnmarshalRecordValueWith unmarshals a RecordValue serialized with the codec specified.
+func UnmarshalRecordValueWith(c Codec, b []byte) (RecordValue, error) {
+	switch c {
+	case CodecJSON:
+		return UnmarshalRecordValue(b)
+	case CodecCBOR:
+		return UnmarshalRecordValueCBOR(b)
+	case CodecProtobuf:
+		return UnmarshalRecordValueProto(b)
+	}
+	return nil, fmt.Errorf("unknown codec %s", c)
+}
+
+// MarshalNode serializes a syntactic node using the codec specified.
+func MarshalNode(c Codec, n xr.Node) ([]byte, error) {
+	switch c {
+	case CodecJSON:
+		return xr.MarshalJSON(n)
+	case CodecCBOR:
+		return marshalNodeCBOR(n)
+	case CodecProtobuf:
+		return marshalNodeProto(n)
+	}
+	return nil, fmt.Errorf("unknown codec %s", c)
+}
+
+// UnmarshalNode unmarshals a syntactic node serialized with the codec specified.
+func UnmarshalNode(c Codec, b []byte) (xr.Node, error) {
+	switch c {
+	case CodecJSON:
+		return xr.UnmarshalJSON(b)
+	case CodecCBOR:
+		return unmarshalNodeCBOR(b)
+	case CodecProtobuf:
+		return unmarshalNodeProto(b)
+	}
+	return nil, fmt.Errorf("unknown codec %s", c)
+}
+
 // MarshalRecordValue serializes RecordValue to send it through libp2p protocol.
 func MarshalRecordValue(r RecordValue) ([]byte, error) {
 	out := make(map[string][]byte)
@@ -46,3 +124,27 @@ func UnmarshalRecordValue(b []byte) (RecordValue, error) {
 	}
 	return out, nil
 }
+
+// maxFloatPrec is the maximum precision in bits of the floats unmarshalled.
+// The precision is sent along with the value, so it is bounded to prevent
+// peers from forcing huge allocations.
+const maxFloatPrec = 1024
+
+// floatToText returns the shortest decimal representation of f that parses
+// back to the same value with the precision returned.
+func floatToText(f *big.Float) (string, uint) {
+	return f.Text('g', -1), f.Prec()
+}
+
+// floatFromText parses a float serialized with floatToText.
+// Precisions larger than maxFloatPrec are rejected.
+func floatFromText(s string, prec uint) (*big.Float, error) {
+	if prec > maxFloatPrec {
+		return nil, fmt.Errorf("float precision %d exceeds the maximum of %d bits", prec, maxFloatPrec)
+	}
+	f, _, err := big.ParseFloat(s, 10, prec, big.ToNearestEven)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing float: %s", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,239 @@
+package vm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+)
+
+// cborNode is the CBOR representation of a syntactic node. It is encoded
+// as a map where only the entry for the type of the node is set.
+type cborNode struct {
+	String    *string        `cbor:"1,keyasint,omitempty"`
+	Bytes     *[]byte        `cbor:"2,keyasint,omitempty"`
+	Bool      *bool          `cbor:"3,keyasint,omitempty"`
+	Int       *big.Int       `cbor:"4,keyasint,omitempty"`
+	Float     *cborFloat     `cbor:"5,keyasint,omitempty"`
+	Dict      *[]cborPair    `cbor:"6,keyasint,omitempty"`
+	List      *[]cborNode    `cbor:"7,keyasint,omitempty"`
+	Predicate *cborPredicate `cbor:"8,keyasint,omitempty"`
+}
+
+type cborFloat struct {
+	_     struct{} `cbor:",toarray"`
+	Value string
+	Prec  uint
+}
+
+type cborPair struct {
+	_     struct{} `cbor:",toarray"`
+	Key   cborNode
+	Value cborNode
+}
+
+type cborPredicate struct {
+	_          struct{} `cbor:",toarray"`
+	Tag        string
+	Positional []cborNode
+	Named      []cborPair
+}
+
+type cborEntry struct {
+	_      struct{} `cbor:",toarray"`
+	Writer []byte
+	Value  []cborPair
+}
+
+var cborEncMode, _ = cbor.EncOptions{BigIntConvert: cbor.BigIntConvertShortest}.EncMode()
+
+// MarshalRecordValueCBOR serializes RecordValue in CBOR.
+func MarshalRecordValueCBOR(r RecordValue) ([]byte, error) {
+	out := make([]cborEntry, 0, len(r))
+	for k, v := range r {
+		ps, err := pairsToCBOR(v.Pairs)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, cborEntry{Writer: []byte(k), Value: ps})
+	}
+	return cborEncMode.Marshal(out)
+}
+
+// UnmarshalRecordValueCBOR unmarshals a RecordValue serialized in CBOR.
+func UnmarshalRecordValueCBOR(b []byte) (RecordValue, error) {
+	var unm []cborEntry
+	if err := cbor.Unmarshal(b, &unm); err != nil {
+		return nil, err
+	}
+	out := make(map[peer.ID]*xr.Dict, len(unm))
+	for _, e := range unm {
+		pid, err := peer.IDFromBytes(e.Writer)
+		if err != nil {
+			return nil, err
+		}
+		ps, err := pairsFromCBOR(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		out[pid] = &xr.Dict{Pairs: ps}
+	}
+	return out, nil
+}
+
+func marshalNodeCBOR(n xr.Node) ([]byte, error) {
+	cn, err := nodeToCBOR(n)
+	if err != nil {
+		return nil, err
+	}
+	return cborEncMode.Marshal(cn)
+}
+
+func unmarshalNodeCBOR(b []byte) (xr.Node, error) {
+	var cn cborNode
+	if err := cbor.Unmarshal(b, &cn); err != nil {
+		return nil, err
+	}
+	return nodeFromCBOR(cn)
+}
+
+func nodeToCBOR(n xr.Node) (cborNode, error) {
+	switch n1 := n.(type) {
+	case xr.String:
+		return cborNode{String: &n1.Value}, nil
+	case xr.Bytes:
+		return cborNode{Bytes: &n1.Bytes}, nil
+	case xr.Bool:
+		return cborNode{Bool: &n1.Value}, nil
+	case xr.Int:
+		return cborNode{Int: n1.Int}, nil
+	case xr.Float:
+		v, prec := floatToText(n1.Float)
+		return cborNode{Float: &cborFloat{Value: v, Prec: prec}}, nil
+	case xr.Dict:
+		ps, err := pairsToCBOR(n1.Pairs)
+		if err != nil {
+			return cborNode{}, err
+		}
+		return cborNode{Dict: &ps}, nil
+	case *xr.Dict:
+		return nodeToCBOR(*n1)
+	case xr.List:
+		els, err := nodesToCBOR(n1.Elements)
+		if err != nil {
+			return cborNode{}, err
+		}
+		return cborNode{List: &els}, nil
+	case xr.Predicate:
+		pos, err := nodesToCBOR(n1.Positional)
+		if err != nil {
+			return cborNode{}, err
+		}
+		named, err := pairsToCBOR(n1.Named)
+		if err != nil {
+			return cborNode{}, err
+		}
+		return cborNode{Predicate: &cborPredicate{Tag: n1.Tag, Positional: pos, Named: named}}, nil
+	}
+	return cborNode{}, fmt.Errorf("unknown node type %T", n)
+}
+
+func nodesToCBOR(ns xr.Nodes) ([]cborNode, error) {
+	out := make([]cborNode, len(ns))
+	for i, n := range ns {
+		cn, err := nodeToCBOR(n)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = cn
+	}
+	return out, nil
+}
+
+func pairsToCBOR(ps xr.Pairs) ([]cborPair, error) {
+	out := make([]cborPair, len(ps))
+	for i, p := range ps {
+		k, err := nodeToCBOR(p.Key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := nodeToCBOR(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = cborPair{Key: k, Value: v}
+	}
+	return out, nil
+}
+
+func nodeFromCBOR(n cborNode) (xr.Node, error) {
+	switch {
+	case n.String != nil:
+		return xr.String{Value: *n.String}, nil
+	case n.Bytes != nil:
+		return xr.Bytes{Bytes: *n.Bytes}, nil
+	case n.Bool != nil:
+		return xr.Bool{Value: *n.Bool}, nil
+	case n.Int != nil:
+		return xr.Int{Int: n.Int}, nil
+	case n.Float != nil:
+		f, err := floatFromText(n.Float.Value, n.Float.Prec)
+		if err != nil {
+			return nil, err
+		}
+		return xr.Float{Float: f}, nil
+	case n.Dict != nil:
+		ps, err := pairsFromCBOR(*n.Dict)
+		if err != nil {
+			return nil, err
+		}
+		return xr.Dict{Pairs: ps}, nil
+	case n.List != nil:
+		els, err := nodesFromCBOR(*n.List)
+		if err != nil {
+			return nil, err
+		}
+		return xr.List{Elements: els}, nil
+	case n.Predicate != nil:
+		pos, err := nodesFromCBOR(n.Predicate.Positional)
+		if err != nil {
+			return nil, err
+		}
+		named, err := pairsFromCBOR(n.Predicate.Named)
+		if err != nil {
+			return nil, err
+		}
+		return xr.Predicate{Tag: n.Predicate.Tag, Positional: pos, Named: named}, nil
+	}
+	return nil, fmt.Errorf("cbor node has no valid type inside")
+}
+
+func nodesFromCBOR(ns []cborNode) (xr.Nodes, error) {
+	out := make(xr.Nodes, len(ns))
+	for i, n := range ns {
+		xn, err := nodeFromCBOR(n)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = xn
+	}
+	return out, nil
+}
+
+func pairsFromCBOR(ps []cborPair) (xr.Pairs, error) {
+	out := make(xr.Pairs, len(ps))
+	for i, p := range ps {
+		k, err := nodeFromCBOR(p.Key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := nodeFromCBOR(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = xr.Pair{Key: k, Value: v}
+	}
+	return out, nil
+}
@@ -0,0 +1,226 @@
+package vm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	pb "github.com/libp2p/go-smart-record/vm/pb"
+)
+
+// MarshalRecordValueProto serializes RecordValue using its protobuf schema.
+func MarshalRecordValueProto(r RecordValue) ([]byte, error) {
+	out := &pb.RecordValue{Entries: make([]*pb.RecordValue_Entry, 0, len(r))}
+	for k, v := range r {
+		d, err := dictToProto(*v)
+		if err != nil {
+			return nil, err
+		}
+		out.Entries = append(out.Entries, &pb.RecordValue_Entry{Writer: []byte(k), Value: d})
+	}
+	return out.Marshal()
+}
+
+// UnmarshalRecordValueProto unmarshals a RecordValue serialized using its protobuf schema.
+func UnmarshalRecordValueProto(b []byte) (RecordValue, error) {
+	var unm pb.RecordValue
+	if err := unm.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	out := make(map[peer.ID]*xr.Dict, len(unm.Entries))
+	for _, e := range unm.Entries {
+		pid, err := peer.IDFromBytes(e.GetWriter())
+		if err != nil {
+			return nil, err
+		}
+		d, err := dictFromProto(e.GetValue())
+		if err != nil {
+			return nil, err
+		}
+		out[pid] = &d
+	}
+	return out, nil
+}
+
+func marshalNodeProto(n xr.Node) ([]byte, error) {
+	pn, err := nodeToProto(n)
+	if err != nil {
+		return nil, err
+	}
+	return pn.Marshal()
+}
+
+func unmarshalNodeProto(b []byte) (xr.Node, error) {
+	var pn pb.Node
+	if err := pn.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	return nodeFromProto(&pn)
+}
+
+func nodeToProto(n xr.Node) (*pb.Node, error) {
+	switch n1 := n.(type) {
+	case xr.String:
+		return &pb.Node{Value: &pb.Node_String_{String_: n1.Value}}, nil
+	case xr.Bytes:
+		return &pb.Node{Value: &pb.Node_Bytes{Bytes: n1.Bytes}}, nil
+	case xr.Bool:
+		return &pb.Node{Value: &pb.Node_Bool{Bool: n1.Value}}, nil
+	case xr.Int:
+		return &pb.Node{Value: &pb.Node_Int{Int: &pb.Int{
+			Abs:      n1.Int.Bytes(),
+			Negative: n1.Int.Sign() < 0,
+		}}}, nil
+	case xr.Float:
+		v, prec := floatToText(n1.Float)
+		return &pb.Node{Value: &pb.Node_Float{Float: &pb.Float{Value: v, Prec: uint32(prec)}}}, nil
+	case xr.Dict:
+		d, err := dictToProto(n1)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Node{Value: &pb.Node_Dict{Dict: d}}, nil
+	case *xr.Dict:
+		return nodeToProto(*n1)
+	case xr.List:
+		l, err := nodesToProto(n1.Elements)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Node{Value: &pb.Node_List{List: &pb.List{Elements: l}}}, nil
+	case xr.Predicate:
+		pos, err := nodesToProto(n1.Positional)
+		if err != nil {
+			return nil, err
+		}
+		named, err := pairsToProto(n1.Named)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Node{Value: &pb.Node_Predicate{Predicate: &pb.Predicate{
+			Tag:        n1.Tag,
+			Positional: pos,
+			Named:      named,
+		}}}, nil
+	}
+	return nil, fmt.Errorf("unknown node type %T", n)
+}
+
+func nodesToProto(ns xr.Nodes) ([]*pb.Node, error) {
+	out := make([]*pb.Node, len(ns))
+	for i, n := range ns {
+		pn, err := nodeToProto(n)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = pn
+	}
+	return out, nil
+}
+
+func pairsToProto(ps xr.Pairs) ([]*pb.Pair, error) {
+	out := make([]*pb.Pair, len(ps))
+	for i, p := range ps {
+		k, err := nodeToProto(p.Key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := nodeToProto(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = &pb.Pair{Key: k, Value: v}
+	}
+	return out, nil
+}
+
+func dictToProto(d xr.Dict) (*pb.Dict, error) {
+	ps, err := pairsToProto(d.Pairs)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Dict{Pairs: ps}, nil
+}
+
+func nodeFromProto(n *pb.Node) (xr.Node, error) {
+	switch n1 := n.GetValue().(type) {
+	case *pb.Node_String_:
+		return xr.String{Value: n1.String_}, nil
+	case *pb.Node_Bytes:
+		return xr.Bytes{Bytes: n1.Bytes}, nil
+	case *pb.Node_Bool:
+		return xr.Bool{Value: n1.Bool}, nil
+	case *pb.Node_Int:
+		i := new(big.Int).SetBytes(n1.Int.GetAbs())
+		if n1.Int.GetNegative() {
+			i.Neg(i)
+		}
+		return xr.Int{Int: i}, nil
+	case *pb.Node_Float:
+		f, err := floatFromText(n1.Float.GetValue(), uint(n1.Float.GetPrec()))
+		if err != nil {
+			return nil, err
+		}
+		return xr.Float{Float: f}, nil
+	case *pb.Node_Dict:
+		return dictFromProto(n1.Dict)
+	case *pb.Node_List:
+		els, err := nodesFromProto(n1.List.GetElements())
+		if err != nil {
+			return nil, err
+		}
+		return xr.List{Elements: els}, nil
+	case *pb.Node_Predicate:
+		pos, err := nodesFromProto(n1.Predicate.GetPositional())
+		if err != nil {
+			return nil, err
+		}
+		named, err := pairsFromProto(n1.Predicate.GetNamed())
+		if err != nil {
+			return nil, err
+		}
+		return xr.Predicate{Tag: n1.Predicate.GetTag(), Positional: pos, Named: named}, nil
+	}
+	return nil, fmt.Errorf("protobuf node has no valid type inside")
+}
+
+func nodesFromProto(ns []*pb.Node) (xr.Nodes, error) {
+	out := make(xr.Nodes, len(ns))
+	for i, n := range ns {
+		xn, err := nodeFromProto(n)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = xn
+	}
+	return out, nil
+}
+
+func pairsFromProto(ps []*pb.Pair) (xr.Pairs, error) {
+	out := make(xr.Pairs, len(ps))
+	for i, p := range ps {
+		if p.GetKey() == nil || p.GetValue() == nil {
+			return nil, fmt.Errorf("protobuf pair with missing key or value")
+		}
+		k, err := nodeFromProto(p.GetKey())
+		if err != nil {
+			return nil, err
+		}
+		v, err := nodeFromProto(p.GetValue())
+		if err != nil {
+			return nil, err
+		}
+		out[i] = xr.Pair{Key: k, Value: v}
+	}
+	return out, nil
+}
+
+func dictFromProto(d *pb.Dict) (xr.Dict, error) {
+	ps, err := pairsFromProto(d.GetPairs())
+	if err != nil {
+		return xr.Dict{}, err
+	}
+	return xr.Dict{Pairs: ps}, nil
+}
@@ -0,0 +1,172 @@
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	p2ptestutil "github.com/libp2p/go-libp2p-netutil"
+	xr "github.com/libp2p/go-routing-language/syntax"
+)
+
+var codecs = []Codec{CodecJSON, CodecCBOR, CodecProtobuf}
+
+func bigInt(s string) xr.Int {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("wrong big int")
+	}
+	return xr.Int{Int: i}
+}
+
+func bigFloat(s string, prec uint) xr.Float {
+	f, _, err := big.ParseFloat(s, 10, prec, big.ToNearestEven)
+	if err != nil {
+		panic(err)
+	}
+	return xr.Float{Float: f}
+}
+
+var allNodes = xr.Dict{
+	Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "string"}, Value: xr.String{Value: "234"}},
+		xr.Pair{Key: xr.String{Value: "empty"}, Value: xr.String{Value: ""}},
+		xr.Pair{Key: xr.String{Value: "bytes"}, Value: xr.Bytes{Bytes: []byte("asdf")}},
+		xr.Pair{Key: xr.String{Value: "bool"}, Value: xr.Bool{Value: true}},
+		xr.Pair{Key: xr.String{Value: "false"}, Value: xr.Bool{Value: false}},
+		xr.Pair{Key: xr.String{Value: "int"}, Value: xr.NewInt64(-42)},
+		xr.Pair{Key: xr.String{Value: "float"}, Value: xr.Float{Float: big.NewFloat(1.5)}},
+		xr.Pair{Key: xr.String{Value: "list"}, Value: xr.List{Elements: xr.Nodes{
+			xr.String{Value: "a"}, xr.NewInt64(1),
+		}}},
+		xr.Pair{Key: xr.String{Value: "dict"}, Value: xr.Dict{Pairs: xr.Pairs{
+			xr.Pair{Key: xr.NewInt64(1), Value: xr.String{Value: "one"}},
+		}}},
+		xr.Pair{Key: xr.String{Value: "predicate"}, Value: xr.Predicate{
+			Tag:        "multiaddr",
+			Positional: xr.Nodes{xr.String{Value: "/ip4/multiaddr1"}},
+			Named: xr.Pairs{
+				xr.Pair{Key: xr.String{Value: "address"}, Value: xr.String{Value: "/ip4/multiaddr2"}},
+			},
+		}},
+	},
+}
+
+// Big numbers are lossy in the routing-language JSON encoding, so
+// they are only checked for binary codecs.
+var bigNodes = xr.Dict{
+	Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "bigInt"}, Value: bigInt("123456789012345678901234567890")},
+		xr.Pair{Key: xr.String{Value: "negBigInt"}, Value: bigInt("-98765432109876543210987654321")},
+		xr.Pair{Key: xr.String{Value: "bigFloat"}, Value: bigFloat("3.14159265358979323846264338327950288419716939937510", 256)},
+		xr.Pair{Key: xr.String{Value: "smallFloat"}, Value: bigFloat("-1e-300", 1024)},
+		xr.Pair{Key: bigInt("-18446744073709551617"), Value: bigFloat("1e+1000", 128)},
+	},
+}
+
+func TestMarshalNode(t *testing.T) {
+	for _, c := range codecs {
+		b, err := MarshalNode(c, allNodes)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		n, err := UnmarshalNode(c, b)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if !xr.IsEqual(allNodes, n) {
+			t.Fatalf("%s: node not unmarshalled successfully: %v", c, n)
+		}
+	}
+}
+
+func TestMarshalNodeBigNumbers(t *testing.T) {
+	for _, c := range []Codec{CodecCBOR, CodecProtobuf} {
+		b, err := MarshalNode(c, bigNodes)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		n, err := UnmarshalNode(c, b)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if !xr.IsEqual(bigNodes, n) {
+			t.Fatalf("%s: big numbers not unmarshalled successfully: %v", c, n)
+		}
+		// Check that precision was also kept.
+		f := n.(xr.Dict).Get(xr.String{Value: "bigFloat"}).(xr.Float)
+		if f.Prec() != 256 {
+			t.Fatalf("%s: float precision not kept: %d", c, f.Prec())
+		}
+	}
+}
+
+func TestUnmarshalFloatPrecision(t *testing.T) {
+	if _, err := floatFromText("1.5", maxFloatPrec); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := floatFromText("1.5", 1<<32); err == nil {
+		t.Fatal("float with a huge precision should have been rejected")
+	}
+	// Unmarshalling fails with every binary codec.
+	n := xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "f"}, Value: bigFloat("1.5", 2*maxFloatPrec)}}}
+	for _, c := range []Codec{CodecCBOR, CodecProtobuf} {
+		b, err := MarshalNode(c, n)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if _, err := UnmarshalNode(c, b); err == nil {
+			t.Fatalf("%s: float with a huge precision should have been rejected", c)
+		}
+	}
+}
+
+func TestMarshalRecordValue(t *testing.T) {
+	p1, _ := p2ptestutil.RandTestBogusIdentity()
+	p2, _ := p2ptestutil.RandTestBogusIdentity()
+	r := RecordValue{p1.ID(): &allNodes, p2.ID(): &allNodes}
+
+	for _, c := range codecs {
+		b, err := MarshalRecordValueWith(c, r)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		out, err := UnmarshalRecordValueWith(c, b)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if len(out) != 2 || !xr.IsEqual(allNodes, *out[p1.ID()]) || !xr.IsEqual(allNodes, *out[p2.ID()]) {
+			t.Fatalf("%s: record value not unmarshalled successfully: %v", c, out)
+		}
+	}
+
+	for _, c := range []Codec{CodecCBOR, CodecProtobuf} {
+		r := RecordValue{p1.ID(): &bigNodes}
+		b, err := MarshalRecordValueWith(c, r)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		out, err := UnmarshalRecordValueWith(c, b)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if !xr.IsEqual(bigNodes, *out[p1.ID()]) {
+			t.Fatalf("%s: record value not unmarshalled successfully: %v", c, out)
+		}
+	}
+}
+
+func TestMarshalEmptyRecordValue(t *testing.T) {
+	for _, c := range codecs {
+		b, err := MarshalRecordValueWith(c, RecordValue{})
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		out, err := UnmarshalRecordValueWith(c, b)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if len(out) != 0 {
+			t.Fatalf("%s: empty record value not unmarshalled successfully: %v", c, out)
+		}
+	}
+}
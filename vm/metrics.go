@@ -0,0 +1,219 @@
+package vm
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/libp2p/go-smart-record/ir"
+	"github.com/libp2p/go-smart-record/ir/base"
+)
+
+// metricsNamespace is the namespace of every smart record metric.
+const metricsNamespace = "smart_record"
+
+var (
+	keysDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "vm", "keys"),
+		"Number of keys with records stored.", nil, nil)
+	writersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "vm", "writers_per_key"),
+		"Number of writers with a dict stored in each key.", nil, nil)
+	storedBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "vm", "stored_bytes"),
+		"Size of the records stored, serialized with CBOR when they are updated or garbage collected.", nil, nil)
+
+	writersBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128}
+)
+
+// vmMetrics are the metrics of a VM. A nil vmMetrics records nothing.
+type vmMetrics struct {
+	gcRuns            prometheus.Counter
+	gcDuration        prometheus.Histogram
+	gcEvicted         prometheus.Counter
+	assemblyFailures  prometheus.Counter
+	reachableOutcomes *prometheus.CounterVec
+
+	// Size of the dict stored by each writer in each key, and their sum,
+	// kept up to date with the VM locked so collecting them is cheap.
+	sizes       map[string]map[peer.ID]int
+	storedBytes int
+
+	reg        prometheus.Registerer
+	registered []prometheus.Collector
+}
+
+// newVMMetrics registers the metrics of a VM, including the ones
+// describing its state, which are computed when collected.
+func newVMMetrics(v *vm, reg prometheus.Registerer) (*vmMetrics, error) {
+	m := &vmMetrics{
+		gcRuns: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "vm", Name: "gc_runs_total",
+			Help: "Number of garbage collections run.",
+		}),
+		gcDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace, Subsystem: "vm", Name: "gc_duration_seconds",
+			Help:    "Duration of garbage collections.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}),
+		gcEvicted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "vm", Name: "gc_evicted_nodes_total",
+			Help: "Number of expired nodes removed by garbage collection.",
+		}),
+		assemblyFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "vm", Name: "assembly_failures_total",
+			Help: "Number of updates that failed to assemble.",
+		}),
+		reachableOutcomes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "vm", Name: "reachable_checks_total",
+			Help: "Number of reachability checks by outcome.",
+		}, []string{"outcome"}),
+		sizes: make(map[string]map[peer.ID]int),
+	}
+	var registered []prometheus.Collector
+	for _, c := range []prometheus.Collector{
+		m.gcRuns, m.gcDuration, m.gcEvicted, m.assemblyFailures, m.reachableOutcomes, stateCollector{v, m},
+	} {
+		if err := reg.Register(c); err != nil {
+			// Don't leave the metrics registered so far behind.
+			for _, r := range registered {
+				reg.Unregister(r)
+			}
+			return nil, err
+		}
+		registered = append(registered, c)
+	}
+	m.reg, m.registered = reg, registered
+	return m, nil
+}
+
+// unregister removes the metrics from the registry they were registered in.
+func (m *vmMetrics) unregister() {
+	if m == nil {
+		return
+	}
+	for _, c := range m.registered {
+		m.reg.Unregister(c)
+	}
+}
+
+// observeSize records the size of the dict stored by a writer in a key,
+// or that it was removed if nil. The VM must be locked.
+func (m *vmMetrics) observeSize(k string, writer peer.ID, d *ir.Dict) {
+	if m == nil {
+		return
+	}
+	m.storedBytes -= m.sizes[k][writer]
+	if d == nil {
+		delete(m.sizes[k], writer)
+		if len(m.sizes[k]) == 0 {
+			delete(m.sizes, k)
+		}
+		return
+	}
+	size := 0
+	if b, err := marshalNodeCBOR(d.Disassemble()); err == nil {
+		size = len(b)
+	}
+	if m.sizes[k] == nil {
+		m.sizes[k] = make(map[peer.ID]int)
+	}
+	m.sizes[k][writer] = size
+	m.storedBytes += size
+}
+
+func (m *vmMetrics) observeGC(start time.Time, evicted int) {
+	if m == nil {
+		return
+	}
+	m.gcRuns.Inc()
+	m.gcDuration.Observe(time.Since(start).Seconds())
+	m.gcEvicted.Add(float64(evicted))
+}
+
+func (m *vmMetrics) observeAssemblyFailure() {
+	if m == nil {
+		return
+	}
+	m.assemblyFailures.Inc()
+}
+
+// observeReachable counts the outcome of the reachability checks
+// triggered in a dict, given by the tag of the disassembled node.
+func (m *vmMetrics) observeReachable(n ir.Node) {
+	if m == nil {
+		return
+	}
+	switch n1 := n.(type) {
+	case *base.Reachable:
+		if p, ok := n1.Disassemble().(xr.Predicate); ok {
+			m.reachableOutcomes.WithLabelValues(p.Tag).Inc()
+		}
+	case *ir.Dict:
+		for _, p := range n1.Pairs {
+			m.observeReachable(p.Key)
+			m.observeReachable(p.Value)
+		}
+	case *ir.List:
+		for _, e := range n1.Elements {
+			m.observeReachable(e)
+		}
+	}
+}
+
+// stateCollector collects the metrics describing the records stored in a VM.
+type stateCollector struct {
+	v *vm
+	m *vmMetrics
+}
+
+func (c stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keysDesc
+	ch <- writersDesc
+	ch <- storedBytesDesc
+}
+
+func (c stateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.v.lk.RLock()
+	defer c.v.lk.RUnlock()
+
+	keys := 0
+	buckets := make(map[float64]uint64, len(writersBuckets))
+	var writers uint64
+	for _, r := range c.v.keys {
+		if len(*r) == 0 {
+			continue
+		}
+		keys++
+		writers += uint64(len(*r))
+		for _, b := range writersBuckets {
+			if float64(len(*r)) <= b {
+				buckets[b]++
+			}
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(keysDesc, prometheus.GaugeValue, float64(keys))
+	ch <- prometheus.MustNewConstHistogram(writersDesc, uint64(keys), float64(writers), buckets)
+	ch <- prometheus.MustNewConstMetric(storedBytesDesc, prometheus.GaugeValue, float64(c.m.storedBytes))
+}
+
+// countNodes returns the number of nodes in a semantic tree.
+func countNodes(n ir.Node) int {
+	switch n1 := n.(type) {
+	case *ir.Dict:
+		c := 1
+		for _, p := range n1.Pairs {
+			c += countNodes(p.Key) + countNodes(p.Value)
+		}
+		return c
+	case *ir.List:
+		c := 1
+		for _, e := range n1.Elements {
+			c += countNodes(e)
+		}
+		return c
+	}
+	return 1
+}
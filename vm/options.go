@@ -3,6 +3,8 @@ package vm
 import (
 	"fmt"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Protocol ID
@@ -14,6 +16,10 @@ const (
 // Options is a structure containing all the options for VM
 type vmConfig struct {
 	gcPeriod time.Duration
+	registry prometheus.Registerer
+	// Overrides of the period and staleness of the periodic hooks of smart tags, by tag.
+	hookPeriods   map[string]time.Duration
+	hookStaleness map[string]time.Duration
 }
 
 // Option type
@@ -23,6 +29,8 @@ type VMOption func(*vmConfig) error
 // prepended to any options you pass to the constructor.
 var defaults = func(o *vmConfig) error {
 	o.gcPeriod = gcPeriod
+	o.hookPeriods = make(map[string]time.Duration)
+	o.hookStaleness = make(map[string]time.Duration)
 	return nil
 }
 
@@ -43,3 +51,36 @@ func GCPeriod(p time.Duration) VMOption {
 		return nil
 	}
 }
+
+// Metrics registers the metrics of the VM in a registry. Metrics are disabled by default.
+func Metrics(reg prometheus.Registerer) VMOption {
+	return func(c *vmConfig) error {
+		c.registry = reg
+		return nil
+	}
+}
+
+// HookPeriod configures the period of the periodic hook of the smart tag
+// registered for a tag (e.g. the re-verification of Reachable smart tags).
+func HookPeriod(tag string, p time.Duration) VMOption {
+	return func(c *vmConfig) error {
+		if p <= 0 {
+			return fmt.Errorf("hook period must be positive")
+		}
+		c.hookPeriods[tag] = p
+		return nil
+	}
+}
+
+// HookStaleness configures the staleness bound of the nodes of the smart tag
+// registered for a tag: its periodic hook is only called for nodes last checked
+// more than the bound ago.
+func HookStaleness(tag string, s time.Duration) VMOption {
+	return func(c *vmConfig) error {
+		if s < 0 {
+			return fmt.Errorf("hook staleness must not be negative")
+		}
+		c.hookStaleness[tag] = s
+		return nil
+	}
+}
@@ -0,0 +1,238 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	xr "github.com/libp2p/go-routing-language/syntax"
+
+	"github.com/libp2p/go-smart-record/ir"
+)
+
+// Fragment is a part of the dict stored by a writer in which every
+// node expires at the same time. Merging every fragment of a writer
+// with its expiration time reproduces the dict stored by the writer.
+type Fragment struct {
+	Dict       xr.Dict
+	Expiration uint64
+}
+
+// Keys returns the keys with records stored in the VM.
+func (v *vm) Keys() []string {
+	v.lk.RLock()
+	defer v.lk.RUnlock()
+	out := make([]string, 0, len(v.keys))
+	for k, r := range v.keys {
+		if len(*r) > 0 {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Snapshot returns the fragments of the dict stored by each writer
+// in a key, sorted by expiration time.
+func (v *vm) Snapshot(k string) map[peer.ID][]Fragment {
+	v.lk.RLock()
+	defer v.lk.RUnlock()
+	return v.snapshot(k)
+}
+
+func (v *vm) snapshot(k string) map[peer.ID][]Fragment {
+	out := make(map[peer.ID][]Fragment)
+	if v.keys[k] == nil {
+		return out
+	}
+	for p, d := range *v.keys[k] {
+		fs := fragmentNode(d)
+		frags := make([]Fragment, 0, len(fs))
+		for exp, n := range fs {
+			frags = append(frags, Fragment{Dict: n.(xr.Dict), Expiration: exp})
+		}
+		sort.Slice(frags, func(i, j int) bool {
+			return frags[i].Expiration < frags[j].Expiration
+		})
+		out[p] = frags
+	}
+	return out
+}
+
+// Digest summarizes the dict stored by a writer in a key, so replicas
+// can find the dicts that differ without exchanging them.
+type Digest struct {
+	Hash       []byte // SHA-256 of the canonical form of the fragments of the dict.
+	Expiration uint64 // Latest expiration time of the fragments.
+}
+
+// Digest returns the digest of the dict stored by each writer in a key.
+// Dicts with the same nodes and expiration times have the same digest,
+// regardless of the order in which they were updated.
+func (v *vm) Digest(k string) (map[peer.ID]Digest, error) {
+	v.lk.RLock()
+	defer v.lk.RUnlock()
+	out := make(map[peer.ID]Digest)
+	for p, frags := range v.snapshot(k) {
+		h := sha256.New()
+		var exp uint64
+		for _, f := range frags {
+			b, err := marshalNodeCBOR(canonicalNode(f.Dict))
+			if err != nil {
+				return nil, err
+			}
+			var eb [8]byte
+			binary.BigEndian.PutUint64(eb[:], f.Expiration)
+			h.Write(eb[:])
+			h.Write(b)
+			exp = maxExpiration(exp, f.Expiration)
+		}
+		out[p] = Digest{Hash: h.Sum(nil), Expiration: exp}
+	}
+	return out, nil
+}
+
+// canonicalNode sorts the pairs of dicts and the elements of lists
+// by their encoding so equal nodes have the same encoding.
+func canonicalNode(n xr.Node) xr.Node {
+	switch n1 := n.(type) {
+	case xr.Dict:
+		ps := make(xr.Pairs, len(n1.Pairs))
+		for i, p := range n1.Pairs {
+			ps[i] = xr.Pair{Key: canonicalNode(p.Key), Value: canonicalNode(p.Value)}
+		}
+		sortPairs(ps)
+		return xr.Dict{Pairs: ps}
+	case xr.List:
+		els := make(xr.Nodes, len(n1.Elements))
+		for i, e := range n1.Elements {
+			els[i] = canonicalNode(e)
+		}
+		sort.SliceStable(els, func(i, j int) bool {
+			return bytes.Compare(nodeKey(els[i]), nodeKey(els[j])) < 0
+		})
+		return xr.List{Elements: els}
+	case xr.Predicate:
+		pos := make(xr.Nodes, len(n1.Positional))
+		for i, e := range n1.Positional {
+			pos[i] = canonicalNode(e)
+		}
+		named := make(xr.Pairs, len(n1.Named))
+		for i, p := range n1.Named {
+			named[i] = xr.Pair{Key: canonicalNode(p.Key), Value: canonicalNode(p.Value)}
+		}
+		sortPairs(named)
+		return xr.Predicate{Tag: n1.Tag, Positional: pos, Named: named}
+	}
+	return n
+}
+
+func sortPairs(ps xr.Pairs) {
+	sort.SliceStable(ps, func(i, j int) bool {
+		return bytes.Compare(nodeKey(ps[i].Key), nodeKey(ps[j].Key)) < 0
+	})
+}
+
+// nodeKey returns the encoding of a node used to sort canonical nodes.
+func nodeKey(n xr.Node) []byte {
+	b, _ := marshalNodeCBOR(n)
+	return b
+}
+
+// fragmentNode splits a node into syntactic nodes with the same
+// expiration time. Dicts are split pair by pair and a pair with a
+// leaf value lives as long as its key or value, like in the garbage
+// collector. Collections are split child by child (see ir.Collection).
+// Elements of lists and any other node are kept whole, and measured
+// nodes (see ir.Measured) are kept without their measured values.
+func fragmentNode(n ir.Node) map[uint64]xr.Node {
+	out := make(map[uint64]xr.Node)
+	switch n1 := n.(type) {
+	case *ir.Dict:
+		out[n1.Metadata().ExpirationTime] = xr.Dict{Pairs: xr.Pairs{}}
+		for _, p := range n1.Pairs {
+			k := p.Key.Disassemble()
+			kexp := p.Key.Metadata().ExpirationTime
+			switch p.Value.(type) {
+			case *ir.Dict, *ir.List, ir.Collection:
+				for exp, f := range fragmentNode(p.Value) {
+					if exp == p.Value.Metadata().ExpirationTime && kexp > exp {
+						// Keep the container alive as long as its key.
+						exp = kexp
+					}
+					addPair(out, exp, k, f)
+				}
+			default:
+				addPair(out, maxExpiration(kexp, p.Value.Metadata().ExpirationTime), k, fragmentLeaf(p.Value))
+			}
+		}
+	case *ir.List:
+		out[n1.Metadata().ExpirationTime] = xr.List{Elements: xr.Nodes{}}
+		for _, e := range n1.Elements {
+			exp := e.Metadata().ExpirationTime
+			l, _ := out[exp].(xr.List)
+			l.Elements = append(l.Elements, fragmentLeaf(e))
+			out[exp] = l
+		}
+	case ir.Collection:
+		for exp, c := range n1.Split() {
+			out[exp] = c.Disassemble()
+		}
+	default:
+		out[n.Metadata().ExpirationTime] = fragmentLeaf(n)
+	}
+	return out
+}
+
+// fragmentLeaf disassembles a node kept whole in a fragment.
+func fragmentLeaf(n ir.Node) xr.Node {
+	if m, ok := n.(ir.Measured); ok {
+		return m.Unmeasured()
+	}
+	return n.Disassemble()
+}
+
+// addPair adds a pair to the dict fragment with the expiration time specified.
+// If the key is already in the fragment, their values are merged.
+func addPair(fs map[uint64]xr.Node, exp uint64, k, v xr.Node) {
+	d, _ := fs[exp].(xr.Dict)
+	for i, p := range d.Pairs {
+		if xr.IsEqual(p.Key, k) {
+			d.Pairs[i].Value = mergeFragments(p.Value, v)
+			fs[exp] = d
+			return
+		}
+	}
+	d.Pairs = append(d.Pairs, xr.Pair{Key: k, Value: v})
+	fs[exp] = d
+}
+
+// mergeFragments merges two fragments of the same container.
+func mergeFragments(x, y xr.Node) xr.Node {
+	switch x1 := x.(type) {
+	case xr.Dict:
+		if y1, ok := y.(xr.Dict); ok {
+			for _, p := range y1.Pairs {
+				fs := map[uint64]xr.Node{0: x1}
+				addPair(fs, 0, p.Key, p.Value)
+				x1 = fs[0].(xr.Dict)
+			}
+			return x1
+		}
+	case xr.List:
+		if y1, ok := y.(xr.List); ok {
+			x1.Elements = append(x1.Elements, y1.Elements...)
+			return x1
+		}
+	}
+	return y
+}
+
+func maxExpiration(x, y uint64) uint64 {
+	if x > y {
+		return x
+	}
+	return y
+}
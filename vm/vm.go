@@ -3,6 +3,7 @@ package vm
 import (
 	"context"
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/libp2p/go-smart-record/ir"
 	"github.com/libp2p/go-smart-record/ir/base"
 	meta "github.com/libp2p/go-smart-record/ir/metadata"
+	"github.com/libp2p/go-smart-record/trace"
 )
 
 // RecordEntry determines the structure of data stored in a record.
@@ -27,8 +29,15 @@ type RecordValue map[peer.ID]*xr.Dict
 
 // Machine captures the public interface of a smart record virtual machine.
 type Machine interface {
-	Update(writer peer.ID, k string, update xr.Dict, metadata ...meta.Metadata) error // Updates the dictionary in the writer's private space.
-	Get(k string) RecordValue                                                         // Get the full Record in a key
+	Update(ctx context.Context, writer peer.ID, k string, update xr.Dict, metadata ...meta.Metadata) error // Updates the dictionary in the writer's private space.
+	Get(k string) RecordValue                                                                              // Get the full Record in a key
+	Merge(ctx context.Context, writer peer.ID, k string, update xr.Dict, metadata ...meta.Metadata) error  // Merges the dictionary in the writer's private space keeping the latest values.
+	Keys() []string                                                                                        // List the keys with records stored.
+	Snapshot(k string) map[peer.ID][]Fragment                                                              // Get the fragments of every dict stored in a key.
+	Digest(k string) (map[peer.ID]Digest, error)                                                           // Get the digest of every dict stored in a key.
+	GetWithExpirations(k string) (RecordValue, map[peer.ID]Expiration)                                     // Get the full Record in a key along with the expiration of the dict of each writer.
+	Seq(k string) uint64                                                                                   // Get the last number of the sequence of a key assigned to seq() nodes.
+	AdvanceSeq(k string, n uint64) error                                                                   // Advance the sequence of a key to a number assigned by a replica.
 	// NOTE: No query operation will be supported until we figure out selectors
 	// Query(key string, selector Selector) (RecordValue, error)
 	Close() error
@@ -45,6 +54,11 @@ type vm struct {
 	// ds  ds.Datastore    // TODO: Add a datastore instead of using map[string] for the VM state
 	keys map[string]*recordEntry // State of the VM storing the map of records.
 	asm  ir.AssemblerContext     // Assemble to use in the VM.
+	tags *ir.TagRegistry         // Smart tags whose lifecycle hooks are called by the VM.
+	// Last number of the sequence of each key assigned to seq() nodes. It is
+	// kept after the record of the key is garbage collected so numbers are
+	// never reused.
+	seqs map[string]uint64
 
 	// NOTE: When performance matters in the future, implement incremental garbage collection,
 	// which runs on every operation and uses a priority queue to know (in O(1) time)
@@ -52,6 +66,8 @@ type vm struct {
 	// (When there are bursts of uneven traffic, no choice of garbage collection interval helps.)
 	// We can add it in a GCType option.
 	gcPeriod time.Duration // Period of the gc process
+
+	metrics *vmMetrics // Nil if metrics are disabled.
 }
 
 // NewVM creates a new smart record Machine
@@ -70,8 +86,21 @@ func newVM(ctx context.Context, h host.Host, updateCtx ir.UpdateContext, asm ir.
 		host:      h,
 		updateCtx: updateCtx,
 		keys:      make(map[string]*recordEntry),
+		seqs:      make(map[string]uint64),
 		asm:       asm,
 		gcPeriod:  cfg.gcPeriod,
+		tags:      asm.Tags,
+	}
+	// Call the hooks of the base smart tags if no registry is given.
+	if v.tags == nil {
+		v.tags = base.BaseTags
+	}
+	if cfg.registry != nil {
+		m, err := newVMMetrics(v, cfg.registry)
+		if err != nil {
+			return nil, err
+		}
+		v.metrics = m
 	}
 
 	// Initialize process so routines are ended with context
@@ -79,13 +108,27 @@ func newVM(ctx context.Context, h host.Host, updateCtx ir.UpdateContext, asm ir.
 	// Start garbage collection process
 	// NOTE: Add an option for gcType?
 	v.proc.Go(v.gcLoop)
+	if err := v.startPeriodicHooks(cfg); err != nil {
+		v.Close()
+		return nil, err
+	}
 	return v, nil
 }
 
+// Expiration is the expiration time of the dict stored by a writer in a key.
+type Expiration struct {
+	Latest   uint64 // Expiration of the dict as a whole.
+	Earliest uint64 // Expiration of the first node of the dict that expires.
+}
+
 // Get the whole record stored in a key
 func (v *vm) Get(k string) RecordValue {
 	v.lk.RLock()
 	defer v.lk.RUnlock()
+	return v.get(k)
+}
+
+func (v *vm) get(k string) RecordValue {
 	// If nothing in key
 	if v.keys[k] == nil {
 		return RecordValue{}
@@ -105,36 +148,50 @@ func (v *vm) Get(k string) RecordValue {
 	return out
 }
 
+// GetWithExpirations gets the whole record stored in a key along with the
+// expiration of the dict of each writer, read at the same time so they
+// always match.
+func (v *vm) GetWithExpirations(k string) (RecordValue, map[peer.ID]Expiration) {
+	v.lk.RLock()
+	defer v.lk.RUnlock()
+	out := v.get(k)
+	exps := make(map[peer.ID]Expiration, len(out))
+	for p := range out {
+		d := (*v.keys[k])[p]
+		exp := Expiration{Latest: d.Metadata().ExpirationTime}
+		// The earliest expiration is the one of the first fragment of the dict.
+		for e := range fragmentNode(d) {
+			if exp.Earliest == 0 || e < exp.Earliest {
+				exp.Earliest = e
+			}
+		}
+		exps[p] = exp
+	}
+	return out, exps
+}
+
 // Update the dictionary in the writer's private space
 // NOTE: We currently store an assembled version of the record.
 // We may need to disassemble and serialize before storage
 // if we choose to use a datastore for persistance.
-func (v *vm) Update(writer peer.ID, k string, update xr.Dict, metadata ...meta.Metadata) error {
+func (v *vm) Update(ctx context.Context, writer peer.ID, k string, update xr.Dict, metadata ...meta.Metadata) (err error) {
+	ctx, span := trace.Start(ctx, "vm.Update")
+	span.SetAttributes("key", k, "writer", writer.String())
+	defer func() { trace.End(span, err) }()
+
 	v.lk.Lock()
 	defer v.lk.Unlock()
 
-	// Start assemble process with the parent VM assemblerContext
-	ds, err := v.asm.Grammar.Assemble(v.asm, update, metadata...)
+	asm := v.asm
+	asm.Exists = v.exists
+	d, err := v.assemble(ctx, asm, writer, k, update, metadata...)
 	if err != nil {
 		return err
 	}
 
-	// Check if the result of the assembler is of type Dict
-	d, ok := ds.(*ir.Dict)
-	if !ok {
-		return fmt.Errorf("assembler didn't generate a dict")
-	}
-
-	// Trigger reachibility verifications.
-	// NOTE: What about considering once we have more than one smart tag
-	// a general method to define the lifecycle of smart tags
-	// and when to trigger them?
-	base.TriggerReachable(d, v.host)
-
 	// Directly store d if there is nothing in the key
 	if v.keys[k] == nil {
 		v.keys[k] = &recordEntry{writer: d}
-		return nil
 	} else {
 		// If no data in peer
 		if (*v.keys[k])[writer] == nil {
@@ -142,16 +199,133 @@ func (v *vm) Update(writer peer.ID, k string, update xr.Dict, metadata ...meta.M
 		} else {
 			// Update existing dict with the stored one if there's already
 			// something in the peer's key
+			_, mspan := trace.Start(ctx, "vm.merge")
 			err := ir.Update(v.ctx, (*v.keys[k])[writer], d)
+			trace.End(mspan, err)
 			if err != nil {
-				return nil
+				return err
 			}
 		}
-		return nil
 	}
+	v.updated(ctx, writer, k)
+	return nil
+}
+
+// Merge the dictionary in the writer's private space. Unlike Update,
+// conflicting values are resolved in favor of the ones that expire later
+// (see ir.MergeLatest), so merging the same updates in any order leads
+// to the same state. It is used to apply updates from other replicas.
+func (v *vm) Merge(ctx context.Context, writer peer.ID, k string, update xr.Dict, metadata ...meta.Metadata) (err error) {
+	ctx, span := trace.Start(ctx, "vm.Merge")
+	span.SetAttributes("key", k, "writer", writer.String())
+	defer func() { trace.End(span, err) }()
+
+	v.lk.Lock()
+	defer v.lk.Unlock()
+
+	asm := v.asm
+	asm.Merging = true
+	d, err := v.assemble(ctx, asm, writer, k, update, metadata...)
+	if err != nil {
+		return err
+	}
+
+	if v.keys[k] == nil {
+		v.keys[k] = &recordEntry{}
+	}
+	if old := (*v.keys[k])[writer]; old != nil {
+		_, mspan := trace.Start(ctx, "vm.merge")
+		ir.MergeLatest(v.updateCtx, old, d)
+		mspan.End()
+	} else {
+		(*v.keys[k])[writer] = d
+	}
+	v.updated(ctx, writer, k)
+	return nil
+}
+
+// updated calls the OnUpdate hooks of the smart tags in the dict of a writer.
+func (v *vm) updated(ctx context.Context, writer peer.ID, k string) {
+	_, span := trace.Start(ctx, "vm.onUpdate")
+	v.runHooks(v.hookContext(ctx, k, writer), (*v.keys[k])[writer], onUpdate)
+	span.End()
+	v.metrics.observeSize(k, writer, (*v.keys[k])[writer])
+}
+
+// assemble assembles an update with the assembler of the VM and
+// calls the OnAssemble hooks of the smart tags in it.
+func (v *vm) assemble(ctx context.Context, asm ir.AssemblerContext, writer peer.ID, k string, update xr.Dict, metadata ...meta.Metadata) (*ir.Dict, error) {
+	// Start assemble process with the assemblerContext given,
+	// tracing the assembler that matches each node.
+	_, span := trace.Start(ctx, "vm.assemble")
+	asm.Span = span
+	asm.Writer = writer
+	ds, err := asm.Grammar.Assemble(asm, update, metadata...)
+	if err != nil {
+		v.metrics.observeAssemblyFailure()
+		trace.End(span, err)
+		return nil, err
+	}
+
+	// Check if the result of the assembler is of type Dict
+	d, ok := ds.(*ir.Dict)
+	if !ok {
+		err := fmt.Errorf("assembler didn't generate a dict")
+		trace.End(span, err)
+		return nil, err
+	}
+	span.End()
+
+	// Trigger smart tags (e.g. reachability verifications).
+	_, span = trace.Start(ctx, "vm.trigger")
+	v.runHooks(v.hookContext(ctx, k, writer), d, onAssemble)
+	v.metrics.observeReachable(d)
+	span.End()
+	return d, nil
+}
+
+// nextSeq advances the sequence of a key and returns the next number,
+// or false if it reached the largest number that fits in an int64.
+// The VM must be locked.
+func (v *vm) nextSeq(k string) (uint64, bool) {
+	n := v.seqs[k]
+	if n >= math.MaxInt64 {
+		return 0, false
+	}
+	v.seqs[k] = n + 1
+	return n + 1, true
+}
+
+// Seq returns the last number of the sequence of a key assigned to seq() nodes.
+func (v *vm) Seq(k string) uint64 {
+	v.lk.RLock()
+	defer v.lk.RUnlock()
+	return v.seqs[k]
+}
+
+// AdvanceSeq advances the sequence of a key to a number assigned by a
+// replica, so numbers are not reused when writers update other replicas.
+// Numbers that don't fit in an int64 are rejected.
+func (v *vm) AdvanceSeq(k string, n uint64) error {
+	if n > math.MaxInt64 {
+		return fmt.Errorf("sequence number %d out of range", n)
+	}
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	if n > v.seqs[k] {
+		v.seqs[k] = n
+	}
+	return nil
+}
+
+// exists reports whether a writer stores a dict in a key.
+// The VM must be locked.
+func (v *vm) exists(k string, writer peer.ID) bool {
+	return v.keys[k] != nil && (*v.keys[k])[writer] != nil
 }
 
-// Close calls Process Close.
+// Close calls Process Close and unregisters the metrics of the VM.
 func (v *vm) Close() error {
+	v.metrics.unregister()
 	return v.proc.Close()
 }
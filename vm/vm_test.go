@@ -1,15 +1,23 @@
 package vm
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"testing"
 	"time"
 
+	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
 	p2ptestutil "github.com/libp2p/go-libp2p-netutil"
 	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	xr "github.com/libp2p/go-routing-language/syntax"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
 	"github.com/libp2p/go-smart-record/ir"
 	"github.com/libp2p/go-smart-record/ir/base"
 	meta "github.com/libp2p/go-smart-record/ir/metadata"
@@ -40,7 +48,7 @@ func TestEmptyUpdate(t *testing.T) {
 		},
 	}
 
-	err := vm.Update(p.ID(), k, in)
+	err := vm.Update(context.Background(), p.ID(), k, in)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -79,11 +87,11 @@ func TestExistingUpdate(t *testing.T) {
 		},
 	}
 
-	err := vm.Update(p.ID(), k, in1)
+	err := vm.Update(context.Background(), p.ID(), k, in1)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = vm.Update(p.ID(), k, in2)
+	err = vm.Update(context.Background(), p.ID(), k, in2)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,6 +101,39 @@ func TestExistingUpdate(t *testing.T) {
 	}
 }
 
+func TestFailedUpdate(t *testing.T) {
+	ctx := ir.DefaultUpdateContext{}
+	asmCtx := ir.AssemblerContext{Grammar: base.BaseGrammar}
+	h := setupHost(context.Background(), t)
+	vm, _ := NewVM(context.Background(), h, ctx, asmCtx, gcPeriodOpt)
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+
+	in1 := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "fff"}, Value: xr.String{Value: "ff2"}},
+		},
+	}
+	in2 := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "fff"}, Value: xr.NewInt64(2)},
+		},
+	}
+
+	err := vm.Update(context.Background(), p.ID(), k, in1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Updates that can't be applied to the stored record fail.
+	err = vm.Update(context.Background(), p.ID(), k, in2)
+	if err == nil {
+		t.Fatal("Update with a different type should fail")
+	}
+	out := vm.Get(k)
+	if !xr.IsEqual(in1, *out[p.ID()]) {
+		t.Fatal("Record updated with a different type", in1, out)
+	}
+}
+
 func TestSeveralPeers(t *testing.T) {
 	ctx := ir.DefaultUpdateContext{}
 	asmCtx := ir.AssemblerContext{Grammar: base.BaseGrammar}
@@ -118,11 +159,11 @@ func TestSeveralPeers(t *testing.T) {
 		},
 	}
 
-	err := vm.Update(p1.ID(), k, in1)
+	err := vm.Update(context.Background(), p1.ID(), k, in1)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = vm.Update(p2.ID(), k, in2)
+	err = vm.Update(context.Background(), p2.ID(), k, in2)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -130,7 +171,7 @@ func TestSeveralPeers(t *testing.T) {
 	if !xr.IsEqual(in1, *out[p1.ID()]) || !xr.IsEqual(in2, *out[p2.ID()]) {
 		t.Fatal("Record not updated in existing key", in1, in2, out)
 	}
-	err = vm.Update(p2.ID(), k, in1)
+	err = vm.Update(context.Background(), p2.ID(), k, in1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -160,17 +201,17 @@ func TestGcProcess(t *testing.T) {
 	}
 
 	// Small expiration for in1
-	err := vm.Update(p.ID(), k, in1, []meta.Metadata{meta.TTL(1 * time.Second)}...)
+	err := vm.Update(context.Background(), p.ID(), k, in1, []meta.Metadata{meta.TTL(1 * time.Second)}...)
 	if err != nil {
 		t.Fatal(err)
 	}
 	// Add it also in other peer
-	err = vm.Update(p2.ID(), k, in1, []meta.Metadata{meta.TTL(1 * time.Second)}...)
+	err = vm.Update(context.Background(), p2.ID(), k, in1, []meta.Metadata{meta.TTL(1 * time.Second)}...)
 	if err != nil {
 		t.Fatal(err)
 	}
 	// Large expiration for in2
-	err = vm.Update(p.ID(), k, in2, []meta.Metadata{meta.TTL(3000 * time.Second)}...)
+	err = vm.Update(context.Background(), p.ID(), k, in2, []meta.Metadata{meta.TTL(3000 * time.Second)}...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -202,7 +243,7 @@ func TestGcFullDict(t *testing.T) {
 		t.Fatal(err)
 	}
 	time.Sleep(2 * time.Second)
-	if g := gcNode(ds); !g {
+	if g := gcNode(ds, nil); !g {
 		t.Fatal("Dict should have been garbage collected", g, ds)
 	}
 }
@@ -236,7 +277,7 @@ func TestGcPartialDict(t *testing.T) {
 		t.Fatal(err)
 	}
 	time.Sleep(2 * time.Second)
-	g := gcNode(ds1)
+	g := gcNode(ds1, nil)
 	if g {
 		t.Fatal("Dict should not have been garbage collected", g, ds1)
 	}
@@ -245,3 +286,430 @@ func TestGcPartialDict(t *testing.T) {
 	}
 
 }
+
+func TestGcLiveKey(t *testing.T) {
+	// Plain dicts are kept along with the key they are the value of.
+	key, err := ir.SyntacticGrammar.Assemble(ir.AssemblerContext{Grammar: ir.SyntacticGrammar},
+		xr.String{Value: "d"}, meta.TTL(3000*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := ir.SyntacticGrammar.Assemble(ir.AssemblerContext{Grammar: ir.SyntacticGrammar},
+		xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "a"}, Value: xr.String{Value: "b"}}}}, meta.TTL(1*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := ir.SyntacticGrammar.Assemble(ir.AssemblerContext{Grammar: ir.SyntacticGrammar}, xr.Dict{}, meta.TTL(1*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.(*ir.Dict).Pairs = ir.Pairs{ir.Pair{Key: key, Value: value}}
+	before := d.Disassemble()
+	time.Sleep(2 * time.Second)
+	if gcNode(d, nil) {
+		t.Fatal("Dict with a live key should not have been garbage collected", d)
+	}
+	if !xr.IsEqual(d.Disassemble(), before) {
+		t.Fatal("value of a live key garbage collected", d.Disassemble())
+	}
+}
+
+func TestGcLogEntries(t *testing.T) {
+	ctx := ir.DefaultUpdateContext{}
+	asmCtx := ir.AssemblerContext{Grammar: base.BaseGrammar}
+	h := setupHost(context.Background(), t)
+	vm1, _ := newVM(context.Background(), h, ctx, asmCtx, gcPeriodOpt)
+	vm2, _ := newVM(context.Background(), h, ctx, asmCtx, gcPeriodOpt)
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+
+	appendLog := func(v string) xr.Dict {
+		return xr.Dict{Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "log"}, Value: xr.Predicate{Tag: "appendLog", Positional: xr.Nodes{xr.String{Value: v}}}},
+		}}
+	}
+	if err := vm1.Update(context.Background(), p.ID(), k, appendLog("a"), meta.TTL(1*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm1.Update(context.Background(), p.ID(), k, appendLog("b"), meta.TTL(3000*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries are fragmented by expiration and merged back in any order.
+	snap := vm1.Snapshot(k)
+	if len(snap[p.ID()]) != 2 {
+		t.Fatal("wrong number of fragments", snap[p.ID()])
+	}
+	for i := len(snap[p.ID()]) - 1; i >= 0; i-- {
+		f := snap[p.ID()][i]
+		if err := vm2.Merge(context.Background(), p.ID(), k, f.Dict, meta.Expiration(f.Expiration)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out1, out2 := vm1.Get(k), vm2.Get(k)
+	if !xr.IsEqual(*out1[p.ID()], *out2[p.ID()]) {
+		t.Fatal("log not merged successfully", *out1[p.ID()], *out2[p.ID()])
+	}
+
+	// Only the entry expired is garbage collected.
+	time.Sleep(2 * time.Second)
+	for _, v := range []*vm{vm1, vm2} {
+		v.lk.Lock()
+		v.garbageCollect()
+		v.lk.Unlock()
+		n, err := ir.AssemblerContext{Grammar: base.BaseGrammar, Merging: true}.Assemble((*v.Get(k)[p.ID()]).Get(xr.String{Value: "log"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs, _ := n.(*base.Log).Entries()
+		if len(seqs) != 1 || seqs[0] != 2 {
+			t.Fatal("log entries not garbage collected", seqs)
+		}
+	}
+}
+
+func TestSnapshotMerge(t *testing.T) {
+	ctx := ir.DefaultUpdateContext{}
+	asmCtx := ir.AssemblerContext{Grammar: base.BaseGrammar}
+	h := setupHost(context.Background(), t)
+	vm1, _ := NewVM(context.Background(), h, ctx, asmCtx, gcPeriodOpt)
+	vm2, _ := NewVM(context.Background(), h, ctx, asmCtx, gcPeriodOpt)
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+
+	in1 := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "fff"}, Value: xr.String{Value: "ff2"}},
+			xr.Pair{Key: xr.String{Value: "list"}, Value: xr.List{Elements: xr.Nodes{xr.NewInt64(1)}}},
+		},
+	}
+	in2 := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "asdf"}, Value: xr.String{Value: "asfd"}},
+			xr.Pair{Key: xr.String{Value: "list"}, Value: xr.List{Elements: xr.Nodes{xr.NewInt64(2)}}},
+		},
+	}
+	if err := vm1.Update(context.Background(), p.ID(), k, in1, meta.TTL(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm1.Update(context.Background(), p.ID(), k, in2, meta.TTL(3000*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := vm1.Keys(); len(keys) != 1 || keys[0] != k {
+		t.Fatal("wrong keys in VM", keys)
+	}
+	snap := vm1.Snapshot(k)
+	if len(snap[p.ID()]) != 2 {
+		t.Fatal("wrong number of fragments", snap[p.ID()])
+	}
+	// Merge fragments in reverse order to check that it converges.
+	for i := len(snap[p.ID()]) - 1; i >= 0; i-- {
+		f := snap[p.ID()][i]
+		if err := vm2.Merge(context.Background(), p.ID(), k, f.Dict, meta.Expiration(f.Expiration)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out1, out2 := vm1.Get(k), vm2.Get(k)
+	if !xr.IsEqual(*out1[p.ID()], *out2[p.ID()]) {
+		t.Fatal("snapshot not merged successfully", *out1[p.ID()], *out2[p.ID()])
+	}
+	// Merging the snapshot again doesn't change the state.
+	for _, f := range vm2.Snapshot(k)[p.ID()] {
+		if err := vm1.Merge(context.Background(), p.ID(), k, f.Dict, meta.Expiration(f.Expiration)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out := vm1.Get(k); !xr.IsEqual(*out1[p.ID()], *out[p.ID()]) {
+		t.Fatal("merging the same state changed the record", *out1[p.ID()], *out[p.ID()])
+	}
+}
+
+func TestDigest(t *testing.T) {
+	ctx := ir.DefaultUpdateContext{}
+	asmCtx := ir.AssemblerContext{Grammar: base.BaseGrammar}
+	h := setupHost(context.Background(), t)
+	vm1, _ := NewVM(context.Background(), h, ctx, asmCtx, gcPeriodOpt)
+	vm2, _ := NewVM(context.Background(), h, ctx, asmCtx, gcPeriodOpt)
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+
+	in1 := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "fff"}, Value: xr.String{Value: "ff2"}},
+			xr.Pair{Key: xr.String{Value: "list"}, Value: xr.List{Elements: xr.Nodes{xr.NewInt64(1)}}},
+		},
+	}
+	in2 := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "asdf"}, Value: xr.String{Value: "asfd"}},
+			xr.Pair{Key: xr.String{Value: "list"}, Value: xr.List{Elements: xr.Nodes{xr.NewInt64(2)}}},
+		},
+	}
+	exp := uint64(time.Now().Unix()) + 3000
+	// Same updates in different order.
+	if err := vm1.Update(context.Background(), p.ID(), k, in1, meta.Expiration(exp)); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm1.Update(context.Background(), p.ID(), k, in2, meta.Expiration(exp)); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm2.Update(context.Background(), p.ID(), k, in2, meta.Expiration(exp)); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm2.Update(context.Background(), p.ID(), k, in1, meta.Expiration(exp)); err != nil {
+		t.Fatal(err)
+	}
+
+	d1, err := vm1.Digest(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, err := vm2.Digest(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d1[p.ID()].Hash, d2[p.ID()].Hash) || d1[p.ID()].Expiration != exp {
+		t.Fatal("digests of the same dict are different", d1, d2)
+	}
+
+	// A different expiration changes the digest.
+	if err := vm2.Update(context.Background(), p.ID(), k, in1, meta.Expiration(exp+1)); err != nil {
+		t.Fatal(err)
+	}
+	d2, err = vm2.Digest(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(d1[p.ID()].Hash, d2[p.ID()].Hash) || d2[p.ID()].Expiration != exp+1 {
+		t.Fatal("digests of different dicts are equal", d1, d2)
+	}
+}
+
+func TestSnapshotMeasured(t *testing.T) {
+	h := setupHost(context.Background(), t)
+	v, err := newVM(context.Background(), h, ir.DefaultUpdateContext{}, base.BaseTags.AssemblerContext(), gcPeriodOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+
+	probe := xr.Predicate{
+		Tag: "probe",
+		Named: xr.Pairs{xr.Pair{
+			Key:   xr.String{Value: "address"},
+			Value: xr.String{Value: h.Addrs()[0].String() + "/p2p/" + h.ID().String()},
+		}},
+	}
+	in := xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "p"}, Value: probe}}}
+	if err := v.Update(context.Background(), p.ID(), k, in, meta.TTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if tag := v.Get(k)[p.ID()].Get(xr.String{Value: "p"}).(xr.Predicate).Tag; tag != "probed" {
+		t.Fatal("peer not probed", tag)
+	}
+	// The values measured are not in the snapshot.
+	frags := v.Snapshot(k)[p.ID()]
+	if n := frags[len(frags)-1].Dict.Get(xr.String{Value: "p"}); !xr.IsEqual(n, probe) {
+		t.Fatal("measured values in the snapshot", n)
+	}
+}
+
+// gatheredValue returns the value of a gauge, or the count of a
+// histogram, gathered from a registry.
+func gatheredValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		m := mf.GetMetric()[0]
+		if h := m.GetHistogram(); h != nil {
+			return float64(h.GetSampleCount())
+		}
+		return m.GetGauge().GetValue()
+	}
+	t.Fatalf("metric %s not gathered", name)
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	h := setupHost(context.Background(), t)
+	reg := prometheus.NewRegistry()
+	v, err := newVM(context.Background(), h, ir.DefaultUpdateContext{},
+		ir.AssemblerContext{Grammar: base.BaseGrammar}, gcPeriodOpt, Metrics(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+	p2, _ := p2ptestutil.RandTestBogusIdentity()
+
+	in1 := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "fff"}, Value: xr.String{Value: "ff2"}},
+		},
+	}
+	// The VM host is always connected to itself.
+	in2 := xr.Dict{
+		Pairs: xr.Pairs{
+			xr.Pair{Key: xr.String{Value: "addr"}, Value: xr.Predicate{
+				Tag: "connectivity",
+				Named: xr.Pairs{xr.Pair{
+					Key:   xr.String{Value: "address"},
+					Value: xr.String{Value: "/ip4/127.0.0.1/tcp/4001/p2p/" + h.ID().String()},
+				}},
+			}},
+		},
+	}
+	if err := v.Update(context.Background(), p.ID(), k, in1, meta.TTL(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Update(context.Background(), p2.ID(), k, in1, meta.TTL(3000*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Update(context.Background(), p2.ID(), "235", in2, meta.TTL(3000*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := gatheredValue(t, reg, "smart_record_vm_keys"); got != 2 {
+		t.Fatal("wrong number of keys", got)
+	}
+	if got := gatheredValue(t, reg, "smart_record_vm_writers_per_key"); got != 2 {
+		t.Fatal("wrong number of keys observed", got)
+	}
+	if got := gatheredValue(t, reg, "smart_record_vm_stored_bytes"); got <= 0 {
+		t.Fatal("stored bytes not reported", got)
+	}
+	if got := testutil.ToFloat64(v.metrics.reachableOutcomes.WithLabelValues("connected")); got != 1 {
+		t.Fatal("reachable check not reported", got)
+	}
+
+	// The dict of p is evicted: the dict and its key and value.
+	time.Sleep(3 * time.Second)
+	if got := testutil.ToFloat64(v.metrics.gcRuns); got < 1 {
+		t.Fatal("gc runs not reported", got)
+	}
+	if got := testutil.ToFloat64(v.metrics.gcEvicted); got != 3 {
+		t.Fatal("wrong number of nodes evicted", got)
+	}
+	// Only the dicts of p2 are left, measured when they were updated.
+	size := 0
+	v.lk.RLock()
+	for _, key := range []string{k, "235"} {
+		b, err := marshalNodeCBOR((*v.keys[key])[p2.ID()].Disassemble())
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += len(b)
+	}
+	v.lk.RUnlock()
+	if got := gatheredValue(t, reg, "smart_record_vm_stored_bytes"); got != float64(size) {
+		t.Fatal("wrong stored bytes after gc", got, size)
+	}
+
+	// A registry can only be used by one VM.
+	if _, err := newVM(context.Background(), h, ir.DefaultUpdateContext{},
+		ir.AssemblerContext{Grammar: base.BaseGrammar}, Metrics(reg)); err == nil {
+		t.Fatal("registering metrics twice should fail")
+	}
+
+	// The metrics registered before one fails are unregistered.
+	reg = prometheus.NewRegistry()
+	keys := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "smart_record_vm_keys", Help: "Number of keys with records stored.",
+	})
+	reg.MustRegister(keys)
+	if _, err := newVM(context.Background(), h, ir.DefaultUpdateContext{},
+		ir.AssemblerContext{Grammar: base.BaseGrammar}, Metrics(reg)); err == nil {
+		t.Fatal("registering conflicting metrics should fail")
+	}
+	reg.Unregister(keys)
+	v, err = newVM(context.Background(), h, ir.DefaultUpdateContext{},
+		ir.AssemblerContext{Grammar: base.BaseGrammar}, Metrics(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Close()
+
+	// A grammar that only accepts smart tags fails to assemble dicts.
+	v, err = newVM(context.Background(), h, ir.DefaultUpdateContext{},
+		ir.AssemblerContext{Grammar: base.ReachableAssembler{}}, Metrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Update(context.Background(), p.ID(), k, in1); err == nil {
+		t.Fatal("update that doesn't assemble should fail")
+	}
+	if got := testutil.ToFloat64(v.metrics.assemblyFailures); got != 1 {
+		t.Fatal("assembly failure not reported", got)
+	}
+}
+
+func TestAssembleWithWriter(t *testing.T) {
+	h := setupHost(context.Background(), t)
+	v, err := newVM(context.Background(), h, ir.DefaultUpdateContext{}, base.BaseTags.AssemblerContext(), gcPeriodOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	priv, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	id, _ := peer.IDFromPrivateKey(priv)
+	other, _ := p2ptestutil.RandTestBogusIdentity()
+
+	rec := peer.NewPeerRecord()
+	rec.PeerID = id
+	env, err := record.Seal(rec, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := env.Marshal()
+	in := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "pr"}, Value: xr.Predicate{
+			Tag:   "peerRecord",
+			Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "envelope"}, Value: xr.Bytes{Bytes: b}}},
+		}},
+	}}
+	// Only the peer of a peer record can write it.
+	if err := v.Update(context.Background(), other.ID(), k, in); err == nil {
+		t.Fatal("peer record written by another peer should fail")
+	}
+	if err := v.Merge(context.Background(), other.ID(), k, in); err == nil {
+		t.Fatal("peer record merged by another peer should fail")
+	}
+	if err := v.Update(context.Background(), id, k, in); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateRef(t *testing.T) {
+	h := setupHost(context.Background(), t)
+	v, err := newVM(context.Background(), h, ir.DefaultUpdateContext{}, base.BaseTags.AssemblerContext(), gcPeriodOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	p, _ := p2ptestutil.RandTestBogusIdentity()
+
+	in := xr.Dict{Pairs: xr.Pairs{
+		xr.Pair{Key: xr.String{Value: "profile"}, Value: xr.Predicate{
+			Tag:   "ref",
+			Named: xr.Pairs{xr.Pair{Key: xr.String{Value: "key"}, Value: xr.String{Value: "profiles"}}},
+		}},
+	}}
+	// Writers can only reference records that exist.
+	if err := v.Update(context.Background(), p.ID(), k, in, meta.TTL(10*time.Second)); err == nil {
+		t.Fatal("reference to a missing record should fail")
+	}
+	// Replicas may merge references before the records referenced.
+	if err := v.Merge(context.Background(), p.ID(), k, in, meta.TTL(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	profile := xr.Dict{Pairs: xr.Pairs{xr.Pair{Key: xr.String{Value: "name"}, Value: xr.String{Value: "alice"}}}}
+	if err := v.Update(context.Background(), p.ID(), "profiles", profile, meta.TTL(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Update(context.Background(), p.ID(), k, in, meta.TTL(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+}